@@ -287,3 +287,174 @@ func TestMkEntriesMatchedContainer(t *testing.T) {
 	makeVar := entry.EntryMap["LOCAL_ACONFIG_FILES"]
 	android.EnsureListContainsSuffix(t, makeVar, "my_aconfig_declarations_foo/intermediate.pb")
 }
+
+func TestExcludeAconfigFiles(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithAconfigBuildComponents,
+		java.PrepareForTestWithJavaDefaultModules).
+		ExtendWithErrorHandler(android.FixtureExpectsNoErrors).
+		RunTestWithBp(t, `
+			aconfig_declarations {
+				name: "my_aconfig_declarations_foo",
+				package: "com.example.package.foo",
+				container: "system",
+				srcs: ["foo.aconfig"],
+			}
+
+			java_aconfig_library {
+				name: "my_java_aconfig_library_foo",
+				aconfig_declarations: "my_aconfig_declarations_foo",
+			}
+
+			aconfig_declarations {
+				name: "my_aconfig_declarations_bar",
+				package: "com.example.package.bar",
+				container: "system",
+				srcs: ["bar.aconfig"],
+			}
+
+			java_aconfig_library {
+				name: "my_java_aconfig_library_bar",
+				aconfig_declarations: "my_aconfig_declarations_bar",
+			}
+
+			java_library {
+				name: "my_lib",
+				srcs: [
+					"src/foo.java",
+				],
+				static_libs: [
+					"my_java_aconfig_library_foo",
+					"my_java_aconfig_library_bar",
+				],
+				exclude_aconfig_files: ["my_java_aconfig_library_bar"],
+				platform_apis: true,
+			}
+
+			java_library {
+				name: "my_module",
+				srcs: [
+					"src/bar.java",
+				],
+				static_libs: ["my_lib"],
+				platform_apis: true,
+			}
+		`)
+
+	module := result.ModuleForTests("my_module", "android_common").Module()
+	javaInfo, _ := android.SingletonModuleProvider(result, module, java.JavaInfoProvider)
+	cacheFiles := android.PathsRelativeToTop(javaInfo.AconfigIntermediateCacheOutputPaths)
+	android.AssertStringListContains(t, "included aconfig file", cacheFiles,
+		"out/soong/.intermediates/my_aconfig_declarations_foo/intermediate.pb")
+	android.AssertStringListDoesNotContain(t, "excluded aconfig file", cacheFiles,
+		"out/soong/.intermediates/my_aconfig_declarations_bar/intermediate.pb")
+
+	// All of the cache files are named intermediate.pb, so only module names are accepted.
+	android.GroupFixturePreparers(
+		PrepareForTestWithAconfigBuildComponents,
+		java.PrepareForTestWithJavaDefaultModules).
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`exclude_aconfig_files: "intermediate.pb" is not a libs or static_libs dependency`)).
+		RunTestWithBp(t, `
+			aconfig_declarations {
+				name: "my_aconfig_declarations_foo",
+				package: "com.example.package.foo",
+				container: "system",
+				srcs: ["foo.aconfig"],
+			}
+
+			java_aconfig_library {
+				name: "my_java_aconfig_library_foo",
+				aconfig_declarations: "my_aconfig_declarations_foo",
+			}
+
+			java_library {
+				name: "my_lib",
+				srcs: [
+					"src/foo.java",
+				],
+				static_libs: ["my_java_aconfig_library_foo"],
+				exclude_aconfig_files: ["intermediate.pb"],
+				platform_apis: true,
+			}
+		`)
+}
+
+func TestTransitiveAconfigFilesForModule(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithAconfigBuildComponents,
+		java.PrepareForTestWithJavaDefaultModules).
+		ExtendWithErrorHandler(android.FixtureExpectsNoErrors).
+		RunTestWithBp(t, `
+			aconfig_declarations {
+				name: "my_aconfig_declarations_foo",
+				package: "com.example.package.foo",
+				container: "system",
+				srcs: ["foo.aconfig"],
+			}
+
+			java_aconfig_library {
+				name: "my_java_aconfig_library_foo",
+				aconfig_declarations: "my_aconfig_declarations_foo",
+			}
+
+			aconfig_declarations {
+				name: "my_aconfig_declarations_bar",
+				package: "com.example.package.bar",
+				container: "system",
+				srcs: ["bar.aconfig"],
+			}
+
+			java_aconfig_library {
+				name: "my_java_aconfig_library_bar",
+				aconfig_declarations: "my_aconfig_declarations_bar",
+			}
+
+			java_library {
+				name: "my_static_lib",
+				srcs: [
+					"src/foo.java",
+				],
+				static_libs: ["my_java_aconfig_library_foo"],
+				platform_apis: true,
+			}
+
+			java_library {
+				name: "my_lib",
+				srcs: [
+					"src/foo.java",
+				],
+				libs: ["my_java_aconfig_library_bar"],
+				platform_apis: true,
+			}
+
+			java_library {
+				name: "my_module",
+				srcs: [
+					"src/bar.java",
+				],
+				libs: ["my_lib"],
+				static_libs: ["my_static_lib"],
+				platform_apis: true,
+			}
+		`)
+
+	ctx := result.TestContext.OtherModuleProviderAdaptor()
+
+	module := result.ModuleForTests("my_module", "android_common").Module()
+	android.AssertPathsRelativeToTopEquals(t, "transitive aconfig files", []string{
+		"out/soong/.intermediates/my_aconfig_declarations_bar/intermediate.pb",
+		"out/soong/.intermediates/my_aconfig_declarations_foo/intermediate.pb",
+	}, java.TransitiveAconfigFilesForModule(ctx, module).ToList())
+
+	// The aconfig files of libs dependencies are not statically linked into the module.
+	javaInfo, _ := android.SingletonModuleProvider(result, module, java.JavaInfoProvider)
+	android.AssertPathsRelativeToTopEquals(t, "aconfig cache files", []string{
+		"out/soong/.intermediates/my_aconfig_declarations_foo/intermediate.pb",
+	}, javaInfo.AconfigIntermediateCacheOutputPaths)
+
+	declarations := result.ModuleForTests("my_aconfig_declarations_foo", "").Module()
+	if files := java.TransitiveAconfigFilesForModule(ctx, declarations); files != nil {
+		t.Errorf("expected no transitive aconfig files for a non-java module, got %v", files.ToList())
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2021 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// BuildRelease represents the version of a build system used to create a specific release.
+//
+// The name of the release, is the same as the code for the dessert release, e.g. S, Tiramisu, etc.
+type BuildRelease struct {
+	// The name of the release, e.g. S, Tiramisu, etc.
+	name string
+
+	// The index of this structure within the dessertBuildReleases list.
+	//
+	// The BuildReleaseCurrent does not appear in the dessertBuildReleases list as it has an ordinal
+	// value that is larger than the size of the dessertBuildReleases.
+	ordinal int
+}
+
+func (br *BuildRelease) EarlierThan(other *BuildRelease) bool {
+	return br.ordinal < other.ordinal
+}
+
+// String returns the name of the build release.
+func (br *BuildRelease) String() string {
+	return br.name
+}
+
+// BuildReleaseSet represents a set of BuildRelease objects.
+type BuildReleaseSet struct {
+	// Set of *BuildRelease represented as a map from *BuildRelease to struct{}.
+	contents map[*BuildRelease]struct{}
+}
+
+// addItem adds a build release to the set.
+func (s *BuildReleaseSet) addItem(release *BuildRelease) {
+	s.contents[release] = struct{}{}
+}
+
+// addRange adds all the build releases from start (inclusive) to end (inclusive).
+func (s *BuildReleaseSet) addRange(start *BuildRelease, end *BuildRelease) {
+	for i := start.ordinal; i <= end.ordinal; i += 1 {
+		s.addItem(dessertBuildReleases[i])
+	}
+}
+
+// Contains returns true if the set contains the specified build release.
+func (s *BuildReleaseSet) Contains(release *BuildRelease) bool {
+	_, ok := s.contents[release]
+	return ok
+}
+
+// String returns a string representation of the set, sorted from earliest to latest release.
+func (s *BuildReleaseSet) String() string {
+	list := []string{}
+	addRelease := func(release *BuildRelease) {
+		if _, ok := s.contents[release]; ok {
+			list = append(list, release.name)
+		}
+	}
+	// Add the names of the build releases in this set in the order in which they were created.
+	for _, release := range dessertBuildReleases {
+		addRelease(release)
+	}
+	// Always add "current" to the list of names last if it is present in the set.
+	addRelease(BuildReleaseCurrent)
+	return fmt.Sprintf("[%s]", strings.Join(list, ","))
+}
+
+var (
+	// nameToBuildRelease contains a map from name to build release.
+	nameToBuildRelease = map[string]*BuildRelease{}
+
+	// dessertBuildReleases lists all the available dessert build releases, i.e. excluding current.
+	dessertBuildReleases = []*BuildRelease{}
+
+	// allBuildReleaseSet is the set of all build releases.
+	allBuildReleaseSet = &BuildReleaseSet{contents: map[*BuildRelease]struct{}{}}
+
+	// Add the dessert build releases from oldest to newest.
+	BuildReleaseS = InitBuildRelease("S")
+	BuildReleaseT = InitBuildRelease("Tiramisu")
+	BuildReleaseU = InitBuildRelease("UpsideDownCake")
+
+	// Add the current build release which is always treated as being more recent than any other
+	// build release, including those added in tests.
+	BuildReleaseCurrent = InitBuildRelease("current")
+)
+
+// InitBuildRelease creates a new build release with the specified name.
+func InitBuildRelease(name string) *BuildRelease {
+	ordinal := len(dessertBuildReleases)
+	if name == "current" {
+		// The current build release is more recent than all other build releases, including those
+		// created in tests so use the max int value. It cannot just rely on being created after all
+		// the other build releases as some are created in tests which run after the current build
+		// release has been created.
+		ordinal = math.MaxInt
+	}
+	release := &BuildRelease{name: name, ordinal: ordinal}
+	nameToBuildRelease[name] = release
+	allBuildReleaseSet.addItem(release)
+	if name != "current" {
+		// As the current build release has an ordinal value that does not correspond to its position
+		// in the dessertBuildReleases list do not add it to the list.
+		dessertBuildReleases = append(dessertBuildReleases, release)
+	}
+	return release
+}
+
+// AllBuildReleaseSet returns the set of all build releases, including those added in tests.
+func AllBuildReleaseSet() *BuildReleaseSet {
+	return allBuildReleaseSet
+}
+
+// latestDessertBuildRelease returns the latest dessert release build name, i.e. the last dessert
+// release added to the list, which does not include current.
+func latestDessertBuildRelease() *BuildRelease {
+	return dessertBuildReleases[len(dessertBuildReleases)-1]
+}
+
+// NameToBuildRelease maps from build release name to the corresponding build release (if it
+// exists) or the error if it does not.
+func NameToBuildRelease(name string) (*BuildRelease, error) {
+	if r, ok := nameToBuildRelease[name]; ok {
+		return r, nil
+	}
+
+	return nil, fmt.Errorf("unknown release %q, expected one of %s", name, allBuildReleaseSet)
+}
+
+// ParseBuildReleaseSet parses a build release set string specification into a build release set.
+//
+// The specification consists of one of the following:
+// * a single build release name, e.g. S, T, etc.
+// * a closed range (inclusive to inclusive), e.g. S-T
+// * an open range, e.g. T+.
+//
+// This returns the set if the specification was valid or an error.
+func ParseBuildReleaseSet(specification string) (*BuildReleaseSet, error) {
+	set := &BuildReleaseSet{contents: map[*BuildRelease]struct{}{}}
+
+	if strings.HasSuffix(specification, "+") {
+		rangeStart := strings.TrimSuffix(specification, "+")
+		start, err := NameToBuildRelease(rangeStart)
+		if err != nil {
+			return nil, err
+		}
+		end := latestDessertBuildRelease()
+		set.addRange(start, end)
+		// An open-ended range always includes the current release.
+		set.addItem(BuildReleaseCurrent)
+	} else if strings.Contains(specification, "-") {
+		limits := strings.SplitN(specification, "-", 2)
+		start, err := NameToBuildRelease(limits[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := NameToBuildRelease(limits[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if start.ordinal > end.ordinal {
+			return nil, fmt.Errorf("invalid closed range, start release %q is later than end release %q", start.name, end.name)
+		}
+
+		set.addRange(start, end)
+	} else {
+		release, err := NameToBuildRelease(specification)
+		if err != nil {
+			return nil, err
+		}
+		set.addItem(release)
+	}
+
+	return set, nil
+}
+
+// BuildReleaseForConfig returns the build release of the platform being built.  That is the
+// release named by the platform sdk codename for a platform that is not final, or the latest
+// release at or before the platform sdk version for a final platform.
+func BuildReleaseForConfig(config Config) *BuildRelease {
+	if !config.PlatformSdkFinal() {
+		if release, ok := nameToBuildRelease[config.PlatformSdkCodename()]; ok {
+			return release
+		}
+		return BuildReleaseCurrent
+	}
+
+	release := BuildReleaseCurrent
+	for _, r := range dessertBuildReleases {
+		apiLevel, err := ApiLevelFromUserWithConfig(config, r.name)
+		if err != nil {
+			// Releases that are only added in tests may not have an api level.
+			continue
+		}
+		if apiLevel.LessThanOrEqualTo(config.PlatformSdkVersion()) {
+			release = r
+		}
+	}
+	return release
+}
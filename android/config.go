@@ -1095,6 +1095,12 @@ func (c *config) MinimizeJavaDebugInfo() bool {
 	return Bool(c.productVariables.MinimizeJavaDebugInfo) && !Bool(c.productVariables.Eng)
 }
 
+// JavaHostBinaryWrapper returns the source path of the wrapper script used by host java binaries
+// that don't set the wrapper property, or an empty string to use the default wrapper.
+func (c *config) JavaHostBinaryWrapper() string {
+	return String(c.productVariables.JavaHostBinaryWrapper)
+}
+
 func (c *config) Debuggable() bool {
 	return Bool(c.productVariables.Debuggable)
 }
@@ -1181,6 +1187,13 @@ func (c *config) RunErrorProne() bool {
 	return c.IsEnvTrue("RUN_ERROR_PRONE")
 }
 
+// SeparateJarOutputDirs returns true if java modules should place their header jars under headers/
+// and their implementation jars under impl/ in their output directories, so that the two can be
+// cached separately.
+func (c *config) SeparateJarOutputDirs() bool {
+	return c.IsEnvTrue("SOONG_SEPARATE_JAR_OUTPUT_DIRS")
+}
+
 // XrefCorpusName returns the Kythe cross-reference corpus name.
 func (c *config) XrefCorpusName() string {
 	return c.Getenv("XREF_CORPUS")
@@ -39,6 +39,14 @@ func addPhony(config Config, name string, deps ...Path) {
 	phonyMap[name] = append(phonyMap[name], deps...)
 }
 
+// PhonyDepsForTests returns the paths added to the phony target name so far.
+func PhonyDepsForTests(config Config, name string) Paths {
+	phonyMap := getPhonyMap(config)
+	phonyMapLock.Lock()
+	defer phonyMapLock.Unlock()
+	return CopyOfPaths(phonyMap[name])
+}
+
 type phonySingleton struct {
 	phonyMap  phonyMap
 	phonyList []string
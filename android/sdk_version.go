@@ -302,7 +302,7 @@ func SdkSpecFromWithConfig(config Config, str string) SdkSpec {
 			kind = SdkTest
 		case "test_frameworks_core":
 			kind = SdkTestFrameworksCore
-		case "module":
+		case "module", "module-lib":
 			kind = SdkModule
 		case "system_server":
 			kind = SdkSystemServer
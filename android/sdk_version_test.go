@@ -55,6 +55,14 @@ func TestSdkSpecFrom(t *testing.T) {
 			input:    "module_current",
 			expected: "module-lib_current",
 		},
+		{
+			input:    "module-lib_current",
+			expected: "module-lib_current",
+		},
+		{
+			input:    "module_30",
+			expected: "module-lib_30",
+		},
 		{
 			input:    "31",
 			expected: "public_31",
@@ -353,6 +353,8 @@ type ProductVariables struct {
 	JavaCoveragePaths        []string `json:",omitempty"`
 	JavaCoverageExcludePaths []string `json:",omitempty"`
 
+	JavaHostBinaryWrapper *string `json:",omitempty"`
+
 	GcovCoverage                *bool    `json:",omitempty"`
 	ClangCoverage               *bool    `json:",omitempty"`
 	NativeCoveragePaths         []string `json:",omitempty"`
@@ -0,0 +1,123 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// check_dex_checksum checks the header of every classes*.dex file in a jar: the magic, the file
+// size, the adler32 checksum and the SHA-1 signature.  It does not verify the bytecode of the
+// classes.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+var inputFile = flag.String("i", "", "input jar")
+
+const (
+	// Offsets in the dex file header.
+	checksumOffset  = 8
+	signatureOffset = 12
+	fileSizeOffset  = 32
+	headerSize      = 0x70
+)
+
+var dexMagic = []byte("dex\n")
+
+// checkDex returns an error if the header of the dex file doesn't match its contents.
+func checkDex(dex []byte) error {
+	if len(dex) < headerSize {
+		return fmt.Errorf("truncated dex file of %d bytes", len(dex))
+	}
+	if !bytes.Equal(dex[:len(dexMagic)], dexMagic) || dex[7] != 0 {
+		return fmt.Errorf("bad dex magic %q", dex[:8])
+	}
+	if size := binary.LittleEndian.Uint32(dex[fileSizeOffset:]); int(size) != len(dex) {
+		return fmt.Errorf("file_size is %d but the file has %d bytes", size, len(dex))
+	}
+	if checksum := adler32.Checksum(dex[signatureOffset:]); checksum != binary.LittleEndian.Uint32(dex[checksumOffset:]) {
+		return fmt.Errorf("checksum is %#08x but the file has checksum %#08x",
+			binary.LittleEndian.Uint32(dex[checksumOffset:]), checksum)
+	}
+	if signature := sha1.Sum(dex[fileSizeOffset:]); !bytes.Equal(signature[:], dex[signatureOffset:fileSizeOffset]) {
+		return fmt.Errorf("signature is %x but the file has signature %x",
+			dex[signatureOffset:fileSizeOffset], signature)
+	}
+	return nil
+}
+
+// checkJar checks every dex file in the jar, returning an error listing the dex files that fail.
+func checkJar(reader *zip.Reader) error {
+	var failures []string
+	dexFiles := 0
+	for _, f := range reader.File {
+		if strings.Contains(f.Name, "/") || path.Ext(f.Name) != ".dex" || !strings.HasPrefix(f.Name, "classes") {
+			continue
+		}
+		dexFiles++
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		dex, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if err := checkDex(dex); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", f.Name, err))
+		}
+	}
+	if dexFiles == 0 {
+		return fmt.Errorf("no classes*.dex file found")
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: check_dex_checksum -i <input jar>")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *inputFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	reader, err := zip.OpenReader(*inputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	if err := checkJar(&reader.Reader); err != nil {
+		fmt.Fprintf(os.Stderr, "%s:\n%s\n", *inputFile, err)
+		os.Exit(1)
+	}
+}
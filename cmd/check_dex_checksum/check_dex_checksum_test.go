@@ -0,0 +1,149 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"strings"
+	"testing"
+)
+
+// testDex returns a dex file with a valid header followed by the given data.
+func testDex(data []byte) []byte {
+	dex := make([]byte, headerSize+len(data))
+	copy(dex, "dex\n035\x00")
+	copy(dex[headerSize:], data)
+	binary.LittleEndian.PutUint32(dex[fileSizeOffset:], uint32(len(dex)))
+	signature := sha1.Sum(dex[fileSizeOffset:])
+	copy(dex[signatureOffset:], signature[:])
+	binary.LittleEndian.PutUint32(dex[checksumOffset:], adler32.Checksum(dex[signatureOffset:]))
+	return dex
+}
+
+func testJar(t *testing.T, files map[string][]byte) *zip.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"META-INF/MANIFEST.MF", "classes.dex", "classes2.dex"} {
+		if data, ok := files[name]; ok {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(data)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCheckDex(t *testing.T) {
+	valid := testDex([]byte("classes"))
+
+	corrupt := func(f func(dex []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+
+	testCases := []struct {
+		name string
+		dex  []byte
+		err  string
+	}{
+		{
+			name: "valid",
+			dex:  valid,
+		},
+		{
+			name: "truncated",
+			dex:  valid[:headerSize-1],
+			err:  "truncated dex file",
+		},
+		{
+			name: "bad magic",
+			dex:  corrupt(func(dex []byte) []byte { dex[0] = 'x'; return dex }),
+			err:  "bad dex magic",
+		},
+		{
+			name: "bad file size",
+			dex:  append(append([]byte(nil), valid...), 0),
+			err:  "file_size is",
+		},
+		{
+			name: "modified data",
+			dex:  corrupt(func(dex []byte) []byte { dex[headerSize] = 'C'; return dex }),
+			err:  "checksum is",
+		},
+		{
+			name: "modified signature",
+			dex: corrupt(func(dex []byte) []byte {
+				dex[signatureOffset] ^= 0xff
+				binary.LittleEndian.PutUint32(dex[checksumOffset:], adler32.Checksum(dex[signatureOffset:]))
+				return dex
+			}),
+			err: "signature is",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkDex(tc.dex)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error %q", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckJar(t *testing.T) {
+	valid := testDex([]byte("classes"))
+	invalid := append(testDex([]byte("classes2")), 0)
+
+	if err := checkJar(testJar(t, map[string][]byte{
+		"META-INF/MANIFEST.MF": []byte("Manifest-Version: 1.0\n"),
+		"classes.dex":          valid,
+		"classes2.dex":         valid,
+	})); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	err := checkJar(testJar(t, map[string][]byte{
+		"classes.dex":  valid,
+		"classes2.dex": invalid,
+	}))
+	if err == nil || !strings.HasPrefix(err.Error(), "classes2.dex: file_size is") {
+		t.Errorf("expected classes2.dex to fail, got %v", err)
+	}
+
+	err = checkJar(testJar(t, map[string][]byte{
+		"META-INF/MANIFEST.MF": []byte("Manifest-Version: 1.0\n"),
+	}))
+	if err == nil || !strings.Contains(err.Error(), "no classes*.dex file found") {
+		t.Errorf("expected missing dex error, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// merge_test_config merges the top level <option> and <target_preparer> elements of a partial
+// tradefed configuration into a full tradefed configuration.  Options of the partial
+// configuration replace the options of the full configuration with the same name and key.
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	outputFile = flag.String("o", "", "output test config")
+	inputFile  = flag.String("i", "", "input test config")
+	mergeFile  = flag.String("m", "", "partial test config to merge into the input test config")
+)
+
+const indent = "    "
+
+// element is a top level element of a <configuration>.
+type element struct {
+	name string
+	// optionKey identifies an <option> element, it is empty for other elements.
+	optionKey string
+	// start and end are the offsets of the element in the file.
+	start, end int64
+}
+
+// config is a parsed tradefed configuration.
+type config struct {
+	buf      []byte
+	elements []element
+	// end is the offset of the </configuration> end element.
+	end int64
+}
+
+func attr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseConfig returns the top level elements of the tradefed configuration in buf.
+func parseConfig(buf []byte) (*config, error) {
+	c := &config{buf: buf, end: -1}
+	d := xml.NewDecoder(bytes.NewReader(buf))
+	depth := 0
+	var current element
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 && t.Name.Local != "configuration" {
+				return nil, fmt.Errorf("expected <configuration>, found <%s>", t.Name.Local)
+			} else if depth == 2 {
+				current = element{name: t.Name.Local, start: start}
+				if t.Name.Local == "option" {
+					current.optionKey = attr(t, "name") + ":" + attr(t, "key")
+				}
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				current.end = d.InputOffset()
+				c.elements = append(c.elements, current)
+			} else if depth == 1 {
+				c.end = start
+			}
+			depth--
+		}
+	}
+	if c.end < 0 {
+		return nil, errors.New("missing <configuration>")
+	}
+	return c, nil
+}
+
+func (c *config) text(e element) string {
+	return string(c.buf[e.start:e.end])
+}
+
+// merge returns the contents of base with the elements of partial merged into it, and a note for
+// each option of base that is replaced.
+func merge(base, partial *config) ([]byte, []string, error) {
+	overrides := make(map[string]bool)
+	var merged []element
+	for _, e := range partial.elements {
+		switch e.name {
+		case "option":
+			overrides[e.optionKey] = true
+		case "target_preparer":
+		default:
+			return nil, nil, fmt.Errorf("unsupported element <%s>, only <option> and <target_preparer> can be merged", e.name)
+		}
+		merged = append(merged, e)
+	}
+
+	var notes []string
+	out := &bytes.Buffer{}
+	pos := int64(0)
+	for _, e := range base.elements {
+		if e.optionKey == "" || !overrides[e.optionKey] {
+			continue
+		}
+		notes = append(notes, fmt.Sprintf("%s replaces %s", partialOption(partial, e.optionKey), base.text(e)))
+		// Drop the element along with the indentation before it.
+		start := e.start
+		for start > pos && (base.buf[start-1] == ' ' || base.buf[start-1] == '\t') {
+			start--
+		}
+		end := e.end
+		if end < int64(len(base.buf)) && base.buf[end] == '\n' && (start == 0 || base.buf[start-1] == '\n') {
+			end++
+		}
+		out.Write(base.buf[pos:start])
+		pos = end
+	}
+	out.Write(base.buf[pos:base.end])
+	if len(merged) > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteString("\n")
+	}
+	for _, e := range merged {
+		out.WriteString(indent + strings.TrimSpace(partial.text(e)) + "\n")
+	}
+	out.Write(base.buf[base.end:])
+	return out.Bytes(), notes, nil
+}
+
+func partialOption(partial *config, optionKey string) string {
+	for i := len(partial.elements) - 1; i >= 0; i-- {
+		if partial.elements[i].optionKey == optionKey {
+			return partial.text(partial.elements[i])
+		}
+	}
+	return ""
+}
+
+func readConfig(file string) (*config, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: merge_test_config -i <input config> -m <partial config> -o <output config>")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *outputFile == "" || *inputFile == "" || *mergeFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	base, err := readConfig(*inputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	partial, err := readConfig(*mergeFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, notes, err := merge(base, partial)
+	if err != nil {
+		log.Fatalf("%s: %s", *mergeFile, err)
+	}
+	for _, note := range notes {
+		fmt.Fprintf(os.Stderr, "note: %s: %s\n", *mergeFile, note)
+	}
+
+	if err := os.WriteFile(*outputFile, out, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
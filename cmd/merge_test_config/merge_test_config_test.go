@@ -0,0 +1,132 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const hostTestConfig = `<?xml version="1.0" encoding="utf-8"?>
+<configuration description="Runs foo">
+    <option name="test-suite-tag" value="apct" />
+    <option name="null-device" value="true" />
+
+    <test class="com.android.tradefed.testtype.HostTest" >
+        <option name="jar" value="foo.jar" />
+    </test>
+</configuration>
+`
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name          string
+		partial       string
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name:     "empty",
+			partial:  `<configuration />`,
+			expected: hostTestConfig,
+		},
+		{
+			name: "target preparer",
+			partial: `<configuration>
+    <target_preparer class="com.android.tradefed.targetprep.RunHostCommandTargetPreparer">
+        <option name="host-setup-command" value="setup.sh" />
+    </target_preparer>
+</configuration>`,
+			expected: `<?xml version="1.0" encoding="utf-8"?>
+<configuration description="Runs foo">
+    <option name="test-suite-tag" value="apct" />
+    <option name="null-device" value="true" />
+
+    <test class="com.android.tradefed.testtype.HostTest" >
+        <option name="jar" value="foo.jar" />
+    </test>
+    <target_preparer class="com.android.tradefed.targetprep.RunHostCommandTargetPreparer">
+        <option name="host-setup-command" value="setup.sh" />
+    </target_preparer>
+</configuration>
+`,
+		},
+		{
+			name: "conflicting option",
+			partial: `<configuration>
+    <option name="null-device" value="false" />
+    <option name="test-tag" value="foo" />
+</configuration>`,
+			expected: `<?xml version="1.0" encoding="utf-8"?>
+<configuration description="Runs foo">
+    <option name="test-suite-tag" value="apct" />
+
+    <test class="com.android.tradefed.testtype.HostTest" >
+        <option name="jar" value="foo.jar" />
+    </test>
+    <option name="null-device" value="false" />
+    <option name="test-tag" value="foo" />
+</configuration>
+`,
+			expectedNotes: []string{
+				`<option name="null-device" value="false" /> replaces <option name="null-device" value="true" />`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base, err := parseConfig([]byte(hostTestConfig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			partial, err := parseConfig([]byte(tc.partial))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, notes, err := merge(base, partial)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(out) != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, out)
+			}
+			if !reflect.DeepEqual(notes, tc.expectedNotes) {
+				t.Errorf("expected notes %q, got %q", tc.expectedNotes, notes)
+			}
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	if _, err := parseConfig([]byte(`<option name="a" value="b" />`)); err == nil {
+		t.Errorf("expected error for a config without <configuration>")
+	}
+	if _, err := parseConfig([]byte(`<configuration>`)); err == nil {
+		t.Errorf("expected error for an unterminated config")
+	}
+
+	base, err := parseConfig([]byte(hostTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := parseConfig([]byte(`<configuration><test class="Foo" /></configuration>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := merge(base, partial); err == nil {
+		t.Errorf("expected error for an unsupported element")
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// strip_class_debug_info copies a jar, removing the LineNumberTable, LocalVariableTable and
+// LocalVariableTypeTable attributes from the Code attribute of every method of every class.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	outputFile = flag.String("o", "", "output jar")
+	inputFile  = flag.String("i", "", "input jar")
+)
+
+// debugAttributes are the attributes of a Code attribute that are removed.
+var debugAttributes = map[string]bool{
+	"LineNumberTable":        true,
+	"LocalVariableTable":     true,
+	"LocalVariableTypeTable": true,
+}
+
+const classMagic = 0xCAFEBABE
+
+var errTruncated = errors.New("truncated class file")
+
+// classReader reads big endian values from a class file.
+type classReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *classReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.buf) {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *classReader) u1() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *classReader) u2() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *classReader) u4() int {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func putU2(w *bytes.Buffer, v int) {
+	w.Write([]byte{byte(v >> 8), byte(v)})
+}
+
+func putU4(w *bytes.Buffer, v int) {
+	w.Write([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// stripClass returns a copy of the class file in buf without debug attributes.
+func stripClass(buf []byte) ([]byte, error) {
+	r := &classReader{buf: buf}
+	if r.u4() != classMagic {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, errors.New("not a class file")
+	}
+	r.u2() // minor_version
+	r.u2() // major_version
+
+	// Record the UTF8 constants so attribute names can be resolved.
+	utf8 := make(map[int]string)
+	count := r.u2()
+	for i := 1; i < count && r.err == nil; i++ {
+		switch tag := r.u1(); tag {
+		case 1: // Utf8
+			utf8[i] = string(r.bytes(r.u2()))
+		case 7, 8, 16, 19, 20: // Class, String, MethodType, Module, Package
+			r.bytes(2)
+		case 15: // MethodHandle
+			r.bytes(3)
+		case 3, 4, 9, 10, 11, 12, 17, 18: // Integer, Float, refs, NameAndType, Dynamic, InvokeDynamic
+			r.bytes(4)
+		case 5, 6: // Long, Double take two constant pool entries
+			r.bytes(8)
+			i++
+		default:
+			return nil, fmt.Errorf("unknown constant pool tag %d", tag)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	out.Write(buf[:r.pos])
+
+	// access_flags, this_class, super_class
+	out.Write(r.bytes(6))
+	interfaces := r.u2()
+	putU2(out, interfaces)
+	out.Write(r.bytes(2 * interfaces))
+
+	for _, members := range []string{"fields", "methods"} {
+		n := r.u2()
+		putU2(out, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			// access_flags, name_index, descriptor_index
+			out.Write(r.bytes(6))
+			if err := copyAttributes(r, out, utf8, members == "methods"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := copyAttributes(r, out, utf8, false); err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(buf) {
+		return nil, errors.New("trailing data after class file")
+	}
+	return out.Bytes(), nil
+}
+
+// copyAttributes copies an attribute table from r to out.  If stripCode is true the debug
+// attributes are removed from any Code attribute in the table.
+func copyAttributes(r *classReader, out *bytes.Buffer, utf8 map[int]string, stripCode bool) error {
+	n := r.u2()
+	putU2(out, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		nameIndex := r.u2()
+		info := r.bytes(r.u4())
+		if stripCode && utf8[nameIndex] == "Code" {
+			code, err := stripCodeAttribute(info, utf8)
+			if err != nil {
+				return err
+			}
+			info = code
+		}
+		putU2(out, nameIndex)
+		putU4(out, len(info))
+		out.Write(info)
+	}
+	return r.err
+}
+
+// stripCodeAttribute returns a copy of the info of a Code attribute without debug attributes.
+func stripCodeAttribute(info []byte, utf8 map[int]string) ([]byte, error) {
+	r := &classReader{buf: info}
+	out := &bytes.Buffer{}
+
+	// max_stack, max_locals
+	out.Write(r.bytes(4))
+	codeLength := r.u4()
+	putU4(out, codeLength)
+	out.Write(r.bytes(codeLength))
+	exceptions := r.u2()
+	putU2(out, exceptions)
+	out.Write(r.bytes(8 * exceptions))
+
+	type attribute struct {
+		nameIndex int
+		info      []byte
+	}
+	var kept []attribute
+	n := r.u2()
+	for i := 0; i < n && r.err == nil; i++ {
+		nameIndex := r.u2()
+		attrInfo := r.bytes(r.u4())
+		if !debugAttributes[utf8[nameIndex]] {
+			kept = append(kept, attribute{nameIndex, attrInfo})
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	putU2(out, len(kept))
+	for _, a := range kept {
+		putU2(out, a.nameIndex)
+		putU4(out, len(a.info))
+		out.Write(a.info)
+	}
+	return out.Bytes(), nil
+}
+
+func stripJar(in *zip.Reader, w io.Writer) error {
+	writer := zip.NewWriter(w)
+	for _, f := range in.File {
+		if !strings.HasSuffix(f.Name, ".class") {
+			if err := writer.Copy(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		stripped, err := stripClass(buf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		header := &zip.FileHeader{
+			Name:     f.Name,
+			Method:   f.Method,
+			Modified: f.Modified,
+		}
+		header.SetMode(f.Mode())
+		fw, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(stripped); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: strip_class_debug_info -i <input jar> -o <output jar>")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *outputFile == "" || *inputFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	reader, err := zip.OpenReader(*inputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	output, err := os.Create(*outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := stripJar(&reader.Reader, output); err != nil {
+		output.Close()
+		os.Remove(*outputFile)
+		log.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+type testAttribute struct {
+	name int
+	info []byte
+}
+
+func writeAttributes(w *bytes.Buffer, attrs []testAttribute) {
+	putU2(w, len(attrs))
+	for _, a := range attrs {
+		putU2(w, a.name)
+		putU4(w, len(a.info))
+		w.Write(a.info)
+	}
+}
+
+// Constant pool indexes used by testClass.
+const (
+	cpCode = 1 + iota
+	cpLineNumberTable
+	cpLocalVariableTable
+	cpStackMapTable
+	cpSourceFile
+	cpLong // takes two entries
+	_
+	cpCount
+)
+
+// testClass returns a class file with a single method whose Code attribute contains the given
+// nested attributes.
+func testClass(codeAttrs []testAttribute) []byte {
+	w := &bytes.Buffer{}
+	putU4(w, classMagic)
+	putU2(w, 0)  // minor_version
+	putU2(w, 52) // major_version
+
+	putU2(w, cpCount)
+	for _, s := range []string{"Code", "LineNumberTable", "LocalVariableTable", "StackMapTable", "SourceFile"} {
+		w.WriteByte(1)
+		putU2(w, len(s))
+		w.WriteString(s)
+	}
+	w.WriteByte(5)
+	w.Write(make([]byte, 8))
+
+	putU2(w, 0x21) // access_flags
+	putU2(w, 0)    // this_class
+	putU2(w, 0)    // super_class
+	putU2(w, 0)    // interfaces_count
+	putU2(w, 0)    // fields_count
+
+	code := &bytes.Buffer{}
+	putU2(code, 1) // max_stack
+	putU2(code, 1) // max_locals
+	putU4(code, 1) // code_length
+	code.WriteByte(0xb1)
+	putU2(code, 0) // exception_table_length
+	writeAttributes(code, codeAttrs)
+
+	putU2(w, 1) // methods_count
+	w.Write(make([]byte, 6))
+	writeAttributes(w, []testAttribute{{cpCode, code.Bytes()}})
+
+	writeAttributes(w, []testAttribute{{cpSourceFile, []byte{0, cpSourceFile}}})
+	return w.Bytes()
+}
+
+func TestStripClass(t *testing.T) {
+	lineNumbers := testAttribute{cpLineNumberTable, []byte{0, 1, 0, 0, 0, 1}}
+	localVariables := testAttribute{cpLocalVariableTable, []byte{0, 0}}
+	stackMap := testAttribute{cpStackMapTable, []byte{0, 0}}
+
+	testCases := []struct {
+		name     string
+		in       []testAttribute
+		expected []testAttribute
+	}{
+		{
+			name:     "no debug info",
+			in:       []testAttribute{stackMap},
+			expected: []testAttribute{stackMap},
+		},
+		{
+			name:     "debug info",
+			in:       []testAttribute{lineNumbers, stackMap, localVariables},
+			expected: []testAttribute{stackMap},
+		},
+		{
+			name:     "only debug info",
+			in:       []testAttribute{lineNumbers, localVariables},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := stripClass(testClass(tc.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if expected := testClass(tc.expected); !bytes.Equal(out, expected) {
+				t.Errorf("expected %x\ngot      %x", expected, out)
+			}
+		})
+	}
+}
+
+func TestStripClassErrors(t *testing.T) {
+	class := testClass(nil)
+
+	if _, err := stripClass([]byte("not a class file")); err == nil {
+		t.Errorf("expected error for bad magic")
+	}
+	if _, err := stripClass(class[:len(class)-1]); err == nil {
+		t.Errorf("expected error for truncated class")
+	}
+	if _, err := stripClass(append(class, 0)); err == nil {
+		t.Errorf("expected error for trailing data")
+	}
+}
+
+func TestStripJar(t *testing.T) {
+	in := &bytes.Buffer{}
+	w := zip.NewWriter(in)
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"META-INF/MANIFEST.MF", []byte("Manifest-Version: 1.0\n")},
+		{"a/A.class", testClass([]testAttribute{{cpLineNumberTable, []byte{0, 0}}})},
+		{"a/res.txt", []byte("resource")},
+	}
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(f.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(in.Bytes()), int64(in.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := stripJar(reader, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	outReader, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]byte{
+		"META-INF/MANIFEST.MF": files[0].content,
+		"a/A.class":            testClass(nil),
+		"a/res.txt":            files[2].content,
+	}
+	if len(outReader.File) != len(files) {
+		t.Fatalf("expected %d files, got %d", len(files), len(outReader.File))
+	}
+	for i, f := range outReader.File {
+		if f.Name != files[i].name {
+			t.Errorf("expected file %d to be %q, got %q", i, files[i].name, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, expected[f.Name]) {
+			t.Errorf("%s: expected %q, got %q", f.Name, expected[f.Name], content)
+		}
+	}
+}
@@ -166,6 +166,7 @@ type ModuleConfig struct {
 	DexPath         android.Path
 	ManifestPath    android.OptionalPath
 	UncompressedDex bool
+	CompactDex      *bool // overrides whether dex2oat generates compact dex if set
 	HasApkLibraries bool
 	PreoptFlags     []string
 
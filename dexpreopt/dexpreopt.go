@@ -408,6 +408,15 @@ func dexpreoptCommand(ctx android.BuilderContext, globalSoong *GlobalSoongConfig
 		cmd.FlagWithArg("--copy-dex-files=", "false")
 	}
 
+	if module.CompactDex != nil {
+		// Passed after the preopt flags so that it takes precedence over any global setting.
+		if *module.CompactDex {
+			cmd.FlagWithArg("--compact-dex-level=", "fast")
+		} else {
+			cmd.FlagWithArg("--compact-dex-level=", "none")
+		}
+	}
+
 	if !android.PrefixInList(preoptFlags, "--compiler-filter=") {
 		var compilerFilter string
 		if systemServerJars.ContainsJar(module.Name) {
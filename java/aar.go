@@ -863,7 +863,7 @@ func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 
 	proguardSpecInfo := a.collectProguardSpecInfo(ctx)
 	android.SetProvider(ctx, ProguardSpecInfoProvider, proguardSpecInfo)
-	exportedProguardFlagsFiles := proguardSpecInfo.ProguardFlagsFiles.ToList()
+	exportedProguardFlagsFiles := a.excludeProguardFlagsFiles(ctx, proguardSpecInfo.ProguardFlagsFiles.ToList())
 	a.extraProguardFlagsFiles = append(a.extraProguardFlagsFiles, exportedProguardFlagsFiles...)
 	a.extraProguardFlagsFiles = append(a.extraProguardFlagsFiles, a.proguardOptionsFile)
 
@@ -1193,6 +1193,7 @@ func (a *AARImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			nil,
 			transitiveUnconditionalExportedFlags,
 		),
+		ProguardFlagsFileOwners: collectProguardFlagsFileOwners(ctx, android.Paths{a.proguardFlags}),
 	})
 
 	ctx.Build(pctx, android.BuildParams{
@@ -116,7 +116,11 @@ func (library *Library) AndroidMkEntries() []android.AndroidMkEntries {
 
 					entries.SetOptionalPath("LOCAL_SOONG_PROGUARD_DICT", library.dexer.proguardDictionary)
 					entries.SetOptionalPath("LOCAL_SOONG_PROGUARD_USAGE_ZIP", library.dexer.proguardUsageZip)
-					entries.SetString("LOCAL_MODULE_STEM", library.Stem())
+					if library.installedStem != "" {
+						entries.SetString("LOCAL_MODULE_STEM", library.installedStem)
+					} else {
+						entries.SetString("LOCAL_MODULE_STEM", library.Stem())
+					}
 
 					entries.SetOptionalPaths("LOCAL_SOONG_LINT_REPORTS", library.linter.reports)
 
@@ -178,10 +182,28 @@ func (j *Test) AndroidMkEntries() []android.AndroidMkEntries {
 
 		j.testProperties.Test_options.CommonTestOptions.SetAndroidMkEntries(entries)
 	})
+	entries.ExtraFooters = append(entries.ExtraFooters, func(w io.Writer, name, prefix, moduleDir string) {
+		androidMkWriteSuiteData(w, name, j.suiteData)
+	})
 
 	return entriesList
 }
 
+// androidMkWriteSuiteData installs the data of each suite only into the testcases directory of
+// that suite, unlike LOCAL_COMPATIBILITY_SUPPORT_FILES which are installed into every suite of
+// the module.
+func androidMkWriteSuiteData(w io.Writer, name string, suiteData map[string]android.Paths) {
+	for _, suite := range android.SortedKeys(suiteData) {
+		fmt.Fprintf(w, "ifdef COMPATIBILITY_TESTCASES_OUT_%s\n", suite)
+		for _, d := range suiteData[suite] {
+			dest := fmt.Sprintf("$(COMPATIBILITY_TESTCASES_OUT_%s)/%s/%s", suite, name, d.Rel())
+			fmt.Fprintf(w, "$(eval $(call copy-one-file,%s,%s))\n", d.String(), dest)
+			fmt.Fprintf(w, "COMPATIBILITY.%s.FILES += %s\n", suite, dest)
+		}
+		fmt.Fprintln(w, "endif")
+	}
+}
+
 func androidMkWriteExtraTestConfigs(extraTestConfigs android.Paths, entries *android.AndroidMkEntries) {
 	if len(extraTestConfigs) > 0 {
 		entries.AddStrings("LOCAL_EXTRA_FULL_TEST_CONFIGS", extraTestConfigs.Strings()...)
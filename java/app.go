@@ -575,7 +575,7 @@ func (a *AndroidApp) proguardBuildActions(ctx android.ModuleContext) {
 		}
 	})
 
-	staticLibProguardFlagFiles = android.FirstUniquePaths(staticLibProguardFlagFiles)
+	staticLibProguardFlagFiles = a.excludeProguardFlagsFiles(ctx, android.FirstUniquePaths(staticLibProguardFlagFiles))
 
 	a.Module.extraProguardFlagsFiles = append(a.Module.extraProguardFlagsFiles, staticLibProguardFlagFiles...)
 	if !(a.dexProperties.optimizedResourceShrinkingEnabled(ctx)) {
@@ -1730,6 +1730,7 @@ func (u *usesLibrary) classLoaderContextForUsesLibDeps(ctx android.ModuleContext
 		}
 
 		if lib, ok := m.(UsesLibraryDependency); ok {
+			checkProvidesUsesLib(ctx, m)
 			libName := dep
 			if ulib, ok := m.(ProvidesUsesLib); ok && ulib.ProvidesUsesLib() != nil {
 				libName = *ulib.ProvidesUsesLib()
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -3407,6 +3408,58 @@ func TestUsesLibraries(t *testing.T) {
 		"--product-packages=out/soong/.intermediates/app/android_common/dexpreopt/app/product_packages.txt")
 }
 
+func TestUsesLibrariesProvidesUsesLibMismatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		err  string
+	}{
+		{
+			name: "mismatched sdk library",
+			bp: `
+				java_sdk_library {
+					name: "foo",
+					srcs: ["a.java"],
+					api_packages: ["foo"],
+					sdk_version: "current",
+					provides_uses_lib: "com.foo",
+				}
+			`,
+			err: `provides_uses_lib "com.foo" of dependency "foo" does not match the <library> name "foo" that it declares`,
+		},
+		{
+			name: "empty",
+			bp: `
+				java_library {
+					name: "foo",
+					srcs: ["a.java"],
+					installable: true,
+					provides_uses_lib: "",
+				}
+			`,
+			err: `dependency "foo" sets an empty provides_uses_lib`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			android.GroupFixturePreparers(
+				prepareForJavaTest,
+				PrepareForTestWithJavaSdkLibraryFiles,
+				FixtureWithLastReleaseApis("foo"),
+			).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				regexp.QuoteMeta(tc.err),
+			)).RunTestWithBp(t, tc.bp+`
+				android_app {
+					name: "app",
+					srcs: ["a.java"],
+					uses_libs: ["foo"],
+					sdk_version: "current",
+				}
+			`)
+		})
+	}
+}
+
 func TestDexpreoptBcp(t *testing.T) {
 	bp := `
 		java_sdk_library {
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -58,6 +59,10 @@ type CommonProperties struct {
 	// This is most useful in the arch/multilib variants to remove non-common files
 	Exclude_srcs []string `android:"path,arch_variant"`
 
+	// source files that are only used to build the Java module for some build releases, in
+	// addition to srcs.
+	Release_srcs []ReleaseSrcs
+
 	// list of directories containing Java resources
 	Java_resource_dirs []string `android:"arch_variant"`
 
@@ -73,9 +78,38 @@ type CommonProperties struct {
 	// list of module-specific flags that will be used for javac compiles
 	Javacflags []string `android:"arch_variant"`
 
+	Xlint struct {
+		// List of javac lint categories to enable, e.g. "cast".
+		Enable []string `android:"arch_variant"`
+
+		// List of javac lint categories to disable, e.g. "deprecation".
+		Disable []string `android:"arch_variant"`
+	}
+
+	Java_module struct {
+		// Version to record in the module descriptor compiled from the module-info.java in srcs,
+		// e.g. "1.2.3".  Must be a valid java.lang.module.ModuleDescriptor.Version, and requires
+		// srcs to contain a module-info.java.
+		Version *string
+
+		// Name of the module declared by the module-info.java in srcs, e.g. "com.example.foo".
+		// Required when jpms_module is true, and recorded for modules that depend on this one.
+		Name *string
+	}
+
+	// If true, the module descriptor compiled from the module-info.java in srcs is kept in the
+	// jar, so that it can be used as a JPMS module.  Only applies to host variants targeting java 9
+	// or later.  Defaults to false, in which case module-info.class files are stripped from the jar.
+	Jpms_module *bool
+
 	// list of module-specific flags that will be used for kotlinc compiles
 	Kotlincflags []string `android:"arch_variant"`
 
+	// list of flags that will be used for the kotlinc compiles of any module that depends on this
+	// module through libs or static_libs, for example -Xjvm-default=all.  They are also exported
+	// by the modules that depend on this module through static_libs.
+	Exported_kotlincflags []string `android:"arch_variant"`
+
 	// list of java libraries that will be in the classpath
 	Libs []string `android:"arch_variant"`
 
@@ -97,20 +131,47 @@ type CommonProperties struct {
 	// If not blank, set the java version passed to javac as -source and -target
 	Java_version *string
 
+	// If true, pass the java version to javac of the host variants as --release instead of as
+	// -source and -target, which also restricts the JDK APIs that the sources can use to those of
+	// that version.  Cannot be used with a custom bootclasspath or system_modules.  Defaults to
+	// false.
+	Use_release_flag *bool
+
 	// If set to true, allow this module to be dexed and installed on devices.  Has no
 	// effect on host modules, which are always considered installable.
 	Installable *bool
 
+	// Overrides the stem of the installed jar for the listed partitions, e.g. to install the jar
+	// as foo-ext.jar when the module is installed on system_ext. Partitions that are not listed
+	// use the regular stem.
+	Stem_by_partition []StemByPartition
+
 	// If set to true, include sources used to compile the module in to the final jar
 	Include_srcs *bool
 
+	// List of extra files, e.g. documentation or license headers, to package alongside the
+	// sources of this module whenever a jar of its sources is built.  Each file is placed at its
+	// path relative to the module directory, which must not collide with a source file.
+	Sources_jar_extra_files []string `android:"path"`
+
 	// If not empty, classes are restricted to the specified packages and their sub-packages.
-	// This restriction is checked after applying jarjar rules and including static libs.
+	// This restriction is checked after applying jarjar rules and including static libs, and the
+	// build of anything that uses the classes of the module fails if a class is outside them.
 	Permitted_packages []string
 
+	// If true, the build of a java_library fails if a package is found in more than one of its
+	// static_libs dependencies, as split packages break module-info and some runtime assumptions.
+	// The error lists the offending packages and modules.  Defaults to false.
+	Enforce_no_split_packages *bool
+
 	// List of modules to use as annotation processors
 	Plugins []string
 
+	// List of java libraries to add to the classpath of the annotation processors only, for
+	// runtime dependencies of the processors that must not be visible to the sources of the
+	// module.  They cannot also be listed in libs or static_libs.
+	Processor_classpath []string
+
 	// List of modules to export to libraries that directly depend on this library as annotation
 	// processors.  Note that if the plugins set generates_api: true this will disable the turbine
 	// optimization on modules that depend on this module, which will reduce parallelism and cause
@@ -171,6 +232,16 @@ type CommonProperties struct {
 		// environment variable is true. Setting this to false will improve build
 		// performance more than adding -XepDisableAllChecks in javacflags.
 		Enabled *bool
+
+		// If true, errorprone is run in a separate rule that the jar of the module does not depend
+		// on, so errorprone failures don't block the regular build.  The separate rule is built by
+		// the errorprone phony target.  Has no effect when enabled is false.  Defaults to false.
+		Variant_mode *bool
+
+		// List of severity overrides for individual errorprone checks, translated into
+		// -Xep:<check>:<severity> flags.  If a check is listed more than once the last entry wins,
+		// so a module can override the severities set by its defaults.
+		Severities []ErrorproneSeverity
 	}
 
 	Proto struct {
@@ -200,9 +271,84 @@ type CommonProperties struct {
 	// intermediate aconfig cache file tacked in by GeneratedJavaLibraryModule
 	Aconfig_Cache_files []android.Path `android:"mutated"`
 
+	// List of libs or static_libs dependencies whose aconfig intermediate cache files are dropped
+	// from the set collected from the dependencies. All of the cache files provided by a listed
+	// module are dropped.
+	Exclude_aconfig_files []string
+
+	Version_stamp struct {
+		// Template of a java resource to generate with the version of the build, with
+		// {BUILD_NUMBER} and {BUILD_FINGERPRINT} replaced by the build number and build
+		// fingerprint.  Builds that don't set them use "unknown" instead.
+		Template *string `android:"path"`
+
+		// Path of the generated resource inside the jar, e.g. "com/android/foo/version.txt".
+		Output *string
+	}
+
+	// If set, the java resources of the module, including the resources of its static_libs
+	// dependencies, are copied into a standalone jar with this name and the ".jar" extension.  The
+	// jar doesn't contain any classes.  The value only names the jar file, it doesn't create a
+	// module: the jar is referenced through the module itself with the ".resources" output tag,
+	// e.g. ":foo{.resources}" for a module named "foo".
+	Export_resources_as *string
+
+	// If true, generate a META-INF/provenance.json java resource recording the module name, a
+	// hash of the sources and a fingerprint of the javac toolchain and flags used to build the
+	// module.  Defaults to false.
+	Embed_provenance *bool
+
 	// If true, then only the headers are built and not the implementation jar.
 	Headers_only *bool
 
+	// If true, the jar of this module is only compiled against.  Modules that depend on it have it
+	// on their javac classpath, but not on their dex classpath, and don't package it even when it
+	// is listed in their static_libs.  The module itself is never installed.  Defaults to false.
+	Neverlink *bool
+
+	// If true, a module that has no classes, for example because it only contains resources, gets
+	// an empty META-INF/.nonempty entry in its classes jar, so that tools that reject zips without
+	// any entries accept it.  Defaults to false.
+	Ensure_nonempty_jar *bool
+
+	// If true, turbine runs the annotation processors of the module while compiling the header
+	// jar, instead of being disabled when a processor generates API.  This keeps the speed of
+	// turbine for modules whose processors only need to generate headers.  Defaults to false.
+	Turbine_process_annotations *bool
+
+	// If true, the host variant of a host_supported module is built without any sources,
+	// producing a stub jar, while the device variant is built normally.  Defaults to false.
+	Host_stub_only *bool
+
+	// If true, package the header jars of all transitive libs and static_libs dependencies into
+	// a single <module>-deps-headers.zip, available through the ".deps-headers.zip" output tag.
+	// The jars are stored at the root of the zip, so their file names must be unique.  Defaults
+	// to false.
+	Emit_deps_header_zip *bool
+
+	// If true, list the service types that the compiled classes load through ServiceLoader.load
+	// in <module>-service-usage.txt, available through the ".service-usage" output tag.  The
+	// service types are found heuristically from class literals passed to ServiceLoader.load.
+	// Defaults to false.
+	Emit_service_usage *bool
+
+	// If true, list the native methods declared by the compiled classes, prefixed with the name of
+	// their class, in <module>-native-methods.txt, available through the ".native-methods" output
+	// tag.  Defaults to false.
+	Emit_native_methods *bool
+
+	// Position of the classes of this module on the runtime classpath of the java_binary and java_test
+	// modules that have it in their static_libs.  Their static_libs are merged in decreasing order
+	// of classpath_priority, so the classes of a library with a higher priority win over classes
+	// with the same name in a library with a lower priority.  Libraries with the same priority keep
+	// their static_libs order, where the first one wins.  Defaults to 0.
+	Classpath_priority *int
+
+	// List of flags, for example --add-opens, that the JVM needs in order to run the classes of
+	// this module.  They are collected from all transitive static dependencies and passed to java
+	// by the generated wrappers of host java_binary modules.
+	Runtime_jvm_flags []string
+
 	// A list of files or dependencies to make available to the build sandbox. This is
 	// useful if source files are symlinks, the targets of the symlinks must be listed here.
 	// Note that currently not all actions implemented by android_apps are sandboxed, so you
@@ -217,6 +363,15 @@ type CommonProperties struct {
 	Is_stubs_module *bool
 }
 
+// Properties that are specific to host only modules. Modules that support both host and device add
+// DeviceProperties instead, whose properties only apply to the device variants.
+type HostOnlyProperties struct {
+	// When targeting 1.9 and above, the java_system_modules to use with --system instead of the
+	// system modules of the host JDK.  Must not be set when targeting 1.8 or below, which uses the
+	// bootclasspath instead.
+	System_modules *string
+}
+
 // Properties that are specific to device modules. Host module factories should not add these when
 // constructing a new module.
 type DeviceProperties struct {
@@ -301,6 +456,25 @@ type DeviceProperties struct {
 	HiddenAPIFlagFileProperties
 }
 
+type ReleaseSrcs struct {
+	// The build releases that the srcs are used for, e.g. "Tiramisu", "S-Tiramisu" or
+	// "UpsideDownCake+", in the same format as the supported_build_releases of sdk member
+	// properties.
+	Build_releases string
+
+	// list of source files used to compile the Java module when building one of the
+	// build_releases.
+	Srcs []string `android:"path"`
+}
+
+type StemByPartition struct {
+	// Name of the partition, one of system, system_ext, product, vendor or odm.
+	Partition string
+
+	// Stem of the installed jar on that partition.
+	Stem string
+}
+
 // Properties that can be overridden by overriding module (e.g. override_android_app)
 type OverridableProperties struct {
 	// set the name of the output. If not set, `name` is used.
@@ -430,17 +604,26 @@ type Module struct {
 	// Functionality common to Module and Import.
 	embeddableInModuleAndImport
 
-	properties       CommonProperties
-	protoProperties  android.ProtoProperties
-	deviceProperties DeviceProperties
+	properties         CommonProperties
+	protoProperties    android.ProtoProperties
+	deviceProperties   DeviceProperties
+	hostOnlyProperties HostOnlyProperties
 
 	overridableProperties OverridableProperties
 	sourceProperties      android.SourceProperties
 
+	// the srcs of the release_srcs entries that apply to the build release being built, resolved
+	// in deps() so that they are taken into account when adding dependencies for the sources
+	releaseSrcs []string
+
 	// jar file containing header classes including static library dependencies, suitable for
 	// inserting into the bootclasspath/classpath of another compile
 	headerJarFile android.Path
 
+	// jar file containing the header classes generated by turbine from the sources of this module
+	// only, or nil if turbine was not used
+	turbineJarFile android.Path
+
 	repackagedHeaderJarFile android.Path
 
 	// jar file containing implementation classes including static library dependencies but no
@@ -454,9 +637,35 @@ type Module struct {
 	srcJarArgs []string
 	srcJarDeps android.Paths
 
+	// jar file containing the source files of this module, provided through the ".srcjar" tag
+	sourcesJar android.Path
+
 	// the source files of this module and all its static dependencies
 	transitiveSrcFiles *android.DepSet[android.Path]
 
+	// the resource jars of this module, not including those of its static dependencies
+	localResourceJars android.Paths
+
+	// the resource jars of this module and all its static dependencies
+	transitiveResourceJars *android.DepSet[android.Path]
+
+	// the runtime_jvm_flags of this module and all its static dependencies
+	transitiveJvmFlags *android.DepSet[string]
+
+	// the exported_kotlincflags of this module and all its static dependencies
+	transitiveExportedKotlincFlags *android.DepSet[string]
+
+	// stamp file written by the rule checking that no package is split across static_libs, if
+	// enforce_no_split_packages is set
+	splitPackagesCheckFile android.Path
+
+	// if true, the static_libs are merged in decreasing order of their classpath_priority
+	orderStaticJarsByClasspathPriority bool
+
+	// the java_module.name of this module if jpms_module is set, in which case the module
+	// descriptor is kept in the jars
+	jpmsModule string
+
 	// jar file containing implementation classes and resources including static library
 	// dependencies
 	implementationAndResourcesJar android.Path
@@ -500,6 +709,10 @@ type Module struct {
 	// if true, the exported plugins generate API and require disabling turbine.
 	exportedDisableTurbine bool
 
+	// the annotation processor options that make an exported plugin generate API, keyed by the
+	// processor class of the plugin.
+	exportedGeneratesApiOptions map[string]string
+
 	// list of source files, collected from srcFiles with unique java and all kt files,
 	// will be used by android.IDEInfo struct
 	expandIDEInfoCompiledSrcs []string
@@ -547,6 +760,27 @@ type Module struct {
 	// java_aconfig_library or java_library modules that are statically linked
 	// to this module. Does not contain cache files from all transitive dependencies.
 	aconfigCacheFiles android.Paths
+
+	// the aconfig cache files of this module and all its libs and static_libs dependencies
+	transitiveAconfigFiles *android.DepSet[android.Path]
+
+	// Zip of the header jars of all transitive dependencies, only set if emit_deps_header_zip is true.
+	depsHeaderZip android.Path
+
+	// List of the service types loaded through ServiceLoader, only set if emit_service_usage is true.
+	serviceUsage android.Path
+
+	// List of the native methods of the classes, only set if emit_native_methods is true.
+	nativeMethods android.Path
+
+	// sha256 checksum of the implementation jar.
+	implementationJarChecksum android.Path
+
+	// Preprocessed aidl file passed to the aidl compiler, only set if the module has aidl sources.
+	aidlPreprocess android.OptionalPath
+
+	// Copy of the resource jar, only set if export_resources_as is set.
+	exportedResourcesJar android.Path
 }
 
 func (j *Module) CheckStableSdkVersion(ctx android.BaseModuleContext) error {
@@ -613,6 +847,9 @@ func (j *Module) checkHeadersOnly(ctx android.ModuleContext) {
 		if headersOnly && installable {
 			ctx.PropertyErrorf("headers_only", "This module has conflicting settings. headers_only is true which, which means this module doesn't generate an implementation jar. However installable is set to true.")
 		}
+		if proptools.Bool(j.properties.Neverlink) && installable {
+			ctx.PropertyErrorf("neverlink", "cannot be set with installable, a neverlink module is never installed")
+		}
 	}
 }
 
@@ -659,18 +896,73 @@ func (j *Module) OutputFiles(tag string) (android.Paths, error) {
 		return android.Paths{j.implementationAndResourcesJar}, nil
 	case ".hjar":
 		return android.Paths{j.headerJarFile}, nil
+	case ".turbine":
+		if j.turbineJarFile != nil {
+			return android.Paths{j.turbineJarFile}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but turbine was not used to compile the headers.", tag)
 	case ".proguard_map":
 		if j.dexer.proguardDictionary.Valid() {
 			return android.Paths{j.dexer.proguardDictionary.Path()}, nil
 		}
 		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".keep_coverage":
+		if j.dexer.keepCoverage.Valid() {
+			return android.Paths{j.dexer.keepCoverage.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".proguard_flags_provenance":
+		if j.dexer.proguardFlagsProvenance.Valid() {
+			return android.Paths{j.dexer.proguardFlagsProvenance.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".optimize_size":
+		if j.dexer.optimizeSizeReport.Valid() {
+			return android.Paths{j.dexer.optimizeSizeReport.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
 	case ".generated_srcjars":
 		return j.properties.Generated_srcjars, nil
+	case ".srcjar":
+		if j.sourcesJar != nil {
+			return android.Paths{j.sourcesJar}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
 	case ".lint":
 		if j.linter.outputs.xml != nil {
 			return android.Paths{j.linter.outputs.xml}, nil
 		}
 		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".deps-headers.zip":
+		if j.depsHeaderZip != nil {
+			return android.Paths{j.depsHeaderZip}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".service-usage":
+		if j.serviceUsage != nil {
+			return android.Paths{j.serviceUsage}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".native-methods":
+		if j.nativeMethods != nil {
+			return android.Paths{j.nativeMethods}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".sha256":
+		if j.implementationJarChecksum != nil {
+			return android.Paths{j.implementationJarChecksum}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".resources":
+		if j.exportedResourcesJar != nil {
+			return android.Paths{j.exportedResourcesJar}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but export_resources_as is not set.", tag)
+	case ".aidl_preprocessed":
+		if j.aidlPreprocess.Valid() {
+			return android.Paths{j.aidlPreprocess.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but the module has no aidl sources or no preprocessed aidl file.", tag)
 	default:
 		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 	}
@@ -787,6 +1079,8 @@ func (j *Module) AvailableFor(what string) bool {
 }
 
 func (j *Module) deps(ctx android.BottomUpMutatorContext) {
+	j.releaseSrcs = j.resolveReleaseSrcs(ctx)
+
 	if ctx.Device() {
 		j.linter.deps(ctx)
 
@@ -798,6 +1092,8 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 			// be forwarded to the public stubs library when necessary.
 			ctx.AddVariationDependencies(nil, syspropPublicStubDepTag, j.deviceProperties.SyspropPublicStub)
 		}
+	} else if j.hostOnlyProperties.System_modules != nil {
+		ctx.AddVariationDependencies(nil, systemModulesTag, *j.hostOnlyProperties.System_modules)
 	}
 
 	libDeps := ctx.AddVariationDependencies(nil, libTag, j.properties.Libs...)
@@ -855,6 +1151,12 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 	}
 
 	ctx.AddFarVariationDependencies(ctx.Config().BuildOSCommonTarget.Variations(), pluginTag, j.properties.Plugins...)
+	for _, lib := range j.properties.Processor_classpath {
+		if android.InList(lib, j.properties.Libs) || android.InList(lib, j.properties.Static_libs) {
+			ctx.PropertyErrorf("processor_classpath", "%q cannot also be listed in libs or static_libs", lib)
+		}
+	}
+	ctx.AddFarVariationDependencies(ctx.Config().BuildOSCommonTarget.Variations(), processorClasspathTag, j.properties.Processor_classpath...)
 	ctx.AddFarVariationDependencies(ctx.Config().BuildOSCommonTarget.Variations(), errorpronePluginTag, j.properties.Errorprone.Extra_check_modules...)
 	ctx.AddFarVariationDependencies(ctx.Config().BuildOSCommonTarget.Variations(), exportedPluginTag, j.properties.Exported_plugins...)
 
@@ -899,7 +1201,7 @@ func hasSrcExt(srcs []string, ext string) bool {
 }
 
 func (j *Module) hasSrcExt(ext string) bool {
-	return hasSrcExt(j.properties.Srcs, ext)
+	return hasSrcExt(j.properties.Srcs, ext) || hasSrcExt(j.releaseSrcs, ext)
 }
 
 func (j *Module) individualAidlFlags(ctx android.ModuleContext, aidlFile android.Path) string {
@@ -972,15 +1274,54 @@ func (j *Module) aidlFlags(ctx android.ModuleContext, aidlPreprocess android.Opt
 	return strings.Join(flags, " "), deps
 }
 
+// ErrorproneSeverity overrides the severity of a single errorprone check.
+type ErrorproneSeverity struct {
+	// Name of the errorprone check, e.g. "MissingOverride".
+	Check string
+
+	// Severity of the check, one of "OFF", "WARN" or "ERROR".
+	Severity string
+}
+
+var errorproneSeverityLevels = []string{"OFF", "WARN", "ERROR"}
+
+// errorproneSeverityFlags converts the errorprone.severities property into -Xep flags, sorted by
+// check name so that the flags don't change when the property is reordered.
+func errorproneSeverityFlags(ctx android.ModuleContext, severities []ErrorproneSeverity) []string {
+	severityByCheck := make(map[string]string)
+	for _, s := range severities {
+		if s.Check == "" {
+			ctx.PropertyErrorf("errorprone.severities", "check must be set")
+			continue
+		}
+		if !android.InList(s.Severity, errorproneSeverityLevels) {
+			ctx.PropertyErrorf("errorprone.severities", "invalid severity %q for check %q, must be one of %q",
+				s.Severity, s.Check, errorproneSeverityLevels)
+			continue
+		}
+		severityByCheck[s.Check] = s.Severity
+	}
+
+	var flags []string
+	for _, check := range android.SortedKeys(severityByCheck) {
+		flags = append(flags, "-Xep:"+check+":"+severityByCheck[check])
+	}
+	return flags
+}
+
 func (j *Module) collectBuilderFlags(ctx android.ModuleContext, deps deps) javaBuilderFlags {
 
 	var flags javaBuilderFlags
 
 	// javaVersion flag.
 	flags.javaVersion = getJavaVersion(ctx, String(j.properties.Java_version), android.SdkContext(j))
+	if j.hostOnlyProperties.System_modules != nil && !flags.javaVersion.usesJavaModules() {
+		ctx.PropertyErrorf("system_modules", "cannot be set when targeting java %s, which uses the bootclasspath",
+			flags.javaVersion)
+	}
 
 	epEnabled := j.properties.Errorprone.Enabled
-	if (ctx.Config().RunErrorProne() && epEnabled == nil) || Bool(epEnabled) {
+	if (ctx.Config().RunErrorProne() && epEnabled == nil) || Bool(epEnabled) || j.errorproneVariantMode() {
 		if config.ErrorProneClasspath == nil && !ctx.Config().RunningInsideUnitTest() {
 			ctx.ModuleErrorf("cannot build with Error Prone, missing external/error_prone?")
 		}
@@ -989,6 +1330,7 @@ func (j *Module) collectBuilderFlags(ctx android.ModuleContext, deps deps) javaB
 			"-Xplugin:ErrorProne",
 			"${config.ErrorProneChecks}",
 		}
+		errorProneFlags = append(errorProneFlags, errorproneSeverityFlags(ctx, j.properties.Errorprone.Severities)...)
 		errorProneFlags = append(errorProneFlags, j.properties.Errorprone.Javacflags...)
 
 		flags.errorProneExtraJavacFlags = "${config.ErrorProneHeapFlags} ${config.ErrorProneFlags} " +
@@ -1035,6 +1377,15 @@ func (j *Module) collectBuilderFlags(ctx android.ModuleContext, deps deps) javaB
 	// systemModules
 	flags.systemModules = deps.systemModules
 
+	if Bool(j.properties.Use_release_flag) && ctx.Host() {
+		// javac doesn't allow --release together with -bootclasspath or --system.
+		if len(flags.bootClasspath) > 0 || flags.systemModules != nil {
+			ctx.PropertyErrorf("use_release_flag", "cannot be used with a custom bootclasspath or system_modules")
+		} else {
+			flags.useReleaseFlag = true
+		}
+	}
+
 	return flags
 }
 
@@ -1057,6 +1408,12 @@ func (j *Module) collectJavacFlags(
 		javacFlags = append(javacFlags, "-g:source,lines")
 	}
 	javacFlags = append(javacFlags, "-Xlint:-dep-ann")
+	if xlintFlag := j.xlintFlag(ctx); xlintFlag != "" {
+		javacFlags = append(javacFlags, xlintFlag)
+	}
+	if moduleVersionFlag := j.moduleVersionFlag(ctx, srcFiles); moduleVersionFlag != "" {
+		javacFlags = append(javacFlags, moduleVersionFlag)
+	}
 
 	if flags.javaVersion.usesJavaModules() {
 		javacFlags = append(javacFlags, j.properties.Openjdk9.Javacflags...)
@@ -1091,6 +1448,105 @@ func (j *Module) collectJavacFlags(
 	return flags
 }
 
+// xlintFlag returns the -Xlint flag for the categories in the xlint property, or an empty string
+// if there are none.
+func (j *Module) xlintFlag(ctx android.ModuleContext) string {
+	var categories []string
+	for _, c := range android.SortedUniqueStrings(j.properties.Xlint.Enable) {
+		if c == "" {
+			ctx.PropertyErrorf("xlint.enable", "category names must not be empty")
+		}
+		categories = append(categories, c)
+	}
+	for _, c := range android.SortedUniqueStrings(j.properties.Xlint.Disable) {
+		if c == "" {
+			ctx.PropertyErrorf("xlint.disable", "category names must not be empty")
+		}
+		categories = append(categories, "-"+c)
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	return "-Xlint:" + strings.Join(categories, ",")
+}
+
+// moduleVersionPattern matches the versions accepted by java.lang.module.ModuleDescriptor.Version,
+// a version number starting with a digit followed by optional pre-release and build parts.
+var moduleVersionPattern = regexp.MustCompile(`^[0-9][^-+\s]*(-[^+\s]+)?(\+\S+)?$`)
+
+// moduleVersionFlag returns the --module-version flag for the java_module.version property, or an
+// empty string if it is not set.
+func (j *Module) moduleVersionFlag(ctx android.ModuleContext, srcFiles android.Paths) string {
+	version := j.properties.Java_module.Version
+	if version == nil {
+		return ""
+	}
+	if !moduleVersionPattern.MatchString(*version) {
+		ctx.PropertyErrorf("java_module.version", "%q is not a valid module version", *version)
+		return ""
+	}
+	if !hasModuleInfoJava(srcFiles) {
+		ctx.PropertyErrorf("java_module.version", "requires a module-info.java in srcs")
+		return ""
+	}
+	return "--module-version " + *version
+}
+
+// jpmsModuleName returns the java_module.name property if jpms_module is set for this variant, or
+// an empty string if the module descriptor should be stripped from the jar.
+func (j *Module) jpmsModuleName(ctx android.ModuleContext, flags javaBuilderFlags, srcFiles android.Paths) string {
+	if !Bool(j.properties.Jpms_module) || !ctx.Host() {
+		return ""
+	}
+	if !flags.javaVersion.usesJavaModules() {
+		ctx.PropertyErrorf("jpms_module", "requires java 9 or later, got java %s", flags.javaVersion)
+		return ""
+	}
+	if !hasModuleInfoJava(srcFiles) {
+		ctx.PropertyErrorf("jpms_module", "requires a module-info.java in srcs")
+		return ""
+	}
+	name := proptools.String(j.properties.Java_module.Name)
+	if name == "" {
+		ctx.PropertyErrorf("java_module.name", "is required when jpms_module is true")
+		return ""
+	}
+	return name
+}
+
+// resolveReleaseSrcs returns the release_srcs of the build releases that include the build
+// release of the platform being built.
+func (j *Module) resolveReleaseSrcs(ctx android.BaseModuleContext) []string {
+	release := android.BuildReleaseForConfig(ctx.Config())
+	var srcs []string
+	for _, releaseSrcs := range j.properties.Release_srcs {
+		set, err := android.ParseBuildReleaseSet(releaseSrcs.Build_releases)
+		if err != nil {
+			ctx.PropertyErrorf("release_srcs", "invalid build_releases %q: %s", releaseSrcs.Build_releases, err)
+			continue
+		}
+		if set.Contains(release) {
+			srcs = append(srcs, releaseSrcs.Srcs...)
+		}
+	}
+	return srcs
+}
+
+// srcs returns the srcs of the module including the release_srcs that apply to the build release
+// being built.
+func (j *Module) srcs() []string {
+	return android.Concat(j.properties.Srcs, j.releaseSrcs)
+}
+
+func hasModuleInfoJava(srcFiles android.Paths) bool {
+	for _, src := range srcFiles {
+		if src.Base() == "module-info.java" {
+			return true
+		}
+	}
+	return false
+}
+
 func (j *Module) AddJSONData(d *map[string]interface{}) {
 	(&j.ModuleBase).AddJSONData(d)
 	(*d)["Java"] = map[string]interface{}{
@@ -1105,6 +1561,10 @@ func (j *Module) addGeneratedSrcJars(path android.Path) {
 
 func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspathJars, extraCombinedJars android.Paths) {
 
+	if proptools.Bool(j.dexProperties.Optimize.Proguard_flags_provenance) {
+		j.dexer.proguardFlagsProvenance = android.OptionalPathForPath(j.buildProguardFlagsProvenance(ctx))
+	}
+
 	// Auto-propagating jarjar rules
 	jarjarProviderData := j.collectJarJarRules(ctx)
 	if jarjarProviderData != nil {
@@ -1129,7 +1589,14 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		ctx.PropertyErrorf("openjdk9.srcs", "JDK version defaults to higher than 9")
 	}
 
-	srcFiles := android.PathsForModuleSrcExcludes(ctx, j.properties.Srcs, j.properties.Exclude_srcs)
+	srcFiles := android.PathsForModuleSrcExcludes(ctx, j.srcs(), j.properties.Exclude_srcs)
+	if proptools.Bool(j.properties.Host_stub_only) {
+		if !j.HostSupported() || !j.DeviceSupported() {
+			ctx.PropertyErrorf("host_stub_only", "can only be set on modules that are host_supported")
+		} else if ctx.Host() {
+			srcFiles = nil
+		}
+	}
 	j.sourceExtensions = []string{}
 	for _, ext := range []string{".kt", ".proto", ".aidl", ".java", ".logtags"} {
 		if hasSrcExt(srcFiles.Strings(), ext) {
@@ -1147,6 +1614,10 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 
 	aidlSrcs := srcFiles.FilterByExt(".aidl")
 	flags.aidlFlags, flags.aidlDeps = j.aidlFlags(ctx, deps.aidlPreprocess, deps.aidlIncludeDirs, aidlSrcs)
+	flags.aidlDeps = append(flags.aidlDeps, deps.aidlIncludeDeps...)
+	if len(aidlSrcs) > 0 {
+		j.aidlPreprocess = deps.aidlPreprocess
+	}
 
 	nonGeneratedSrcJars := srcFiles.FilterByExt(".srcjar")
 	srcFiles = j.genSources(ctx, srcFiles, flags)
@@ -1187,13 +1658,18 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	uniqueSrcFiles = append(uniqueSrcFiles, uniqueJavaFiles...)
 	uniqueSrcFiles = append(uniqueSrcFiles, uniqueKtFiles...)
 	j.uniqueSrcFiles = uniqueSrcFiles
+	j.jpmsModule = j.jpmsModuleName(ctx, flags, uniqueSrcFiles)
 	android.SetProvider(ctx, blueprint.SrcsFileProviderKey, blueprint.SrcsFileProviderData{SrcPaths: uniqueSrcFiles.Strings()})
 
 	// We don't currently run annotation processors in turbine, which means we can't use turbine
 	// generated header jars when an annotation processor that generates API is enabled.  One
 	// exception (handled further below) is when kotlin sources are enabled, in which case turbine
 	//  is used to run all of the annotation processors.
-	disableTurbine := deps.disableTurbine
+	disableTurbine := deps.disableTurbine || j.passesGeneratesApiOption(deps.generatesApiOptions)
+	if disableTurbine && Bool(j.properties.Turbine_process_annotations) {
+		disableTurbine = false
+		flags.turbineProcessAnnotations = true
+	}
 
 	// Collect .java and .kt files for AIDEGen
 	j.expandIDEInfoCompiledSrcs = append(j.expandIDEInfoCompiledSrcs, uniqueSrcFiles.Strings()...)
@@ -1206,7 +1682,14 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	// final R classes from the app.
 	flags.classpath = append(android.CopyOf(extraClasspathJars), flags.classpath...)
 
+	for _, exclude := range j.properties.Exclude_aconfig_files {
+		if !android.InList(exclude, j.properties.Libs) && !android.InList(exclude, j.properties.Static_libs) {
+			ctx.PropertyErrorf("exclude_aconfig_files", "%q is not a libs or static_libs dependency", exclude)
+		}
+	}
 	j.aconfigCacheFiles = append(deps.aconfigProtoFiles, j.properties.Aconfig_Cache_files...)
+	j.transitiveAconfigFiles = collectTransitive(ctx, j.aconfigCacheFiles, transitiveAconfigFiles,
+		j.properties.Exclude_aconfig_files, libTag, staticLibTag)
 
 	// If compiling headers then compile them and skip the rest
 	if proptools.Bool(j.properties.Headers_only) {
@@ -1232,8 +1715,11 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			ExportedPlugins:                     j.exportedPluginJars,
 			ExportedPluginClasses:               j.exportedPluginClasses,
 			ExportedPluginDisableTurbine:        j.exportedDisableTurbine,
+			ExportedPluginGeneratesApiOptions:   j.exportedGeneratesApiOptions,
 			StubsLinkType:                       j.stubsLinkType,
 			AconfigIntermediateCacheOutputPaths: deps.aconfigProtoFiles,
+			TransitiveAconfigFiles:              j.transitiveAconfigFiles,
+			Neverlink:                           proptools.Bool(j.properties.Neverlink),
 		})
 
 		j.outputFile = j.headerJarFile
@@ -1250,6 +1736,9 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		kotlincFlags := j.properties.Kotlincflags
 		CheckKotlincFlags(ctx, kotlincFlags)
 
+		// Flags exported by libs and static_libs dependencies.
+		kotlincFlags = append(kotlincFlags, android.FirstUniqueStrings(deps.exportedKotlincFlags)...)
+
 		// Workaround for KT-46512
 		kotlincFlags = append(kotlincFlags, "-Xsam-conversions=class")
 
@@ -1293,8 +1782,8 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			flags.processors = nil
 		}
 
-		kotlinJar := android.PathForModuleOut(ctx, "kotlin", jarName)
-		kotlinHeaderJar := android.PathForModuleOut(ctx, "kotlin_headers", jarName)
+		kotlinJar := implJarOutPath(ctx, "kotlin", jarName)
+		kotlinHeaderJar := headerJarOutPath(ctx, "kotlin_headers", jarName)
 		kotlinCompile(ctx, kotlinJar, kotlinHeaderJar, uniqueSrcFiles, kotlinCommonSrcFiles, srcJars, flags)
 		if ctx.Failed() {
 			return
@@ -1353,7 +1842,20 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			}
 		}
 		var extraJarDeps android.Paths
-		if Bool(j.properties.Errorprone.Enabled) {
+		if j.errorproneVariantMode() {
+			// Compile with errorprone to a separate jar that nothing in the regular build depends on,
+			// and that is only built by the errorprone phony target.
+			if hasErrorproneableFiles {
+				errorproneFlags := enableErrorproneFlags(flags)
+				errorprone := android.PathForModuleOut(ctx, "errorprone", jarName)
+				errorproneAnnoSrcJar := android.PathForModuleOut(ctx, "errorprone", "anno.srcjar")
+
+				transformJavaToClasses(ctx, errorprone, -1, uniqueJavaFiles, srcJars, errorproneAnnoSrcJar, errorproneFlags, nil,
+					"errorprone", "errorprone")
+
+				ctx.Phony("errorprone", errorprone)
+			}
+		} else if Bool(j.properties.Errorprone.Enabled) {
 			// If error-prone is enabled, enable errorprone flags on the regular
 			// build.
 			flags = enableErrorproneFlags(flags)
@@ -1416,36 +1918,47 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 
 	j.srcJarArgs, j.srcJarDeps = resourcePathsToJarArgs(srcFiles), srcFiles
 
+	// sources_jar_extra_files only go into the sources jar, not into the jar built for
+	// include_srcs nor into the SrcJarArgs exported to the modules that depend on this one.
+	var sourcesJarExtraFiles android.Paths
+	if len(j.properties.Sources_jar_extra_files) > 0 {
+		sourcesJarExtraFiles = android.PathsForModuleSrc(ctx, j.properties.Sources_jar_extra_files)
+		srcRels := make(map[string]bool)
+		for _, f := range srcFiles {
+			srcRels[f.Rel()] = true
+		}
+		for _, f := range sourcesJarExtraFiles {
+			if srcRels[f.Rel()] {
+				ctx.PropertyErrorf("sources_jar_extra_files", "%q collides with a source file", f.Rel())
+			}
+		}
+	}
+
 	var includeSrcJar android.WritablePath
 	if Bool(j.properties.Include_srcs) {
 		includeSrcJar = android.PathForModuleOut(ctx, ctx.ModuleName()+".srcjar")
 		TransformResourcesToJar(ctx, includeSrcJar, j.srcJarArgs, j.srcJarDeps)
 	}
+	if includeSrcJar != nil && len(sourcesJarExtraFiles) == 0 {
+		j.sourcesJar = includeSrcJar
+	} else if len(j.srcJarDeps) > 0 || len(sourcesJarExtraFiles) > 0 {
+		// Nothing depends on this jar unless it is referenced through the ".srcjar" tag, so it
+		// is only built when needed.
+		sourcesJarFiles := append(android.CopyOfPaths(srcFiles), sourcesJarExtraFiles...)
+		sourcesJar := android.PathForModuleOut(ctx, "sources", ctx.ModuleName()+"-sources.jar")
+		TransformResourcesToJar(ctx, sourcesJar, resourcePathsToJarArgs(sourcesJarFiles), sourcesJarFiles)
+		j.sourcesJar = sourcesJar
+	}
 
-	dirArgs, dirDeps := ResourceDirsToJarArgs(ctx, j.properties.Java_resource_dirs,
-		j.properties.Exclude_java_resource_dirs, j.properties.Exclude_java_resources)
-	fileArgs, fileDeps := ResourceFilesToJarArgs(ctx, j.properties.Java_resources, j.properties.Exclude_java_resources)
-	extraArgs, extraDeps := resourcePathsToJarArgs(j.extraResources), j.extraResources
-
-	var resArgs []string
-	var resDeps android.Paths
-
-	resArgs = append(resArgs, dirArgs...)
-	resDeps = append(resDeps, dirDeps...)
-
-	resArgs = append(resArgs, fileArgs...)
-	resDeps = append(resDeps, fileDeps...)
-
-	resArgs = append(resArgs, extraArgs...)
-	resDeps = append(resDeps, extraDeps...)
-
-	if len(resArgs) > 0 {
-		resourceJar := android.PathForModuleOut(ctx, "res", jarName)
-		TransformResourcesToJar(ctx, resourceJar, resArgs, resDeps)
-		j.resourceJar = resourceJar
-		if ctx.Failed() {
-			return
-		}
+	if versionStamp := j.buildVersionStamp(ctx); versionStamp != nil {
+		j.extraResources = append(j.extraResources, versionStamp)
+	}
+	if Bool(j.properties.Embed_provenance) {
+		j.extraResources = append(j.extraResources, j.buildProvenance(ctx, uniqueSrcFiles, srcJars, flags))
+	}
+	j.resourceJar = j.buildJavaResourcesJar(ctx, jarName)
+	if ctx.Failed() {
+		return
 	}
 
 	var resourceJars android.Paths
@@ -1455,6 +1968,7 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	if Bool(j.properties.Include_srcs) {
 		resourceJars = append(resourceJars, includeSrcJar)
 	}
+	j.localResourceJars = android.CopyOf(resourceJars)
 	resourceJars = append(resourceJars, deps.staticResourceJars...)
 
 	if len(resourceJars) > 1 {
@@ -1467,7 +1981,11 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	}
 
 	if len(deps.staticJars) > 0 {
-		jars = append(jars, deps.staticJars...)
+		staticJars := deps.staticJars
+		if j.orderStaticJarsByClasspathPriority {
+			staticJars = orderByClasspathPriority(staticJars, deps.staticJarsClasspathPriority)
+		}
+		jars = append(jars, staticJars...)
 	}
 
 	manifest := j.overrideManifest
@@ -1500,6 +2018,12 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		jars = append(jars, servicesJar)
 	}
 
+	if len(jars) == 0 && Bool(j.properties.Ensure_nonempty_jar) {
+		markerJar := android.PathForModuleOut(ctx, "nonempty-marker", jarName)
+		TransformEmptyJarMarker(ctx, markerJar)
+		jars = append(jars, markerJar)
+	}
+
 	// Combine the classes built from sources, any manifests, and any static libraries into
 	// classes.jar. If there is only one input jar this step will be skipped.
 	var outputFile android.OutputPath
@@ -1529,7 +2053,7 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		} else {
 			// The file is not in the out directory so create an OutputPath into which it can be copied
 			// and which the following code can use to refer to it.
-			combinedJar := android.PathForModuleOut(ctx, "combined", jarName)
+			combinedJar := implJarOutPath(ctx, "combined", jarName)
 			ctx.Build(pctx, android.BuildParams{
 				Rule:   android.Cp,
 				Input:  jars[0],
@@ -1538,16 +2062,18 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			outputFile = combinedJar.OutputPath
 		}
 	} else {
-		combinedJar := android.PathForModuleOut(ctx, "combined", jarName)
-		TransformJarsToJar(ctx, combinedJar, "for javac", jars, manifest,
-			false, nil, nil)
+		combinedJar := implJarOutPath(ctx, "combined", jarName)
+		// The module descriptor compiled by javac comes first, so it is kept over any from the
+		// static libraries.
+		transformJarsToJar(ctx, combinedJar, "for javac", jars, manifest,
+			false, nil, nil, j.jpmsModule == "")
 		outputFile = combinedJar.OutputPath
 	}
 
 	// jarjar implementation jar if necessary
 	if j.expandJarjarRules != nil {
 		// Transform classes.jar into classes-jarjar.jar
-		jarjarFile := android.PathForModuleOut(ctx, "jarjar", jarName).OutputPath
+		jarjarFile := implJarOutPath(ctx, "jarjar", jarName).OutputPath
 		TransformJarJar(ctx, jarjarFile, outputFile, j.expandJarjarRules)
 		outputFile = jarjarFile
 
@@ -1572,7 +2098,7 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		// will check that the jar only contains the permitted packages. The new location will become
 		// the output file of this module.
 		inputFile := outputFile
-		outputFile = android.PathForModuleOut(ctx, "package-check", jarName).OutputPath
+		outputFile = implJarOutPath(ctx, "package-check", jarName).OutputPath
 		ctx.Build(pctx, android.BuildParams{
 			Rule:   android.Cp,
 			Input:  inputFile,
@@ -1590,13 +2116,27 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		}
 	}
 
+	// Check that no package is split across static_libs if necessary.
+	if j.splitPackagesCheckFile != nil {
+		// Like the package check above, make any dependency on the output file run the split
+		// package check rule.
+		inputFile := outputFile
+		outputFile = implJarOutPath(ctx, "split-package-check", jarName).OutputPath
+		ctx.Build(pctx, android.BuildParams{
+			Rule:       android.Cp,
+			Input:      inputFile,
+			Output:     outputFile,
+			Validation: j.splitPackagesCheckFile,
+		})
+	}
+
 	j.implementationJarFile = outputFile
 	if j.headerJarFile == nil {
 		// If this module couldn't generate a header jar (for example due to api generating annotation processors)
 		// then use the implementation jar.  Run it through zip2zip first to remove any files in META-INF/services
 		// so that javac on modules that depend on this module don't pick up annotation processors (which may be
 		// missing their implementations) from META-INF/services/javax.annotation.processing.Processor.
-		headerJarFile := android.PathForModuleOut(ctx, "javac-header", jarName)
+		headerJarFile := headerJarOutPath(ctx, "javac-header", jarName)
 		convertImplementationJarToHeaderJar(ctx, j.implementationJarFile, headerJarFile)
 		j.headerJarFile = headerJarFile
 	}
@@ -1616,8 +2156,8 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	if j.resourceJar != nil {
 		jars := android.Paths{j.resourceJar, implementationAndResourcesJar}
 		combinedJar := android.PathForModuleOut(ctx, "withres", jarName).OutputPath
-		TransformJarsToJar(ctx, combinedJar, "for resources", jars, manifest,
-			false, nil, nil)
+		transformJarsToJar(ctx, combinedJar, "for resources", jars, manifest,
+			false, nil, nil, j.jpmsModule == "")
 		implementationAndResourcesJar = combinedJar
 	}
 
@@ -1680,7 +2220,8 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 					false, nil, nil)
 				if *j.dexProperties.Uncompress_dex {
 					combinedAlignedJar := android.PathForModuleOut(ctx, "dex-withres-aligned", jarName).OutputPath
-					TransformZipAlign(ctx, combinedAlignedJar, combinedJar, nil)
+					TransformZipAlignWithAlignment(ctx, combinedAlignedJar, combinedJar, nil,
+						dexAlignment(ctx, j.dexProperties.Dex_align))
 					dexOutputFile = combinedAlignedJar
 				} else {
 					dexOutputFile = combinedJar
@@ -1701,6 +2242,7 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			if j.SdkLibraryName() != nil && strings.HasSuffix(ctx.ModuleName(), ".impl") {
 				libName = strings.TrimSuffix(libName, ".impl")
 			}
+			j.dexpreopter.compactDex = j.dexProperties.Compact_dex
 			j.dexpreopt(ctx, libName, dexOutputFile)
 
 			outputFile = dexOutputFile
@@ -1715,6 +2257,9 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 			return
 		}
 	} else {
+		if ctx.Device() && j.dexProperties.Compact_dex != nil {
+			ctx.PropertyErrorf("compact_dex", "only applies to modules that are compiled to dex, set installable or compile_dex")
+		}
 		outputFile = implementationAndResourcesJar
 	}
 
@@ -1746,9 +2291,57 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 	}
 
 	j.collectTransitiveSrcFiles(ctx, srcFiles)
+	j.transitiveResourceJars = collectTransitive(ctx, j.localResourceJars, transitiveResourceJars, nil, staticLibTag)
+	j.transitiveJvmFlags = collectTransitive(ctx, j.properties.Runtime_jvm_flags, transitiveJvmFlags, nil, staticLibTag)
+	checkKotlincFlags(ctx, "exported_kotlincflags", j.properties.Exported_kotlincflags)
+	j.transitiveExportedKotlincFlags = collectTransitive(ctx, j.properties.Exported_kotlincflags,
+		transitiveExportedKotlincFlags, nil, staticLibTag)
+
+	if proptools.Bool(j.properties.Emit_deps_header_zip) {
+		j.depsHeaderZip = j.buildDepsHeaderZip(ctx)
+	}
+
+	if proptools.Bool(j.properties.Emit_service_usage) {
+		j.serviceUsage = android.PathForModuleOut(ctx, ctx.ModuleName()+"-service-usage.txt")
+		TransformJarToServiceUsage(ctx, j.serviceUsage, j.implementationJarFile)
+	}
+
+	if proptools.Bool(j.properties.Emit_native_methods) {
+		j.nativeMethods = android.PathForModuleOut(ctx, ctx.ModuleName()+"-native-methods.txt")
+		TransformJarToNativeMethods(ctx, j.nativeMethods, j.implementationJarFile)
+	}
+
+	j.implementationJarChecksum = android.PathForModuleOut(ctx, ctx.ModuleName()+".jar.sha256")
+	TransformJarToChecksum(ctx, j.implementationJarChecksum, j.implementationJarFile)
 
 	ctx.CheckbuildFile(outputFile)
 
+	if len(j.kytheFiles) > 0 {
+		android.SetProvider(ctx, KytheInfoProvider, KytheInfo{XrefJavaFiles: j.kytheFiles})
+	}
+
+	depCount := 0
+	ctx.VisitDirectDeps(func(android.Module) { depCount++ })
+	android.SetProvider(ctx, BuildCostInfoProvider, BuildCostInfo{
+		SrcCount: len(j.uniqueSrcFiles),
+		DepCount: depCount,
+		Dex:      j.dexJarFile.IsSet(),
+		Optimize: j.dexJarFile.IsSet() && j.dexer.effectiveOptimizeEnabled(),
+	})
+
+	if name := j.properties.Export_resources_as; name != nil {
+		if j.resourceJar == nil {
+			ctx.PropertyErrorf("export_resources_as", "module has no java resources to export")
+		} else {
+			j.exportedResourcesJar = android.PathForModuleOut(ctx, "exported_resources", *name+".jar")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:   android.Cp,
+				Input:  j.resourceJar,
+				Output: j.exportedResourcesJar,
+			})
+		}
+	}
+
 	android.SetProvider(ctx, JavaInfoProvider, JavaInfo{
 		HeaderJars:                          android.PathsIfNonNil(j.headerJarFile),
 		RepackagedHeaderJars:                android.PathsIfNonNil(j.repackagedHeaderJarFile),
@@ -1757,22 +2350,66 @@ func (j *Module) compile(ctx android.ModuleContext, extraSrcJars, extraClasspath
 		ImplementationAndResourcesJars:      android.PathsIfNonNil(j.implementationAndResourcesJar),
 		ImplementationJars:                  android.PathsIfNonNil(j.implementationJarFile),
 		ResourceJars:                        android.PathsIfNonNil(j.resourceJar),
+		LocalResourceJars:                   j.localResourceJars,
 		AidlIncludeDirs:                     j.exportAidlIncludeDirs,
 		SrcJarArgs:                          j.srcJarArgs,
 		SrcJarDeps:                          j.srcJarDeps,
 		TransitiveSrcFiles:                  j.transitiveSrcFiles,
+		TransitiveResourceJars:              j.transitiveResourceJars,
+		TransitiveJvmFlags:                  j.transitiveJvmFlags,
+		TransitiveExportedKotlincFlags:      j.transitiveExportedKotlincFlags,
 		ExportedPlugins:                     j.exportedPluginJars,
 		ExportedPluginClasses:               j.exportedPluginClasses,
 		ExportedPluginDisableTurbine:        j.exportedDisableTurbine,
+		ExportedPluginGeneratesApiOptions:   j.exportedGeneratesApiOptions,
+		ClasspathPriority:                   proptools.Int(j.properties.Classpath_priority),
+		JpmsModuleName:                      j.jpmsModule,
 		JacocoReportClassesFile:             j.jacocoReportClassesFile,
 		StubsLinkType:                       j.stubsLinkType,
 		AconfigIntermediateCacheOutputPaths: j.aconfigCacheFiles,
+		TransitiveAconfigFiles:              j.transitiveAconfigFiles,
+		Neverlink:                           proptools.Bool(j.properties.Neverlink),
 	})
 
 	// Save the output file with no relative path so that it doesn't end up in a subdirectory when used as a resource
 	j.outputFile = outputFile.WithoutRel()
 }
 
+// buildDepsHeaderZip packages the header jars of all transitive libs and static_libs dependencies
+// into a single zip. Each jar appears once, in the order it is first found in the depsets.
+func (j *Module) buildDepsHeaderZip(ctx android.ModuleContext) android.Path {
+	var headerJars android.Paths
+	if j.transitiveLibsHeaderJars != nil {
+		headerJars = append(headerJars, j.transitiveLibsHeaderJars.ToList()...)
+	}
+	if j.transitiveStaticLibsHeaderJars != nil {
+		headerJars = append(headerJars, j.transitiveStaticLibsHeaderJars.ToList()...)
+	}
+	headerJars = android.FirstUniquePaths(headerJars)
+
+	// The header jars are stored at the root of the zip so that its entries don't depend on the
+	// output directory, which requires their names to be unique.
+	seen := make(map[string]android.Path)
+	for _, jar := range headerJars {
+		if other, ok := seen[jar.Base()]; ok {
+			ctx.PropertyErrorf("emit_deps_header_zip", "header jars %s and %s have the same name %q",
+				other, jar, jar.Base())
+			continue
+		}
+		seen[jar.Base()] = jar
+	}
+
+	depsHeaderZip := android.PathForModuleOut(ctx, ctx.ModuleName()+"-deps-headers.zip")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("soong_zip").
+		FlagWithOutput("-o ", depsHeaderZip).
+		Flag("-j").
+		FlagWithRspFileInputList("-r ", depsHeaderZip.ReplaceExtension(ctx, "rsp"), headerJars)
+	rule.Build("deps_header_zip", "zip transitive header jars")
+	return depsHeaderZip
+}
+
 func (j *Module) useCompose() bool {
 	return android.InList("androidx.compose.runtime_runtime", j.properties.Static_libs)
 }
@@ -1795,6 +2432,88 @@ func collectDepProguardSpecInfo(ctx android.ModuleContext) (transitiveProguardFl
 	return transitiveProguardFlags, transitiveUnconditionalExportedFlags
 }
 
+// collectProguardFlagsFileOwners returns a depset pairing the given proguard flags files of this
+// module and the proguard flags files of all its transitive deps with the module providing them.
+func collectProguardFlagsFileOwners(ctx android.ModuleContext, proguardFlagsForThisModule android.Paths) *android.DepSet[ProguardFlagsFileOwner] {
+	var direct []ProguardFlagsFileOwner
+	for _, f := range proguardFlagsForThisModule {
+		direct = append(direct, ProguardFlagsFileOwner{File: f, Module: ctx.ModuleName()})
+	}
+	var transitive []*android.DepSet[ProguardFlagsFileOwner]
+	ctx.VisitDirectDeps(func(m android.Module) {
+		depProguardInfo, _ := android.OtherModuleProvider(ctx, m, ProguardSpecInfoProvider)
+		if depProguardInfo.ProguardFlagsFileOwners != nil {
+			transitive = append(transitive, depProguardInfo.ProguardFlagsFileOwners)
+		}
+	})
+	return android.NewDepSet[ProguardFlagsFileOwner](android.POSTORDER, direct, transitive)
+}
+
+// buildProguardFlagsProvenance writes a file listing each proguard flags file used by this module,
+// the module that provides it and the direct dependency it was inherited through, if any.
+func (j *Module) buildProguardFlagsProvenance(ctx android.ModuleContext) android.Path {
+	var lines []string
+	for _, f := range android.PathsForModuleSrc(ctx, j.dexProperties.Optimize.Proguard_flags_files) {
+		lines = append(lines, fmt.Sprintf("%s %s self", f, ctx.ModuleName()))
+	}
+	ctx.VisitDirectDeps(func(m android.Module) {
+		depProguardInfo, ok := android.OtherModuleProvider(ctx, m, ProguardSpecInfoProvider)
+		if !ok {
+			return
+		}
+		// Mirror collectDepProguardSpecInfo: unconditionally exported flags are inherited across
+		// any edge, all other flags only across static_libs edges.
+		edge := "libs"
+		files := depProguardInfo.UnconditionallyExportedProguardFlags.ToList()
+		if ctx.OtherModuleDependencyTag(m) == staticLibTag {
+			edge = "static_libs"
+			files = append(files, depProguardInfo.ProguardFlagsFiles.ToList()...)
+		}
+		owners := make(map[string]string)
+		for _, owner := range depProguardInfo.ProguardFlagsFileOwners.ToList() {
+			owners[owner.File.String()] = owner.Module
+		}
+		for _, f := range android.FirstUniquePaths(files) {
+			lines = append(lines, fmt.Sprintf("%s %s %s %s", f, owners[f.String()], edge, ctx.OtherModuleName(m)))
+		}
+	})
+
+	provenance := android.PathForModuleOut(ctx, ctx.ModuleName()+"-proguard-flags-provenance.txt")
+	android.WriteFileRule(ctx, provenance, strings.Join(lines, "\n"))
+	return provenance
+}
+
+// excludeProguardFlagsFiles returns the given proguard flags files inherited from dependencies
+// without the ones matching optimize.proguard_flags_files_exclude by the name of the module
+// providing them or by their basename, and prints a warning listing the excluded files.
+func (j *Module) excludeProguardFlagsFiles(ctx android.ModuleContext, files android.Paths) android.Paths {
+	excludes := j.dexProperties.Optimize.Proguard_flags_files_exclude
+	if len(excludes) == 0 {
+		return files
+	}
+	owners := make(map[android.Path]string)
+	for _, owner := range collectProguardFlagsFileOwners(ctx, nil).ToList() {
+		owners[owner.File] = owner.Module
+	}
+	own := android.PathsForModuleSrc(ctx, j.dexProperties.Optimize.Proguard_flags_files)
+	var kept android.Paths
+	var excluded []string
+	for _, f := range files {
+		if android.InList(f, own) {
+			kept = append(kept, f)
+		} else if android.InList(f.Base(), excludes) || android.InList(owners[f], excludes) {
+			excluded = append(excluded, f.String())
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if len(excluded) > 0 {
+		fmt.Printf("Warning: Module '%s' excludes proguard flags files: %s\n", ctx.ModuleName(),
+			strings.Join(excluded, " "))
+	}
+	return kept
+}
+
 func (j *Module) collectProguardSpecInfo(ctx android.ModuleContext) ProguardSpecInfo {
 	transitiveProguardFlags, transitiveUnconditionalExportedFlags := collectDepProguardSpecInfo(ctx)
 
@@ -1819,10 +2538,17 @@ func (j *Module) collectProguardSpecInfo(ctx android.ModuleContext) ProguardSpec
 			directUnconditionalExportedFlags,
 			transitiveUnconditionalExportedFlags,
 		),
+		ProguardFlagsFileOwners: collectProguardFlagsFileOwners(ctx, proguardFlagsForThisModule),
 	}
 
 }
 
+// errorproneVariantMode returns true if errorprone runs in a separate rule that doesn't block the
+// regular build.
+func (j *Module) errorproneVariantMode() bool {
+	return Bool(j.properties.Errorprone.Variant_mode) && BoolDefault(j.properties.Errorprone.Enabled, true)
+}
+
 // Returns a copy of the supplied flags, but with all the errorprone-related
 // fields copied to the regular build's fields.
 func enableErrorproneFlags(flags javaBuilderFlags) javaBuilderFlags {
@@ -1838,6 +2564,24 @@ func enableErrorproneFlags(flags javaBuilderFlags) javaBuilderFlags {
 	return flags
 }
 
+// headerJarOutPath returns the path of a header jar in dir in the module's output directory, which
+// is under headers/ when Config.SeparateJarOutputDirs is enabled.
+func headerJarOutPath(ctx android.ModuleContext, dir, jarName string) android.ModuleOutPath {
+	if ctx.Config().SeparateJarOutputDirs() {
+		return android.PathForModuleOut(ctx, "headers", dir, jarName)
+	}
+	return android.PathForModuleOut(ctx, dir, jarName)
+}
+
+// implJarOutPath returns the path of an implementation jar in dir in the module's output
+// directory, which is under impl/ when Config.SeparateJarOutputDirs is enabled.
+func implJarOutPath(ctx android.ModuleContext, dir, jarName string) android.ModuleOutPath {
+	if ctx.Config().SeparateJarOutputDirs() {
+		return android.PathForModuleOut(ctx, "impl", dir, jarName)
+	}
+	return android.PathForModuleOut(ctx, dir, jarName)
+}
+
 func (j *Module) compileJavaClasses(ctx android.ModuleContext, jarName string, idx int,
 	srcFiles, srcJars android.Paths, flags javaBuilderFlags, extraJarDeps android.Paths) android.WritablePath {
 
@@ -1849,7 +2593,7 @@ func (j *Module) compileJavaClasses(ctx android.ModuleContext, jarName string, i
 		jarName += strconv.Itoa(idx)
 	}
 
-	classes := android.PathForModuleOut(ctx, "javac", jarName).OutputPath
+	classes := implJarOutPath(ctx, "javac", jarName).OutputPath
 	TransformJavaToClasses(ctx, classes, idx, srcFiles, srcJars, annoSrcJar, flags, extraJarDeps)
 
 	if ctx.Config().EmitXrefRules() && ctx.Module() == ctx.PrimaryModule() {
@@ -1868,22 +2612,27 @@ func (j *Module) compileJavaClasses(ctx android.ModuleContext, jarName string, i
 // Check for invalid kotlinc flags. Only use this for flags explicitly passed by the user,
 // since some of these flags may be used internally.
 func CheckKotlincFlags(ctx android.ModuleContext, flags []string) {
+	checkKotlincFlags(ctx, "kotlincflags", flags)
+}
+
+// checkKotlincFlags reports the flags that can't be passed to kotlinc as errors in property.
+func checkKotlincFlags(ctx android.ModuleContext, property string, flags []string) {
 	for _, flag := range flags {
 		flag = strings.TrimSpace(flag)
 
 		if !strings.HasPrefix(flag, "-") {
-			ctx.PropertyErrorf("kotlincflags", "Flag `%s` must start with `-`", flag)
+			ctx.PropertyErrorf(property, "Flag `%s` must start with `-`", flag)
 		} else if strings.HasPrefix(flag, "-Xintellij-plugin-root") {
-			ctx.PropertyErrorf("kotlincflags",
+			ctx.PropertyErrorf(property,
 				"Bad flag: `%s`, only use internal compiler for consistency.", flag)
 		} else if inList(flag, config.KotlincIllegalFlags) {
-			ctx.PropertyErrorf("kotlincflags", "Flag `%s` already used by build system", flag)
+			ctx.PropertyErrorf(property, "Flag `%s` already used by build system", flag)
 		} else if flag == "-include-runtime" {
-			ctx.PropertyErrorf("kotlincflags", "Bad flag: `%s`, do not include runtime.", flag)
+			ctx.PropertyErrorf(property, "Bad flag: `%s`, do not include runtime.", flag)
 		} else {
 			args := strings.Split(flag, " ")
 			if args[0] == "-kotlin-home" {
-				ctx.PropertyErrorf("kotlincflags",
+				ctx.PropertyErrorf(property,
 					"Bad flag: `%s`, kotlin home already set to default (path to kotlinc in the repo).", flag)
 			}
 		}
@@ -1897,13 +2646,14 @@ func (j *Module) compileJavaHeader(ctx android.ModuleContext, srcFiles, srcJars
 	var jars android.Paths
 	if len(srcFiles) > 0 || len(srcJars) > 0 {
 		// Compile java sources into turbine.jar.
-		turbineJar := android.PathForModuleOut(ctx, "turbine", jarName)
+		turbineJar := headerJarOutPath(ctx, "turbine", jarName)
 		TransformJavaToHeaderClasses(ctx, turbineJar, srcFiles, srcJars, flags)
 		if ctx.Failed() {
 			return nil, nil, nil
 		}
 		jars = append(jars, turbineJar)
 		headerJar = turbineJar
+		j.turbineJarFile = turbineJar
 	}
 
 	jars = append(jars, extraJars...)
@@ -1914,14 +2664,14 @@ func (j *Module) compileJavaHeader(ctx android.ModuleContext, srcFiles, srcJars
 
 	// we cannot skip the combine step for now if there is only one jar
 	// since we have to strip META-INF/TRANSITIVE dir from turbine.jar
-	combinedJar := android.PathForModuleOut(ctx, "turbine-combined", jarName)
+	combinedJar := headerJarOutPath(ctx, "turbine-combined", jarName)
 	TransformJarsToJar(ctx, combinedJar, "for turbine", jars, android.OptionalPath{},
 		false, nil, []string{"META-INF/TRANSITIVE"})
 	jarjarAndDepsHeaderJar = combinedJar
 
 	if j.expandJarjarRules != nil {
 		// Transform classes.jar into classes-jarjar.jar
-		jarjarFile := android.PathForModuleOut(ctx, "turbine-jarjar", jarName)
+		jarjarFile := headerJarOutPath(ctx, "turbine-jarjar", jarName)
 		TransformJarJar(ctx, jarjarFile, jarjarAndDepsHeaderJar, j.expandJarjarRules)
 		jarjarAndDepsHeaderJar = jarjarFile
 		if ctx.Failed() {
@@ -1930,7 +2680,7 @@ func (j *Module) compileJavaHeader(ctx android.ModuleContext, srcFiles, srcJars
 	}
 
 	if j.repackageJarjarRules != nil {
-		repackagedJarjarFile := android.PathForModuleOut(ctx, "repackaged-turbine-jarjar", jarName)
+		repackagedJarjarFile := headerJarOutPath(ctx, "repackaged-turbine-jarjar", jarName)
 		TransformJarJar(ctx, repackagedJarjarFile, jarjarAndDepsHeaderJar, j.repackageJarjarRules)
 		jarjarAndDepsRepackagedHeaderJar = repackagedJarjarFile
 		if ctx.Failed() {
@@ -2065,7 +2815,7 @@ func (j *Module) CompilerDeps() []string {
 }
 
 func (j *Module) hasCode(ctx android.ModuleContext) bool {
-	srcFiles := android.PathsForModuleSrcExcludes(ctx, j.properties.Srcs, j.properties.Exclude_srcs)
+	srcFiles := android.PathsForModuleSrcExcludes(ctx, j.srcs(), j.properties.Exclude_srcs)
 	return len(srcFiles) > 0 || len(ctx.GetDirectDepsWithTag(staticLibTag)) > 0
 }
 
@@ -2091,6 +2841,74 @@ func (j *Module) ShouldSupportSdkVersion(ctx android.BaseModuleContext, sdkVersi
 	return nil
 }
 
+// versionStampPlaceholder replaces the build number and fingerprint in version_stamp resources
+// when they are not set, so that the generated resource stays reproducible.
+const versionStampPlaceholder = "unknown"
+
+// buildVersionStamp generates the version_stamp resource, if any, and returns its path.
+func (j *Module) buildVersionStamp(ctx android.ModuleContext) android.Path {
+	template := j.properties.Version_stamp.Template
+	output := j.properties.Version_stamp.Output
+	if template == nil && output == nil {
+		return nil
+	}
+	if template == nil || output == nil {
+		ctx.PropertyErrorf("version_stamp", "template and output must be set together")
+		return nil
+	}
+
+	// Read the files without depending on them, as they change on every build.
+	var orderOnly android.Paths
+	buildNumber, buildFingerprint := versionStampPlaceholder, versionStampPlaceholder
+	productVariables := ctx.Config().ProductVariables()
+	if String(productVariables.BuildNumberFile) != "" {
+		buildNumberFile := ctx.Config().BuildNumberFile(ctx)
+		buildNumber = "$$(cat " + buildNumberFile.String() + ")"
+		orderOnly = append(orderOnly, buildNumberFile)
+	}
+	if String(productVariables.BuildFingerprintFile) != "" {
+		buildFingerprintFile := ctx.Config().BuildFingerprintFile(ctx)
+		buildFingerprint = "$$(cat " + buildFingerprintFile.String() + ")"
+		orderOnly = append(orderOnly, buildFingerprintFile)
+	}
+
+	versionStamp := android.PathForModuleGen(ctx, "version_stamp").Join(ctx, *output)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        versionStampRule,
+		Description: "version stamp",
+		Input:       android.PathForModuleSrc(ctx, *template),
+		Output:      versionStamp,
+		OrderOnly:   orderOnly,
+		Args: map[string]string{
+			"buildNumber":      buildNumber,
+			"buildFingerprint": buildFingerprint,
+		},
+	})
+	return versionStamp
+}
+
+// buildProvenance generates the META-INF/provenance.json resource and returns its path.  It only
+// depends on build inputs so that it is the same for identical builds.
+func (j *Module) buildProvenance(ctx android.ModuleContext, srcFiles, srcJars android.Paths,
+	flags javaBuilderFlags) android.Path {
+
+	srcs := android.SortedUniquePaths(append(slices.Clone(srcFiles), srcJars...))
+
+	provenanceFile := android.PathForModuleGen(ctx, "provenance").Join(ctx, "META-INF/provenance.json")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        provenance,
+		Description: "provenance",
+		Inputs:      srcs,
+		Output:      provenanceFile,
+		Args: map[string]string{
+			"module":      ctx.ModuleName(),
+			"javaVersion": flags.javaVersion.String(),
+			"javacFlags":  flags.javacFlags,
+		},
+	})
+	return provenanceFile
+}
+
 func (j *Module) Stem() string {
 	if j.stem == "" {
 		panic("Stem() called before stem property was set")
@@ -2117,6 +2935,83 @@ func (j *Module) collectTransitiveSrcFiles(ctx android.ModuleContext, mine andro
 	j.transitiveSrcFiles = android.NewDepSet(android.POSTORDER, mine, fromDeps)
 }
 
+// buildJavaResourcesJar packages java_resource_dirs, java_resources and the extra resources of the
+// module into their own jar, and returns nil if there are no resources.  The resources are kept out
+// of the inputs of the turbine and javac actions, so that changing a resource only rebuilds this
+// jar and the jars it is merged into, and not the classes.
+func (j *Module) buildJavaResourcesJar(ctx android.ModuleContext, jarName string) android.Path {
+	dirArgs, dirDeps := ResourceDirsToJarArgs(ctx, j.properties.Java_resource_dirs,
+		j.properties.Exclude_java_resource_dirs, j.properties.Exclude_java_resources)
+	fileArgs, fileDeps := ResourceFilesToJarArgs(ctx, j.properties.Java_resources, j.properties.Exclude_java_resources)
+	extraArgs, extraDeps := resourcePathsToJarArgs(j.extraResources), j.extraResources
+
+	var resArgs []string
+	var resDeps android.Paths
+
+	resArgs = append(resArgs, dirArgs...)
+	resDeps = append(resDeps, dirDeps...)
+
+	resArgs = append(resArgs, fileArgs...)
+	resDeps = append(resDeps, fileDeps...)
+
+	resArgs = append(resArgs, extraArgs...)
+	resDeps = append(resDeps, extraDeps...)
+
+	if len(resArgs) == 0 {
+		return nil
+	}
+	resourceJar := android.PathForModuleOut(ctx, "res", jarName)
+	TransformResourcesToJar(ctx, resourceJar, resArgs, resDeps)
+	return resourceJar
+}
+
+// orderByClasspathPriority returns the jars sorted in decreasing order of their classpath priority,
+// keeping the order of jars with the same priority.
+func orderByClasspathPriority(jars android.Paths, priorities map[android.Path]int) android.Paths {
+	ordered := slices.Clone(jars)
+	slices.SortStableFunc(ordered, func(a, b android.Path) int {
+		return priorities[b] - priorities[a]
+	})
+	return ordered
+}
+
+// collectTransitive returns a depset of the given items of this module and the depsets returned by
+// get for the JavaInfo of the direct dependencies with one of tags, other than the dependencies
+// listed in excludes.
+func collectTransitive[T comparable](ctx android.ModuleContext, mine []T, get func(JavaInfo) *android.DepSet[T],
+	excludes []string, tags ...blueprint.DependencyTag) *android.DepSet[T] {
+	var fromDeps []*android.DepSet[T]
+	ctx.VisitDirectDeps(func(module android.Module) {
+		tag := ctx.OtherModuleDependencyTag(module)
+		if slices.Contains(tags, tag) && !android.InList(ctx.OtherModuleName(module), excludes) {
+			depInfo, _ := android.OtherModuleProvider(ctx, module, JavaInfoProvider)
+			if depSet := get(depInfo); depSet != nil {
+				fromDeps = append(fromDeps, depSet)
+			}
+		}
+	})
+
+	return android.NewDepSet(android.POSTORDER, mine, fromDeps)
+}
+
+// transitiveResourceJars, transitiveJvmFlags, transitiveExportedKotlincFlags and
+// transitiveAconfigFiles return the matching depsets of a JavaInfo for collectTransitive.
+func transitiveResourceJars(info JavaInfo) *android.DepSet[android.Path] {
+	return info.TransitiveResourceJars
+}
+
+func transitiveJvmFlags(info JavaInfo) *android.DepSet[string] {
+	return info.TransitiveJvmFlags
+}
+
+func transitiveExportedKotlincFlags(info JavaInfo) *android.DepSet[string] {
+	return info.TransitiveExportedKotlincFlags
+}
+
+func transitiveAconfigFiles(info JavaInfo) *android.DepSet[android.Path] {
+	return info.TransitiveAconfigFiles
+}
+
 func (j *Module) IsInstallable() bool {
 	return Bool(j.properties.Installable)
 }
@@ -2296,14 +3191,21 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 					ctx.ModuleErrorf("a java_plugin (%s) cannot be used as a libs dependency", otherName)
 				}
 				deps.classpath = append(deps.classpath, dep.HeaderJars...)
-				deps.dexClasspath = append(deps.dexClasspath, dep.HeaderJars...)
+				if !dep.Neverlink {
+					deps.dexClasspath = append(deps.dexClasspath, dep.HeaderJars...)
+				}
 				if len(dep.RepackagedHeaderJars) == 1 && !slices.Contains(dep.HeaderJars, dep.RepackagedHeaderJars[0]) {
 					deps.classpath = append(deps.classpath, dep.RepackagedHeaderJars...)
-					deps.dexClasspath = append(deps.dexClasspath, dep.RepackagedHeaderJars...)
+					if !dep.Neverlink {
+						deps.dexClasspath = append(deps.dexClasspath, dep.RepackagedHeaderJars...)
+					}
 				}
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs...)
+				deps.aidlIncludeDeps = append(deps.aidlIncludeDeps, dep.AidlIncludeDeps...)
 				addPlugins(&deps, dep.ExportedPlugins, dep.ExportedPluginClasses...)
 				deps.disableTurbine = deps.disableTurbine || dep.ExportedPluginDisableTurbine
+				addGeneratesApiOptions(&deps, dep.ExportedPluginGeneratesApiOptions)
+				deps.exportedKotlincFlags = append(deps.exportedKotlincFlags, dep.TransitiveExportedKotlincFlags.ToList()...)
 			case java9LibTag:
 				deps.java9Classpath = append(deps.java9Classpath, dep.HeaderJars...)
 			case staticLibTag:
@@ -2311,16 +3213,33 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 					ctx.ModuleErrorf("a java_plugin (%s) cannot be used as a static_libs dependency", otherName)
 				}
 				deps.classpath = append(deps.classpath, dep.HeaderJars...)
-				deps.staticJars = append(deps.staticJars, dep.ImplementationJars...)
-				deps.staticHeaderJars = append(deps.staticHeaderJars, dep.HeaderJars...)
-				deps.staticResourceJars = append(deps.staticResourceJars, dep.ResourceJars...)
+				// A neverlink library is only compiled against, it is never packaged into the
+				// jars of the modules that depend on it.
+				if !dep.Neverlink {
+					deps.staticJars = append(deps.staticJars, dep.ImplementationJars...)
+					if dep.ClasspathPriority != 0 {
+						if deps.staticJarsClasspathPriority == nil {
+							deps.staticJarsClasspathPriority = make(map[android.Path]int)
+						}
+						for _, jar := range dep.ImplementationJars {
+							deps.staticJarsClasspathPriority[jar] = dep.ClasspathPriority
+						}
+					}
+					deps.staticHeaderJars = append(deps.staticHeaderJars, dep.HeaderJars...)
+					deps.staticResourceJars = append(deps.staticResourceJars, dep.ResourceJars...)
+				}
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs...)
+				deps.aidlIncludeDeps = append(deps.aidlIncludeDeps, dep.AidlIncludeDeps...)
 				addPlugins(&deps, dep.ExportedPlugins, dep.ExportedPluginClasses...)
 				// Turbine doesn't run annotation processors, so any module that uses an
 				// annotation processor that generates API is incompatible with the turbine
 				// optimization.
 				deps.disableTurbine = deps.disableTurbine || dep.ExportedPluginDisableTurbine
-				deps.aconfigProtoFiles = append(deps.aconfigProtoFiles, dep.AconfigIntermediateCacheOutputPaths...)
+				addGeneratesApiOptions(&deps, dep.ExportedPluginGeneratesApiOptions)
+				deps.exportedKotlincFlags = append(deps.exportedKotlincFlags, dep.TransitiveExportedKotlincFlags.ToList()...)
+				if !android.InList(otherName, j.properties.Exclude_aconfig_files) {
+					deps.aconfigProtoFiles = append(deps.aconfigProtoFiles, dep.AconfigIntermediateCacheOutputPaths...)
+				}
 			case pluginTag:
 				if plugin, ok := module.(*Plugin); ok {
 					if plugin.pluginProperties.Processor_class != nil {
@@ -2332,9 +3251,14 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 					// annotation processor that generates API is incompatible with the turbine
 					// optimization.
 					deps.disableTurbine = deps.disableTurbine || Bool(plugin.pluginProperties.Generates_api)
+					if option := plugin.pluginProperties.Generates_api_when_option; option != nil {
+						deps.generatesApiOptions = append(deps.generatesApiOptions, *option)
+					}
 				} else {
 					ctx.PropertyErrorf("plugins", "%q is not a java_plugin module", otherName)
 				}
+			case processorClasspathTag:
+				deps.processorPath = append(deps.processorPath, dep.ImplementationAndResourcesJars...)
 			case errorpronePluginTag:
 				if _, ok := module.(*Plugin); ok {
 					deps.errorProneProcessorPath = append(deps.errorProneProcessorPath, dep.ImplementationAndResourcesJars...)
@@ -2349,8 +3273,15 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 					}
 					// Turbine doesn't run annotation processors, so any module that uses an
 					// annotation processor that generates API is incompatible with the turbine
-					// optimization.
-					j.exportedDisableTurbine = Bool(plugin.pluginProperties.Generates_api)
+					// optimization.  Any exported plugin that generates API disables turbine, not only
+					// the last one listed.
+					j.exportedDisableTurbine = j.exportedDisableTurbine || Bool(plugin.pluginProperties.Generates_api)
+					if option := plugin.pluginProperties.Generates_api_when_option; option != nil {
+						if j.exportedGeneratesApiOptions == nil {
+							j.exportedGeneratesApiOptions = make(map[string]string)
+						}
+						j.exportedGeneratesApiOptions[plugin.processorKey()] = *option
+					}
 				} else {
 					ctx.PropertyErrorf("exported_plugins", "%q is not a java_plugin module", otherName)
 				}
@@ -2382,7 +3313,9 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 		} else if dep, ok := android.OtherModuleProvider(ctx, module, android.CodegenInfoProvider); ok {
 			switch tag {
 			case staticLibTag:
-				deps.aconfigProtoFiles = append(deps.aconfigProtoFiles, dep.IntermediateCacheOutputPaths...)
+				if !android.InList(otherName, j.properties.Exclude_aconfig_files) {
+					deps.aconfigProtoFiles = append(deps.aconfigProtoFiles, dep.IntermediateCacheOutputPaths...)
+				}
 			}
 		} else {
 			switch tag {
@@ -2562,7 +3495,7 @@ func collectDirectDepsProviders(ctx android.ModuleContext) (result *JarJarProvid
 					return RenameUseExclude, "tagswitch"
 				case staticLibTag:
 					return RenameUseInclude, "tagswitch"
-				case pluginTag:
+				case pluginTag, processorClasspathTag:
 					return RenameUseInclude, "tagswitch"
 				case errorpronePluginTag:
 					return RenameUseInclude, "tagswitch"
@@ -2725,6 +3658,25 @@ func addPlugins(deps *deps, pluginJars android.Paths, pluginClasses ...string) {
 	deps.processorClasses = append(deps.processorClasses, pluginClasses...)
 }
 
+func addGeneratesApiOptions(deps *deps, generatesApiOptions map[string]string) {
+	for _, processor := range android.SortedKeys(generatesApiOptions) {
+		deps.generatesApiOptions = append(deps.generatesApiOptions, generatesApiOptions[processor])
+	}
+}
+
+// passesGeneratesApiOption returns true if the javacflags of the module pass one of the given
+// annotation processor options.
+func (j *Module) passesGeneratesApiOption(options []string) bool {
+	for _, option := range options {
+		for _, flag := range j.properties.Javacflags {
+			if flag == "-A"+option || strings.HasPrefix(flag, "-A"+option+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // TODO(b/132357300) Generalize SdkLibrarComponentDependency to non-SDK libraries and merge with
 // this interface.
 type ProvidesUsesLib interface {
@@ -2735,6 +3687,35 @@ func (j *Module) ProvidesUsesLib() *string {
 	return j.usesLibraryProperties.Provides_uses_lib
 }
 
+// declaredUsesLibrary is implemented by modules that declare the name of the <library> they
+// provide, e.g. in a permissions file.
+type declaredUsesLibrary interface {
+	// declaredUsesLibraryName returns the declared <library> name, or "" if none is declared.
+	declaredUsesLibraryName() string
+}
+
+// checkProvidesUsesLib reports an error if the given <uses-library> dependency sets an empty
+// provides_uses_lib, or one that does not match the <library> name that it declares.  A mismatch
+// would put a different name in the class loader context than the one used at runtime.
+func checkProvidesUsesLib(ctx android.ModuleContext, depModule android.Module) {
+	ulib, ok := depModule.(ProvidesUsesLib)
+	if !ok || ulib.ProvidesUsesLib() == nil {
+		return
+	}
+	name := *ulib.ProvidesUsesLib()
+	depName := ctx.OtherModuleName(depModule)
+	if name == "" {
+		ctx.ModuleErrorf("dependency %q sets an empty provides_uses_lib", depName)
+		return
+	}
+	if lib, ok := depModule.(declaredUsesLibrary); ok {
+		if declared := lib.declaredUsesLibraryName(); declared != "" && declared != name {
+			ctx.ModuleErrorf("provides_uses_lib %q of dependency %q does not match the <library> name %q that it declares",
+				name, depName, declared)
+		}
+	}
+}
+
 type ModuleWithStem interface {
 	Stem() string
 }
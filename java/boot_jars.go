@@ -15,6 +15,9 @@
 package java
 
 import (
+	"regexp"
+	"strings"
+
 	"android/soong/android"
 )
 
@@ -52,3 +55,25 @@ func buildRuleForBootJarsPackageCheck(ctx android.ModuleContext, bootDexJarByMod
 	// The droidcore phony target depends on the check-boot-jars phony target
 	ctx.Phony("droidcore", android.PathForPhony(ctx, "check-boot-jars"))
 }
+
+// buildRuleForDexJarPackageCheck generates the build rule that checks that the classes of a boot
+// dex jar are in the permitted packages, or their subpackages, and creates timestamp on success.
+func buildRuleForDexJarPackageCheck(ctx android.ModuleContext, timestamp android.WritablePath,
+	dexJar android.Path, permittedPackages []string) {
+
+	// check_boot_jars matches the package names against the regular expressions of an allow list.
+	var allowList []string
+	for _, pkg := range permittedPackages {
+		allowList = append(allowList, regexp.QuoteMeta(pkg)+`(\..*)?`)
+	}
+	allowListFile := android.PathForModuleOut(ctx, "package-check", "package_allowed_list.txt")
+	android.WriteFileRule(ctx, allowListFile, strings.Join(allowList, "\n"))
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("check_boot_jars").
+		Input(ctx.Config().HostToolPath(ctx, "dexdump")).
+		Input(allowListFile).
+		Input(dexJar).
+		Text("&& touch").Output(timestamp)
+	rule.Build("dex_jar_package_check", "check dex jar packages")
+}
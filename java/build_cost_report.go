@@ -0,0 +1,94 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+)
+
+// This singleton ranks java modules by the estimated cost from their BuildCostInfo and lists the
+// most expensive ones in $OUT/soong/java_build_cost_report.txt.  The report is only generated
+// when SOONG_JAVA_BUILD_COST_REPORT=true is set in the environment, and is built by the
+// java_build_cost_report phony target.
+
+func registerBuildCostReportBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("java_build_cost_report", buildCostReportSingletonFactory)
+}
+
+func buildCostReportSingletonFactory() android.Singleton {
+	return &buildCostReportSingleton{}
+}
+
+type buildCostReportSingleton struct{}
+
+const buildCostReportFileName = "java_build_cost_report.txt"
+
+// buildCostReportMaxEntries is the number of modules listed in the report.
+const buildCostReportMaxEntries = 50
+
+type buildCostReportEntry struct {
+	name    string
+	variant string
+	info    BuildCostInfo
+}
+
+func (s *buildCostReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("SOONG_JAVA_BUILD_COST_REPORT") {
+		return
+	}
+
+	var entries []buildCostReportEntry
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, BuildCostInfoProvider)
+		if !ok {
+			return
+		}
+		entries = append(entries, buildCostReportEntry{
+			name:    ctx.ModuleName(module),
+			variant: ctx.ModuleSubDir(module),
+			info:    info,
+		})
+	})
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].info.Cost() != entries[j].info.Cost() {
+			return entries[i].info.Cost() > entries[j].info.Cost()
+		}
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].variant < entries[j].variant
+	})
+	if len(entries) > buildCostReportMaxEntries {
+		entries = entries[:buildCostReportMaxEntries]
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%d %s %s srcs=%d deps=%d dex=%t optimize=%t",
+			e.info.Cost(), e.name, e.variant, e.info.SrcCount, e.info.DepCount, e.info.Dex, e.info.Optimize))
+	}
+
+	reportPath := android.PathForOutput(ctx, buildCostReportFileName)
+	android.WriteFileRule(ctx, reportPath, strings.Join(lines, "\n"))
+	ctx.Phony("java_build_cost_report", reportPath)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+const buildCostReportBp = `
+	java_library {
+		name: "small",
+		srcs: ["a.java"],
+	}
+
+	java_library {
+		name: "medium",
+		srcs: ["a.java", "b.java", "c.java"],
+		libs: ["small"],
+	}
+
+	java_library {
+		name: "large",
+		srcs: ["a.java", "b.java", "c.java", "d.java", "e.java"],
+		static_libs: ["small", "medium"],
+		installable: true,
+	}
+`
+
+func TestBuildCostReport(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_JAVA_BUILD_COST_REPORT": "true",
+		}),
+	).RunTestWithBp(t, buildCostReportBp)
+
+	report := result.SingletonForTests("java_build_cost_report").Output(buildCostReportFileName)
+	content := android.ContentFromFileRuleForTests(t, result.TestContext, report)
+
+	// The default modules of the fixture are also listed, only check the order of the test modules.
+	var ranked []string
+	lines := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch name := fields[1]; name {
+		case "small", "medium", "large":
+			ranked = append(ranked, name)
+			lines[name] = line
+		}
+	}
+	android.AssertArrayString(t, "ranked modules", []string{"large", "medium", "small"}, ranked)
+	android.AssertStringDoesContain(t, "large module", lines["large"], "srcs=5")
+	android.AssertStringDoesContain(t, "large module", lines["large"], "dex=true")
+	android.AssertStringDoesContain(t, "small module", lines["small"], "srcs=1")
+	android.AssertStringDoesContain(t, "small module", lines["small"], "dex=false")
+}
+
+func TestBuildCostReportDisabledByDefault(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, buildCostReportBp)
+
+	report := result.SingletonForTests("java_build_cost_report").MaybeOutput(buildCostReportFileName)
+	android.AssertBoolEquals(t, "report generated", false, report.Rule != nil)
+}
@@ -49,7 +49,7 @@ var (
 				`${config.SoongJavacWrapper} $javaTemplate${config.JavacCmd} ` +
 				`${config.JavacHeapFlags} ${config.JavacVmFlags} ${config.CommonJdkFlags} ` +
 				`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
-				`-source $javaVersion -target $javaVersion ` +
+				`$javaVersionFlags ` +
 				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list ; fi ) && ` +
 				`$annoSrcJarTemplate${config.SoongZipCmd} -jar -o $annoSrcJar.tmp -C $annoDir -D $annoDir && ` +
 				`$zipTemplate${config.SoongZipCmd} -jar -o $out.tmp -C $outDir -D $outDir && ` +
@@ -86,7 +86,7 @@ var (
 				Platform:     map[string]string{remoteexec.PoolKey: "${config.REJavaPool}"},
 			},
 		}, []string{"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
-			"outDir", "annoDir", "annoSrcJar", "javaVersion"}, nil)
+			"outDir", "annoDir", "annoSrcJar", "javaVersionFlags"}, nil)
 
 	_ = pctx.VariableFunc("kytheCorpus",
 		func(ctx android.PackageVarContext) string { return ctx.Config().XrefCorpusName() })
@@ -251,6 +251,184 @@ var (
 		},
 		"packages")
 
+	// Lists the packages of the classes in each $module:$jar:$excluded entry of $jars, leaving out
+	// the classes also found in the comma separated $excluded jars, and fails if a package is found
+	// in the jars of more than one module.
+	splitPackageCheck = pctx.AndroidStaticRule("splitPackageCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && " +
+				"for entry in $jars; do " +
+				"mod=$${entry%%:*}; rest=$${entry#*:}; jar=$${rest%%:*}; excluded=$${rest#*:}; " +
+				"for e in $$(echo $$excluded | tr , ' '); do unzip -Z1 $$e; done > $out.excluded; " +
+				"unzip -Z1 $$jar | grep '\\.class$$' | grep -v -e '^META-INF/' -e 'module-info\\.class$$' | " +
+				"grep -vxF -f $out.excluded | " +
+				"grep / | sed 's|/[^/]*$$||' | sort -u | sed \"s|^|$$mod |\"; " +
+				"done | sort -u | " +
+				`awk '{ n[$$2]++; mods[$$2] = mods[$$2] " " $$1 } ` +
+				`END { for (p in n) if (n[p] > 1) { pkg = p; gsub("/", ".", pkg); ` +
+				`print "error: package " pkg " is split across static_libs:" mods[p] > "/dev/stderr"; err = 1 } exit err }' && ` +
+				"rm -f $out.excluded && touch $out",
+		},
+		"jars")
+
+	// Fails if a class in $headerJar is missing from $implJar.
+	headerJarClassesCheck = pctx.AndroidStaticRule("headerJarClassesCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && " +
+				"unzip -Z1 $implJar | grep '\\.class$$' | sort > $out.impl && " +
+				"missing=$$(unzip -Z1 $headerJar | grep '\\.class$$' | sort | comm -23 - $out.impl) && " +
+				"rm -f $out.impl && " +
+				`if [ -n "$$missing" ]; then ` +
+				`echo "error: classes in the header jar $headerJar are missing from the implementation jar $implJar:" >&2; ` +
+				`echo "$$missing" >&2; exit 1; fi && ` +
+				"touch $out",
+		},
+		"headerJar", "implJar")
+
+	// Writes a jar whose only entry is an empty META-INF/.nonempty file.
+	emptyJarMarker = pctx.AndroidStaticRule("emptyJarMarker",
+		blueprint.RuleParams{
+			Command: "rm -rf $out $out.tmp && mkdir -p $out.tmp/META-INF && touch $out.tmp/META-INF/.nonempty && " +
+				"${config.SoongZipCmd} -o $out -C $out.tmp -f $out.tmp/META-INF/.nonempty && " +
+				"rm -rf $out.tmp",
+			CommandDeps: []string{"${config.SoongZipCmd}"},
+		})
+
+	// Concatenates the META-INF/services files of the same name found in the input jars, in the
+	// order of the jars, and writes them to a jar that is merged ahead of the input jars.
+	mergeServiceFiles = pctx.AndroidStaticRule("mergeServiceFiles",
+		blueprint.RuleParams{
+			Command: "rm -rf $out $out.tmp && mkdir -p $out.tmp/META-INF/services && " +
+				"for jar in $in; do " +
+				"for f in $$(unzip -Z1 $$jar 'META-INF/services/*' 2>/dev/null | grep -v '/$$'); do " +
+				"unzip -p $$jar $$f >> $out.tmp/$$f && echo >> $out.tmp/$$f; " +
+				"done; done && " +
+				"${config.SoongZipCmd} -o $out -C $out.tmp -D $out.tmp && " +
+				"rm -rf $out.tmp",
+			CommandDeps: []string{"${config.SoongZipCmd}"},
+		})
+
+	versionStampRule = pctx.AndroidStaticRule("versionStamp",
+		blueprint.RuleParams{
+			Command: `sed -e "s|{BUILD_NUMBER}|${buildNumber}|g" ` +
+				`-e "s|{BUILD_FINGERPRINT}|${buildFingerprint}|g" $in > $out`,
+		},
+		"buildNumber", "buildFingerprint")
+
+	// Writes META-INF/provenance.json with the module name, a hash of the sources and a
+	// fingerprint of the javac toolchain and of the flags passed to it.
+	provenance = pctx.AndroidStaticRule("provenance",
+		blueprint.RuleParams{
+			Command: `srcHash=$$(for f in $in; do echo "$$f"; cat "$$f"; done | sha256sum | cut -d' ' -f1) && ` +
+				`toolchainHash=$$( (${config.JavacCmd} -version 2>&1; echo $javaVersion $javacFlags) | sha256sum | cut -d' ' -f1) && ` +
+				`printf '{\n\t"module": "%s",\n\t"source_hash": "%s",\n\t"toolchain_fingerprint": "%s"\n}\n' ` +
+				`"$module" "$$srcHash" "$$toolchainHash" > $out`,
+			CommandDeps: []string{"${config.JavacCmd}"},
+		},
+		"module", "javaVersion", "javacFlags")
+
+	// Disassembles the classes in the jar and prints the class literal loaded last before each call
+	// to ServiceLoader.load, which is the service type in the common ServiceLoader.load(Foo.class)
+	// pattern.  The class names are passed to javap in batches by xargs to stay below the command
+	// line length limit.
+	serviceUsage = pctx.AndroidStaticRule("serviceUsage",
+		blueprint.RuleParams{
+			Command: `unzip -Z1 $in | grep '\.class$$' | grep -v -e '^META-INF/' -e 'module-info\.class$$' | sed 's/\.class$$//' | ` +
+				`tr '\n' '\000' | xargs -0 -r ${config.JavapCmd} -c -p -classpath $in | ` +
+				`awk '/ldc.*\/\/ class / { cls = $$NF } /java\/util\/ServiceLoader\.load/ { if (cls != "") print cls }' | ` +
+				`tr / . | sort -u > $out`,
+			CommandDeps: []string{"${config.JavapCmd}"},
+		})
+
+	// Disassembles the declarations of the classes in the jar and prints the native methods,
+	// prefixed with the name of the class that declares them.  The class names are passed to javap
+	// in batches by xargs to stay below the command line length limit.
+	nativeMethods = pctx.AndroidStaticRule("nativeMethods",
+		blueprint.RuleParams{
+			Command: `unzip -Z1 $in | grep '\.class$$' | grep -v -e '^META-INF/' -e 'module-info\.class$$' | sed 's/\.class$$//' | ` +
+				`tr '\n' '\000' | xargs -0 -r ${config.JavapCmd} -p -classpath $in | ` +
+				`awk '/^[^ ].* \{$$/ { for (i = 1; i < NF; i++) if ($$i == "class" || $$i == "interface" || $$i == "enum") { cls = $$(i + 1); sub(/<.*/, "", cls); break } } ` +
+				`/^ .* native / { sub(/^ +/, ""); sub(/;$$/, ""); print cls ": " $$0 }' | ` +
+				`sort > $out`,
+			CommandDeps: []string{"${config.JavapCmd}"},
+		})
+
+	// Writes the sha256 checksum of the jar.  The checksum only depends on the contents of the jar,
+	// so it is reproducible whenever the jar is.
+	jarChecksum = pctx.AndroidStaticRule("jarChecksum",
+		blueprint.RuleParams{
+			Command: `sha256sum $in | cut -d' ' -f1 > $out`,
+		})
+
+	unzipAidlIncludes = pctx.AndroidStaticRule("unzipAidlIncludes",
+		blueprint.RuleParams{
+			Command: "rm -rf $outDir && mkdir -p $outDir && unzip -qoDD -d $outDir $in && touch $out",
+		},
+		"outDir")
+
+	// Extracts the jar and deletes the classes that are present in one of $stripJars before zipping
+	// it up again.  The class names are passed to rm through xargs -0 so that neither the length
+	// of the list nor the characters in the names are subject to the shell.
+	stripJarClasses = pctx.AndroidStaticRule("stripJarClasses",
+		blueprint.RuleParams{
+			Command: "rm -rf $out.tmp $out && mkdir -p $out.tmp && unzip -qoDD -d $out.tmp $in && " +
+				"for jar in $stripJars; do unzip -Z1 $$jar | grep '\\.class$$'; done | tr '\\n' '\\000' | " +
+				"(cd $out.tmp && xargs -0 rm -f) && " +
+				"${config.SoongZipCmd} -jar -o $out -C $out.tmp -D $out.tmp && rm -rf $out.tmp",
+			CommandDeps: []string{"${config.SoongZipCmd}"},
+		},
+		"stripJars")
+
+	// Flattens a multi-release jar into a single-release jar for $version, overlaying the classes
+	// in META-INF/versions/N for every N up to $version onto the base classes, with the highest
+	// version winning.
+	flattenMultiReleaseJar = pctx.AndroidStaticRule("flattenMultiReleaseJar",
+		blueprint.RuleParams{
+			Command: "rm -rf $out $out.tmp && mkdir -p $out.tmp && " +
+				"for v in $$(seq $version -1 9); do " +
+				"${config.Zip2ZipCmd} -i $in -o $out.tmp/$$v.jar \"META-INF/versions/$$v/**/*:.\" || exit 1; " +
+				"done && " +
+				"${config.Zip2ZipCmd} -i $in -o $out.tmp/base.jar -x 'META-INF/versions/**/*' && " +
+				"${config.MergeZipsCmd} --ignore-duplicates -j $out " +
+				"$$(for v in $$(seq $version -1 9); do echo $out.tmp/$$v.jar; done) $out.tmp/base.jar && " +
+				"rm -rf $out.tmp",
+			CommandDeps: []string{"${config.Zip2ZipCmd}", "${config.MergeZipsCmd}"},
+		},
+		"version")
+
+	stripClassDebugInfo = pctx.AndroidStaticRule("stripClassDebugInfo",
+		blueprint.RuleParams{
+			Command:     "rm -f $out && ${config.StripClassDebugInfoCmd} -i $in -o $out",
+			CommandDeps: []string{"${config.StripClassDebugInfoCmd}"},
+		})
+
+	// Fails if the header of any of the classes*.dex files in the jar doesn't match its contents.
+	checkDexChecksum = pctx.AndroidStaticRule("checkDexChecksum",
+		blueprint.RuleParams{
+			Command:     "rm -f $out && ${config.CheckDexChecksumCmd} -i $in && cp -f $in $out",
+			CommandDeps: []string{"${config.CheckDexChecksumCmd}"},
+		},
+	)
+
+	// Fails if the dex version in the header of any of the classes*.dex files in the jar requires a
+	// newer runtime than $minApiLevel.
+	checkDexApiLevel = pctx.AndroidStaticRule("checkDexApiLevel",
+		blueprint.RuleParams{
+			Command: "rm -f $out && " +
+				"for dex in $$(unzip -Z1 $in 'classes*.dex'); do " +
+				"version=$$(unzip -p $in $$dex | head -c 7 | tail -c 3) && " +
+				"case $$version in " +
+				"035) api=1 ;; 037) api=24 ;; 038) api=26 ;; 039) api=28 ;; 040) api=29 ;; 041) api=35 ;; " +
+				"*) echo \"error: $in: $$dex has unknown dex version $$version\"; exit 1 ;; " +
+				"esac && " +
+				"if [ $$api -gt $minApiLevel ]; then " +
+				"echo \"error: $in: $$dex has dex version $$version, which requires API level $$api, but min_api_level is $minApiLevel\"; exit 1; " +
+				"fi; " +
+				"done && " +
+				"touch $out",
+		},
+		"minApiLevel")
+
 	jetifier = pctx.AndroidStaticRule("jetifier",
 		blueprint.RuleParams{
 			Command:     "${config.JavaCmd}  ${config.JavaVmFlags} -jar ${config.JetifierJar} -l error -o $out -i $in -t epoch",
@@ -260,14 +438,14 @@ var (
 
 	zipalign = pctx.AndroidStaticRule("zipalign",
 		blueprint.RuleParams{
-			Command: "if ! ${config.ZipAlign} -c -p 4 $in > /dev/null; then " +
-				"${config.ZipAlign} -f -p 4 $in $out; " +
+			Command: "if ! ${config.ZipAlign} -c -p $alignment $in > /dev/null; then " +
+				"${config.ZipAlign} -f -p $alignment $in $out; " +
 				"else " +
 				"cp -f $in $out; " +
 				"fi",
 			CommandDeps: []string{"${config.ZipAlign}"},
 		},
-	)
+		"alignment")
 
 	convertImplementationJarToHeaderJarRule = pctx.AndroidStaticRule("convertImplementationJarToHeaderJar",
 		blueprint.RuleParams{
@@ -339,6 +517,14 @@ type javaBuilderFlags struct {
 	aidlDeps      android.Paths
 	javaVersion   javaVersion
 
+	// useReleaseFlag is true if javac is passed the java version as --release instead of as
+	// -source and -target.
+	useReleaseFlag bool
+
+	// turbineProcessAnnotations is true if turbine runs the annotation processors when
+	// compiling the header jar.
+	turbineProcessAnnotations bool
+
 	errorProneExtraJavacFlags string
 	errorProneProcessorPath   classpath
 
@@ -355,6 +541,15 @@ func DefaultJavaBuilderFlags() javaBuilderFlags {
 	}
 }
 
+// javacJavaVersionFlags returns the javac flags that set the java version of the sources and the
+// classes.
+func (flags javaBuilderFlags) javacJavaVersionFlags() string {
+	if flags.useReleaseFlag {
+		return "--release " + flags.javaVersion.StringForJavacRelease()
+	}
+	return "-source " + flags.javaVersion.String() + " -target " + flags.javaVersion.String()
+}
+
 func TransformJavaToClasses(ctx android.ModuleContext, outputFile android.WritablePath, shardIdx int,
 	srcFiles, srcJars android.Paths, annoSrcJar android.WritablePath, flags javaBuilderFlags, deps android.Paths) {
 
@@ -471,6 +666,12 @@ func TransformJavaToHeaderClasses(ctx android.ModuleContext, outputFile android.
 
 	deps = append(deps, srcJars...)
 
+	if flags.turbineProcessAnnotations && len(flags.processors) > 0 {
+		deps = append(deps, flags.processorPath...)
+		turbineFlags += " " + flags.processorPath.FormTurbineClassPath("--processorpath ")
+		turbineFlags += " --processors " + strings.Join(flags.processors, " ")
+	}
+
 	rule := turbine
 	args := map[string]string{
 		"javacFlags":   flags.javacFlags,
@@ -612,17 +813,17 @@ func transformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 		Inputs:         srcFiles,
 		Implicits:      deps,
 		Args: map[string]string{
-			"javacFlags":    flags.javacFlags,
-			"bootClasspath": bootClasspath,
-			"classpath":     classpathArg,
-			"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
-			"processor":     processor,
-			"srcJars":       strings.Join(srcJars.Strings(), " "),
-			"srcJarDir":     android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
-			"outDir":        android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
-			"annoDir":       android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
-			"annoSrcJar":    annoSrcJar.String(),
-			"javaVersion":   flags.javaVersion.String(),
+			"javacFlags":       flags.javacFlags,
+			"bootClasspath":    bootClasspath,
+			"classpath":        classpathArg,
+			"processorpath":    flags.processorPath.FormJavaClassPath("-processorpath"),
+			"processor":        processor,
+			"srcJars":          strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":        android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
+			"outDir":           android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
+			"annoDir":          android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
+			"annoSrcJar":       annoSrcJar.String(),
+			"javaVersionFlags": flags.javacJavaVersionFlags(),
 		},
 	})
 }
@@ -649,6 +850,14 @@ func TransformJarsToJar(ctx android.ModuleContext, outputFile android.WritablePa
 	jars android.Paths, manifest android.OptionalPath, stripDirEntries bool, filesToStrip []string,
 	dirsToStrip []string) {
 
+	transformJarsToJar(ctx, outputFile, desc, jars, manifest, stripDirEntries, filesToStrip,
+		dirsToStrip, true)
+}
+
+func transformJarsToJar(ctx android.ModuleContext, outputFile android.WritablePath, desc string,
+	jars android.Paths, manifest android.OptionalPath, stripDirEntries bool, filesToStrip []string,
+	dirsToStrip []string, stripModuleInfo bool) {
+
 	var deps android.Paths
 
 	var jarArgs []string
@@ -667,7 +876,9 @@ func TransformJarsToJar(ctx android.ModuleContext, outputFile android.WritablePa
 
 	// Remove any module-info.class files that may have come from prebuilt jars, they cause problems
 	// for downstream tools like desugar.
-	jarArgs = append(jarArgs, "-stripFile module-info.class")
+	if stripModuleInfo {
+		jarArgs = append(jarArgs, "-stripFile module-info.class")
+	}
 
 	if stripDirEntries {
 		jarArgs = append(jarArgs, "-D")
@@ -736,6 +947,175 @@ func CheckJarPackages(ctx android.ModuleContext, outputFile android.WritablePath
 	})
 }
 
+// CheckNoSplitPackages writes the stamp file outputFile if no package is found in the jars of more
+// than one of modules, failing the build otherwise.  jars[i] is a jar of modules[i], and the
+// classes that are also in one of excludedJars[i] are not part of modules[i], as they come from
+// the static_libs of modules[i] that are checked on their own.
+func CheckNoSplitPackages(ctx android.ModuleContext, outputFile android.WritablePath,
+	modules []string, jars android.Paths, excludedJars []android.Paths) {
+	entries := make([]string, len(jars))
+	inputs := android.CopyOf(jars)
+	for i, jar := range jars {
+		entries[i] = modules[i] + ":" + jar.String() + ":" + strings.Join(excludedJars[i].Strings(), ",")
+		inputs = append(inputs, excludedJars[i]...)
+	}
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        splitPackageCheck,
+		Description: "check split packages",
+		Output:      outputFile,
+		Inputs:      android.FirstUniquePaths(inputs),
+		Args: map[string]string{
+			"jars": strings.Join(entries, " "),
+		},
+	})
+}
+
+// CheckHeaderJarClasses writes a stamp file to outputFile if every class in headerJar is also in
+// implJar, and fails otherwise.
+func CheckHeaderJarClasses(ctx android.ModuleContext, outputFile android.WritablePath,
+	headerJar, implJar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        headerJarClassesCheck,
+		Description: "check header jar classes",
+		Output:      outputFile,
+		Inputs:      android.Paths{headerJar, implJar},
+		Args: map[string]string{
+			"headerJar": headerJar.String(),
+			"implJar":   implJar.String(),
+		},
+	})
+}
+
+// TransformEmptyJarMarker writes a jar to outputFile that only contains an empty META-INF/.nonempty
+// entry.  soong_zip uses a fixed timestamp for the entry, so the jar is deterministic.
+func TransformEmptyJarMarker(ctx android.ModuleContext, outputFile android.WritablePath) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        emptyJarMarker,
+		Description: "empty jar marker",
+		Output:      outputFile,
+	})
+}
+
+// TransformJarsToFatJar merges jars into a single executable jar with the given manifest.  The
+// META-INF/services files that are present in more than one of the jars are concatenated instead
+// of only keeping the first one.
+func TransformJarsToFatJar(ctx android.ModuleContext, outputFile android.WritablePath,
+	jars android.Paths, manifest android.OptionalPath) {
+
+	servicesJar := android.PathForModuleOut(ctx, "fat", "services.jar")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        mergeServiceFiles,
+		Description: "merge service files",
+		Output:      servicesJar,
+		Inputs:      jars,
+	})
+
+	// The merged service files come first so that they are kept instead of the copies in the jars.
+	TransformJarsToJar(ctx, outputFile, "for fat jar", append(android.Paths{servicesJar}, jars...),
+		manifest, false, nil, nil)
+}
+
+// TransformJarToServiceUsage writes the service types loaded through ServiceLoader by the classes
+// in jar to outputFile.
+func TransformJarToServiceUsage(ctx android.ModuleContext, outputFile android.WritablePath, jar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        serviceUsage,
+		Description: "service usage",
+		Output:      outputFile,
+		Input:       jar,
+	})
+}
+
+// TransformJarToChecksum writes the sha256 checksum of jar to outputFile.
+func TransformJarToChecksum(ctx android.ModuleContext, outputFile android.WritablePath, jar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        jarChecksum,
+		Description: "jar checksum",
+		Output:      outputFile,
+		Input:       jar,
+	})
+}
+
+// TransformJarToNativeMethods writes the native methods declared by the classes in jar to
+// outputFile.
+func TransformJarToNativeMethods(ctx android.ModuleContext, outputFile android.WritablePath, jar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        nativeMethods,
+		Description: "native methods",
+		Output:      outputFile,
+		Input:       jar,
+	})
+}
+
+// TransformStripJarClasses copies inputFile to outputFile, dropping every class that is also
+// present in one of stripJars.
+func TransformStripJarClasses(ctx android.ModuleContext, outputFile android.WritablePath,
+	inputFile android.Path, stripJars android.Paths) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        stripJarClasses,
+		Description: "strip jar classes",
+		Output:      outputFile,
+		Input:       inputFile,
+		Implicits:   stripJars,
+		Args: map[string]string{
+			"stripJars": strings.Join(stripJars.Strings(), " "),
+		},
+	})
+}
+
+// TransformFlattenMultiReleaseJar converts the multi-release jar inputFile into a single-release
+// jar outputFile containing the classes that a runtime of the given Java version would load.
+func TransformFlattenMultiReleaseJar(ctx android.ModuleContext, outputFile android.WritablePath,
+	inputFile android.Path, version int) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        flattenMultiReleaseJar,
+		Description: "flatten multi-release jar",
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"version": strconv.Itoa(version),
+		},
+	})
+}
+
+// TransformStripClassDebugInfo copies the jar inputFile to outputFile, removing the line number
+// and local variable tables from every class it contains.
+func TransformStripClassDebugInfo(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        stripClassDebugInfo,
+		Description: "strip class debug info",
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// TransformCheckDexChecksum copies the dex jar inputFile to outputFile after checking that the
+// magic, file size, checksum and signature in the header of every dex file it contains match the
+// file, failing the build otherwise.  The bytecode of the classes is not verified.
+func TransformCheckDexChecksum(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkDexChecksum,
+		Description: "check dex checksum",
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// CheckDexApiLevel writes the stamp file outputFile if none of the dex files in inputFile
+// requires an API level newer than minApiLevel, failing the build otherwise.
+func CheckDexApiLevel(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path,
+	minApiLevel int) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkDexApiLevel,
+		Description: "check dex api level",
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"minApiLevel": strconv.Itoa(minApiLevel),
+		},
+	})
+}
+
 func TransformJetifier(ctx android.ModuleContext, outputFile android.WritablePath,
 	inputFile android.Path) {
 	ctx.Build(pctx, android.BuildParams{
@@ -751,12 +1131,22 @@ func GenerateMainClassManifest(ctx android.ModuleContext, outputFile android.Wri
 }
 
 func TransformZipAlign(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path, validations android.Paths) {
+	TransformZipAlignWithAlignment(ctx, outputFile, inputFile, validations, defaultDexAlignment)
+}
+
+// TransformZipAlignWithAlignment is TransformZipAlign with a configurable alignment in bytes for
+// uncompressed entries.
+func TransformZipAlignWithAlignment(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path,
+	validations android.Paths, alignment int) {
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        zipalign,
 		Description: "align",
 		Input:       inputFile,
 		Output:      outputFile,
 		Validations: validations,
+		Args: map[string]string{
+			"alignment": strconv.Itoa(alignment),
+		},
 	})
 }
 
@@ -0,0 +1,114 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"encoding/json"
+	"sort"
+
+	"android/soong/android"
+)
+
+// This singleton writes the compile classpath of every java_library variant to
+// $OUT/soong/java_classpath_manifests.json so that IDE and lint integrations do not have to
+// reconstruct it. It is built by the java_classpath_manifests phony target.
+
+func registerClasspathManifestsBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("java_classpath_manifests", classpathManifestsSingletonFactory)
+}
+
+func classpathManifestsSingletonFactory() android.Singleton {
+	return &classpathManifestsSingleton{}
+}
+
+type classpathManifestsSingleton struct{}
+
+const classpathManifestsFileName = "java_classpath_manifests.json"
+
+// classpathManifest is the entry for a single variant of a java_library in
+// java_classpath_manifests.json.  The json names of the fields must not be changed.
+type classpathManifest struct {
+	// Name of the module.
+	Name string `json:"name"`
+
+	// Directory of the Android.bp file that defines the module.
+	Path string `json:"path"`
+
+	// Variant of the module, for example android_common.
+	Variant string `json:"variant"`
+
+	// Header jars of the module.
+	HeaderJars []string `json:"header_jars"`
+
+	// Header jars of the transitive libs dependencies of the module.
+	TransitiveLibsHeaderJars []string `json:"transitive_libs_header_jars"`
+
+	// Header jars of the transitive static_libs dependencies of the module.
+	TransitiveStaticLibsHeaderJars []string `json:"transitive_static_libs_header_jars"`
+
+	// Aidl include directories exported by the module.
+	AidlIncludeDirs []string `json:"aidl_include_dirs"`
+}
+
+// stringsOrEmpty returns the strings of paths, or an empty list rather than nil so that the
+// field is written as [] instead of null.
+func stringsOrEmpty(paths android.Paths) []string {
+	if len(paths) == 0 {
+		return []string{}
+	}
+	return paths.Strings()
+}
+
+func (s *classpathManifestsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	manifests := []classpathManifest{}
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		if _, ok := module.(*Library); !ok {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, JavaInfoProvider)
+		if !ok {
+			return
+		}
+		manifests = append(manifests, classpathManifest{
+			Name:                           ctx.ModuleName(module),
+			Path:                           ctx.ModuleDir(module),
+			Variant:                        ctx.ModuleSubDir(module),
+			HeaderJars:                     stringsOrEmpty(info.HeaderJars),
+			TransitiveLibsHeaderJars:       stringsOrEmpty(info.TransitiveLibsHeaderJars.ToList()),
+			TransitiveStaticLibsHeaderJars: stringsOrEmpty(info.TransitiveStaticLibsHeaderJars.ToList()),
+			AidlIncludeDirs:                stringsOrEmpty(info.AidlIncludeDirs),
+		})
+	})
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		if manifests[i].Name != manifests[j].Name {
+			return manifests[i].Name < manifests[j].Name
+		}
+		return manifests[i].Variant < manifests[j].Variant
+	})
+
+	buf, err := json.MarshalIndent(manifests, "", "\t")
+	if err != nil {
+		ctx.Errorf("JSON marshal of java classpath manifests failed: %s", err)
+		return
+	}
+
+	manifestsPath := android.PathForOutput(ctx, classpathManifestsFileName)
+	android.WriteFileRule(ctx, manifestsPath, string(buf))
+	ctx.Phony("java_classpath_manifests", manifestsPath)
+}
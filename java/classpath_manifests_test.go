@@ -0,0 +1,107 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestClasspathManifests(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddFile("a/aidl/IFoo.aidl", nil),
+		android.FixtureAddTextFile("a/Android.bp", `
+			java_library {
+				name: "foo",
+				srcs: ["a.java"],
+				sdk_version: "none",
+				system_modules: "none",
+				libs: ["bar"],
+				static_libs: ["baz"],
+				aidl: {
+					export_include_dirs: ["aidl"],
+				},
+			}
+
+			java_library {
+				name: "bar",
+				srcs: ["b.java"],
+				sdk_version: "none",
+				system_modules: "none",
+				static_libs: ["qux"],
+			}
+
+			java_library {
+				name: "baz",
+				srcs: ["c.java"],
+				sdk_version: "none",
+				system_modules: "none",
+			}
+
+			java_library {
+				name: "qux",
+				srcs: ["d.java"],
+				sdk_version: "none",
+				system_modules: "none",
+			}
+		`),
+	).RunTest(t)
+
+	manifests := result.SingletonForTests("java_classpath_manifests").Output(classpathManifestsFileName)
+	content := android.StringRelativeToTop(result.Config,
+		android.ContentFromFileRuleForTests(t, result.TestContext, manifests))
+
+	// Only check the modules defined above, the default modules are listed as well.
+	for _, expected := range []string{
+		`{
+		"name": "bar",
+		"path": "a",
+		"variant": "android_common",
+		"header_jars": [
+			"out/soong/.intermediates/a/bar/android_common/turbine-combined/bar.jar"
+		],
+		"transitive_libs_header_jars": [],
+		"transitive_static_libs_header_jars": [
+			"out/soong/.intermediates/a/qux/android_common/turbine-combined/qux.jar"
+		],
+		"aidl_include_dirs": []
+	}`,
+		`{
+		"name": "foo",
+		"path": "a",
+		"variant": "android_common",
+		"header_jars": [
+			"out/soong/.intermediates/a/foo/android_common/turbine-combined/foo.jar"
+		],
+		"transitive_libs_header_jars": [
+			"out/soong/.intermediates/a/bar/android_common/turbine-combined/bar.jar"
+		],
+		"transitive_static_libs_header_jars": [
+			"out/soong/.intermediates/a/qux/android_common/turbine-combined/qux.jar",
+			"out/soong/.intermediates/a/baz/android_common/turbine-combined/baz.jar"
+		],
+		"aidl_include_dirs": [
+			"a/aidl"
+		]
+	}`,
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("expected java_classpath_manifests.json to contain:\n%s\ngot:\n%s", expected, content)
+		}
+	}
+}
@@ -139,6 +139,7 @@ func init() {
 		"${JavaToolchain}/javac", "ALTERNATE_JAVAC")
 	pctx.SourcePathVariable("JavaCmd", "${JavaToolchain}/java")
 	pctx.SourcePathVariable("JarCmd", "${JavaToolchain}/jar")
+	pctx.SourcePathVariable("JavapCmd", "${JavaToolchain}/javap")
 	pctx.SourcePathVariable("JavadocCmd", "${JavaToolchain}/javadoc")
 	pctx.SourcePathVariable("JlinkCmd", "${JavaToolchain}/jlink")
 	pctx.SourcePathVariable("JmodCmd", "${JavaToolchain}/jmod")
@@ -156,9 +157,11 @@ func init() {
 	pctx.HostBinToolVariable("SoongZipCmd", "soong_zip")
 	pctx.HostBinToolVariable("MergeZipsCmd", "merge_zips")
 	pctx.HostBinToolVariable("Zip2ZipCmd", "zip2zip")
+	pctx.HostBinToolVariable("StripClassDebugInfoCmd", "strip_class_debug_info")
 	pctx.HostBinToolVariable("ZipSyncCmd", "zipsync")
 	pctx.HostBinToolVariable("ApiCheckCmd", "apicheck")
 	pctx.HostBinToolVariable("D8Cmd", "d8")
+	pctx.HostBinToolVariable("CheckDexChecksumCmd", "check_dex_checksum")
 	pctx.HostBinToolVariable("R8Cmd", "r8")
 	pctx.HostBinToolVariable("ResourceShrinkerCmd", "resourceshrinker")
 	pctx.HostBinToolVariable("HiddenAPICmd", "hiddenapi")
@@ -258,6 +261,21 @@ func hostBinToolVariableWithBuildToolsPrebuilt(name, tool string) {
 	})
 }
 
+// DefaultHostBinaryWrapper is the wrapper script used by host java binaries that don't set the
+// wrapper property, unless the JavaHostBinaryWrapper product variable overrides it.
+const DefaultHostBinaryWrapper = "build/soong/scripts/jar-wrapper.sh"
+
+// HostBinaryWrapper returns the source path of the wrapper script used by host java binaries that
+// don't set the wrapper property, and whether it was overridden by the product configuration.
+// Like the default wrapper, a product wrapper must start java with a line beginning with
+// "exec java ", the runtime_jvm_flags of the dependencies of a binary are inserted after it.
+func HostBinaryWrapper(ctx android.PathContext) (string, bool) {
+	if wrapper := ctx.Config().JavaHostBinaryWrapper(); wrapper != "" {
+		return wrapper, true
+	}
+	return DefaultHostBinaryWrapper, false
+}
+
 // JavaCmd returns a SourcePath object with the path to the java command.
 func JavaCmd(ctx android.PathContext) android.SourcePath {
 	return javaTool(ctx, "java")
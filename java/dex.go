@@ -49,6 +49,11 @@ type DexProperties struct {
 		// Defaults to false for apps, true for libraries and tests.
 		Proguard_compatibility *bool
 
+		// If true, runs R8 in full mode regardless of the default of proguard_compatibility.  Requires
+		// sdk_version to be set, and cannot be combined with proguard_compatibility: true.  Defaults
+		// to false.
+		Full_mode *bool
+
 		// If true, optimize for size by removing unused code.  Defaults to true for apps,
 		// false for libraries and tests.
 		Shrink *bool
@@ -81,6 +86,28 @@ type DexProperties struct {
 		// If true, transitive reverse dependencies of this module will have this
 		// module's proguard spec appended to their optimization action
 		Export_proguard_flags_files *bool
+
+		// Proguard flags files inherited from dependencies that are not passed to the optimizer,
+		// given either as the name of the module providing the file or as the basename of the
+		// file.  Does not apply to the proguard_flags_files of this module.
+		Proguard_flags_files_exclude []string
+
+		// If true, write the keep rules that R8 reports as not matching anything to
+		// <module>-keep-coverage.txt, available through the ".keep_coverage" output tag.
+		// Only has an effect when optimization is enabled.  Defaults to false.
+		Emit_keep_coverage *bool
+
+		// If true, write the total size of the dex files before and after optimization and the
+		// percentage saved to <module>-optimize-size.txt, available through the ".optimize_size"
+		// output tag.  The size before optimization is measured by compiling the same classes with
+		// d8.  Only has an effect when optimization is enabled.  Defaults to false.
+		Emit_size_report *bool
+
+		// If true, write <module>-proguard-flags-provenance.txt, listing each proguard flags file
+		// used by this module together with the module that listed it in proguard_flags_files and
+		// the direct libs or static_libs dependency it was inherited through, available through
+		// the ".proguard_flags_provenance" output tag.  Defaults to false.
+		Proguard_flags_provenance *bool
 	}
 
 	// Keep the data uncompressed. We always need uncompressed dex for execution,
@@ -89,6 +116,14 @@ type DexProperties struct {
 	// It exists only to support ART tests.
 	Uncompress_dex *bool
 
+	// Alignment in bytes of the uncompressed dex files when uncompress_dex is set, must be a
+	// power of two.  Defaults to 4.  Use 16384 for devices with 16KB pages.
+	Dex_align *int
+
+	// If set, overrides whether dex2oat converts the dex files of this module to compact dex when
+	// dexpreopting it.  Only applies to modules that are compiled to dex.
+	Compact_dex *bool
+
 	// Exclude kotlinc generate files: *.kotlin_module, *.kotlin_builtins. Defaults to false.
 	Exclude_kotlinc_generated_files *bool
 }
@@ -101,12 +136,31 @@ type dexer struct {
 	proguardDictionary      android.OptionalPath
 	proguardConfiguration   android.OptionalPath
 	proguardUsageZip        android.OptionalPath
+	keepCoverage            android.OptionalPath
+	optimizeSizeReport      android.OptionalPath
+	proguardFlagsProvenance android.OptionalPath
 	resourcesInput          android.OptionalPath
 	resourcesOutput         android.OptionalPath
 
 	providesTransitiveHeaderJars
 }
 
+// The default alignment of uncompressed dex files in a jar.
+const defaultDexAlignment = 4
+
+// dexAlignment returns the alignment in bytes to use for uncompressed dex files, reporting a
+// property error if the requested alignment is not a power of two.
+func dexAlignment(ctx android.ModuleContext, dexAlign *int) int {
+	if dexAlign == nil {
+		return defaultDexAlignment
+	}
+	if align := *dexAlign; align <= 0 || align&(align-1) != 0 {
+		ctx.PropertyErrorf("dex_align", "must be a power of two, got %d", align)
+		return defaultDexAlignment
+	}
+	return *dexAlign
+}
+
 func (d *dexer) effectiveOptimizeEnabled() bool {
 	return BoolDefault(d.dexProperties.Optimize.Enabled, d.dexProperties.Optimize.EnabledByDefault)
 }
@@ -274,7 +328,10 @@ func (d *dexer) d8Flags(ctx android.ModuleContext, dexParams *compileDexParams)
 	return d8Flags, d8Deps, artProfileOutput
 }
 
-func (d *dexer) r8Flags(ctx android.ModuleContext, dexParams *compileDexParams) (r8Flags []string, r8Deps android.Paths, artProfileOutput *android.OutputPath) {
+// r8Flags returns the flags and dependencies of the r8 rule. keepCoverageFlags is the prefix of
+// r8Flags without the flags that make r8 write outputs other than the dex files, so that they can
+// be used for a separate r8 invocation.
+func (d *dexer) r8Flags(ctx android.ModuleContext, dexParams *compileDexParams) (r8Flags []string, r8Deps android.Paths, artProfileOutput *android.OutputPath, keepCoverageFlags []string) {
 	flags := dexParams.flags
 	opt := d.dexProperties.Optimize
 
@@ -338,7 +395,15 @@ func (d *dexer) r8Flags(ctx android.ModuleContext, dexParams *compileDexParams)
 
 	r8Flags = append(r8Flags, opt.Proguard_flags...)
 
-	if BoolDefault(opt.Proguard_compatibility, true) {
+	if Bool(opt.Full_mode) {
+		if Bool(opt.Proguard_compatibility) {
+			ctx.PropertyErrorf("optimize.full_mode", "cannot be set together with optimize.proguard_compatibility: true")
+		}
+		// R8 misbehaves in full mode when the sdk_version is not set.
+		if dexParams.sdkVersion.Raw == "" {
+			ctx.PropertyErrorf("optimize.full_mode", "requires sdk_version to be set")
+		}
+	} else if BoolDefault(opt.Proguard_compatibility, true) {
 		r8Flags = append(r8Flags, "--force-proguard-compatibility")
 	}
 
@@ -377,6 +442,8 @@ func (d *dexer) r8Flags(ctx android.ModuleContext, dexParams *compileDexParams)
 		r8Flags = append(r8Flags, "-ignorewarnings")
 	}
 
+	keepCoverageFlags = android.CopyOf(r8Flags)
+
 	// resourcesInput is empty when we don't use resource shrinking, if on, pass these to R8
 	if d.resourcesInput.Valid() {
 		r8Flags = append(r8Flags, "--resource-input", d.resourcesInput.Path().String())
@@ -393,7 +460,7 @@ func (d *dexer) r8Flags(ctx android.ModuleContext, dexParams *compileDexParams)
 		artProfileOutput = profileOutput
 	}
 
-	return r8Flags, r8Deps, artProfileOutput
+	return r8Flags, r8Deps, artProfileOutput, keepCoverageFlags
 }
 
 type compileDexParams struct {
@@ -423,6 +490,18 @@ func (d *dexer) addArtProfile(ctx android.ModuleContext, dexParams *compileDexPa
 
 }
 
+// Compares the total uncompressed size of the classes*.dex files in the unoptimized and optimized
+// dex jars.
+var optimizeSizeReport = pctx.AndroidStaticRule("optimizeSizeReport",
+	blueprint.RuleParams{
+		Command: `unoptimized=$$(unzip -l $unoptimized 'classes*.dex' | tail -n 1 | awk '{print $$1}') && ` +
+			`optimized=$$(unzip -l $in 'classes*.dex' | tail -n 1 | awk '{print $$1}') && ` +
+			`awk -v unoptimized=$$unoptimized -v optimized=$$optimized 'BEGIN { ` +
+			`printf "unoptimized_dex_size: %d\noptimized_dex_size: %d\nsaved: %.2f%%\n", ` +
+			`unoptimized, optimized, unoptimized ? (unoptimized - optimized) * 100 / unoptimized : 0 }' > $out`,
+	},
+	"unoptimized")
+
 // Return the compiled dex jar and (optional) profile _after_ r8 optimization
 func (d *dexer) compileDex(ctx android.ModuleContext, dexParams *compileDexParams) (android.OutputPath, *android.OutputPath) {
 
@@ -462,7 +541,7 @@ func (d *dexer) compileDex(ctx android.ModuleContext, dexParams *compileDexParam
 			proguardUsageZip,
 			proguardConfiguration,
 		}
-		r8Flags, r8Deps, r8ArtProfileOutputPath := d.r8Flags(ctx, dexParams)
+		r8Flags, r8Deps, r8ArtProfileOutputPath, keepCoverageFlags := d.r8Flags(ctx, dexParams)
 		if r8ArtProfileOutputPath != nil {
 			artProfileOutputPath = r8ArtProfileOutputPath
 			implicitOutputs = append(
@@ -499,6 +578,14 @@ func (d *dexer) compileDex(ctx android.ModuleContext, dexParams *compileDexParam
 			Implicits:       r8Deps,
 			Args:            args,
 		})
+		if proptools.Bool(d.dexProperties.Optimize.Emit_size_report) {
+			d.optimizeSizeReport = android.OptionalPathForPath(
+				d.buildOptimizeSizeReport(ctx, dexParams, commonFlags, javalibJar))
+		}
+		if proptools.Bool(d.dexProperties.Optimize.Emit_keep_coverage) {
+			d.keepCoverage = android.OptionalPathForPath(
+				d.buildKeepCoverage(ctx, dexParams, append(android.CopyOf(commonFlags), keepCoverageFlags...), r8Deps))
+		}
 	} else {
 		implicitOutputs := android.WritablePaths{}
 		d8Flags, d8Deps, d8ArtProfileOutputPath := d.d8Flags(ctx, dexParams)
@@ -531,9 +618,80 @@ func (d *dexer) compileDex(ctx android.ModuleContext, dexParams *compileDexParam
 	}
 	if proptools.Bool(d.dexProperties.Uncompress_dex) {
 		alignedJavalibJar := android.PathForModuleOut(ctx, "aligned", dexParams.jarName).OutputPath
-		TransformZipAlign(ctx, alignedJavalibJar, javalibJar, nil)
+		TransformZipAlignWithAlignment(ctx, alignedJavalibJar, javalibJar, nil,
+			dexAlignment(ctx, d.dexProperties.Dex_align))
 		javalibJar = alignedJavalibJar
 	}
 
 	return javalibJar, artProfileOutputPath
 }
+
+// buildOptimizeSizeReport compiles the classes jar again with d8 to measure the size of the dex
+// files without optimization, and writes a report comparing it to the size of optimizedJar.
+func (d *dexer) buildOptimizeSizeReport(ctx android.ModuleContext, dexParams *compileDexParams,
+	commonFlags []string, optimizedJar android.Path) android.Path {
+
+	flags := dexParams.flags
+	d8Flags := append(android.CopyOf(commonFlags), flags.bootClasspath.FormRepeatedClassPath("--lib ")...)
+	d8Flags = append(d8Flags, flags.dexClasspath.FormRepeatedClassPath("--lib ")...)
+
+	unoptimizedJar := android.PathForModuleOut(ctx, "unoptimized-dex", dexParams.jarName)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        d8,
+		Description: "d8 for optimize size report",
+		Output:      unoptimizedJar,
+		Input:       dexParams.classesJar,
+		Implicits:   append(android.CopyOf(flags.bootClasspath), flags.dexClasspath...),
+		Args: map[string]string{
+			"d8Flags":  strings.Join(d8Flags, " "),
+			"zipFlags": "--ignore_missing_files",
+			"outDir":   android.PathForModuleOut(ctx, "unoptimized-dex", "dex").String(),
+		},
+	})
+
+	report := android.PathForModuleOut(ctx, ctx.ModuleName()+"-optimize-size.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        optimizeSizeReport,
+		Description: "optimize size report",
+		Output:      report,
+		Input:       optimizedJar,
+		Implicit:    unoptimizedJar,
+		Args: map[string]string{
+			"unoptimized": unoptimizedJar.String(),
+		},
+	})
+	return report
+}
+
+// R8 reports every keep rule that doesn't match anything as an info diagnostic. Run it a second
+// time into a scratch directory to capture its output and extract those diagnostics, so that the
+// output of the r8 rule itself is not redirected.
+var r8KeepCoverage = pctx.AndroidStaticRule("r8KeepCoverage",
+	blueprint.RuleParams{
+		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+			`if ! ${config.R8Cmd} ${config.R8Flags} $r8Flags -injars $in --output $outDir --no-data-resources ` +
+			`> $out.log 2>&1; then cat $out.log; exit 1; fi && ` +
+			`(grep -F "rule does not match anything" $out.log || true) > $out && ` +
+			`rm -rf "$outDir" $out.log`,
+		CommandDeps: []string{"${config.R8Cmd}"},
+	},
+	"outDir", "r8Flags")
+
+// buildKeepCoverage returns a file listing the keep rules that don't match anything.
+func (d *dexer) buildKeepCoverage(ctx android.ModuleContext, dexParams *compileDexParams,
+	r8Flags []string, r8Deps android.Paths) android.Path {
+
+	keepCoverage := android.PathForModuleOut(ctx, ctx.ModuleName()+"-keep-coverage.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        r8KeepCoverage,
+		Description: "r8 keep coverage",
+		Output:      keepCoverage,
+		Input:       dexParams.classesJar,
+		Implicits:   r8Deps,
+		Args: map[string]string{
+			"r8Flags": strings.Join(r8Flags, " "),
+			"outDir":  android.PathForModuleOut(ctx, "keep-coverage-dex").String(),
+		},
+	})
+	return keepCoverage
+}
@@ -16,6 +16,8 @@ package java
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
 
 	"android/soong/android"
@@ -287,6 +289,143 @@ func TestR8Flags(t *testing.T) {
 		appR8.Args["r8Flags"], "--android-platform-build")
 }
 
+func TestR8FullMode(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["foo.java"],
+			sdk_version: "current",
+			installable: true,
+			optimize: {
+				enabled: true,
+				full_mode: true,
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["foo.java"],
+			sdk_version: "current",
+			installable: true,
+			optimize: {
+				enabled: true,
+			},
+		}
+	`)
+
+	fooR8 := result.ModuleForTests("foo", "android_common").Rule("r8")
+	android.AssertStringDoesNotContain(t, "expected no --force-proguard-compatibility in foo r8 flags",
+		fooR8.Args["r8Flags"], "--force-proguard-compatibility")
+	android.AssertStringListContains(t, "expected proguard raise dependencies in foo r8 inputs",
+		android.PathsRelativeToTop(fooR8.Implicits),
+		defaultModuleToPath("framework"))
+
+	barR8 := result.ModuleForTests("bar", "android_common").Rule("r8")
+	android.AssertStringDoesContain(t, "expected --force-proguard-compatibility in bar r8 flags",
+		barR8.Args["r8Flags"], "--force-proguard-compatibility")
+
+	PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`optimize.full_mode: requires sdk_version to be set`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["foo.java"],
+			installable: true,
+			optimize: {
+				enabled: true,
+				full_mode: true,
+			},
+		}
+	`)
+}
+
+func TestR8KeepCoverage(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeMockFs(android.MockFS{
+			"dead.flags": []byte("-keep class com.example.DoesNotExist { *; }"),
+		}),
+	).RunTestWithBp(t, `
+		android_app {
+			name: "app",
+			srcs: ["foo.java"],
+			platform_apis: true,
+			optimize: {
+				proguard_flags_files: ["dead.flags"],
+				emit_keep_coverage: true,
+			},
+		}
+
+		android_app {
+			name: "app_no_coverage",
+			srcs: ["foo.java"],
+			platform_apis: true,
+		}
+	`)
+
+	app := result.ModuleForTests("app", "android_common")
+	appR8 := app.Rule("r8")
+	keepCoverage := app.Rule("r8KeepCoverage")
+	android.AssertStringEquals(t, "keep coverage output", "app-keep-coverage.txt", keepCoverage.Output.Base())
+	android.AssertStringEquals(t, "keep coverage input", appR8.Input.String(), keepCoverage.Input.String())
+	android.AssertStringDoesContain(t, "expected keep coverage to use the proguard flags files",
+		keepCoverage.Args["r8Flags"], "-include dead.flags")
+	android.AssertStringDoesNotContain(t, "unexpected redirection in the r8 command",
+		appR8.RuleParams.Command, "rule does not match anything")
+
+	noCoverage := result.ModuleForTests("app_no_coverage", "android_common")
+	android.AssertBoolEquals(t, "unexpected keep coverage rule", false,
+		noCoverage.MaybeRule("r8KeepCoverage").Rule != nil)
+}
+
+func TestR8SizeReport(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "app",
+			srcs: ["foo.java"],
+			platform_apis: true,
+			optimize: {
+				emit_size_report: true,
+			},
+		}
+
+		android_app {
+			name: "app_no_optimize",
+			srcs: ["foo.java"],
+			platform_apis: true,
+			optimize: {
+				enabled: false,
+				emit_size_report: true,
+			},
+		}
+	`)
+
+	app := result.ModuleForTests("app", "android_common")
+	appR8 := app.Rule("r8")
+	unoptimized := app.Rule("d8")
+	android.AssertPathRelativeToTopEquals(t, "unoptimized dex output",
+		"out/soong/.intermediates/app/android_common/unoptimized-dex/app.jar", unoptimized.Output)
+	android.AssertStringEquals(t, "unoptimized dex input", appR8.Input.String(), unoptimized.Input.String())
+
+	report := app.Output("app-optimize-size.txt")
+	android.AssertStringEquals(t, "report optimized input", appR8.Output.String(), report.Input.String())
+	android.AssertStringEquals(t, "report unoptimized input", unoptimized.Output.String(), report.Implicit.String())
+	android.AssertStringEquals(t, "report unoptimized arg", unoptimized.Output.String(), report.Args["unoptimized"])
+	for _, field := range []string{"unoptimized_dex_size: %d", "optimized_dex_size: %d", "saved: %.2f%%",
+		"(unoptimized - optimized) * 100 / unoptimized"} {
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, field)
+	}
+
+	outputs, err := app.Module().(*AndroidApp).OutputFiles(".optimize_size")
+	android.AssertSame(t, "output files error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "output files",
+		[]string{"out/soong/.intermediates/app/android_common/app-optimize-size.txt"}, outputs)
+
+	noOptimize := result.ModuleForTests("app_no_optimize", "android_common")
+	android.AssertDeepEquals(t, "unexpected size report", nil,
+		noOptimize.MaybeOutput("app_no_optimize-optimize-size.txt").Rule)
+}
+
 func TestD8(t *testing.T) {
 	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
 		java_library {
@@ -328,6 +467,84 @@ func TestD8(t *testing.T) {
 		fooD8.Args["d8Flags"], staticLibHeader.String())
 }
 
+func TestD8Neverlink(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["foo.java"],
+			libs: ["lib", "neverlink_lib"],
+			installable: true,
+		}
+
+		java_library {
+			name: "lib",
+			srcs: ["foo.java"],
+		}
+
+		java_library {
+			name: "neverlink_lib",
+			srcs: ["foo.java"],
+			neverlink: true,
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	libHeader := result.ModuleForTests("lib", "android_common").Output("turbine-combined/lib.jar").Output
+	neverlinkLibHeader := result.ModuleForTests("neverlink_lib", "android_common").
+		Output("turbine-combined/neverlink_lib.jar").Output
+
+	fooJavac := foo.Rule("javac")
+	fooD8 := foo.Rule("d8")
+
+	android.AssertStringDoesContain(t, "expected neverlink_lib header jar in foo javac classpath",
+		fooJavac.Args["classpath"], neverlinkLibHeader.String())
+	android.AssertStringDoesContain(t, "expected lib header jar in foo d8 classpath",
+		fooD8.Args["d8Flags"], libHeader.String())
+	android.AssertStringDoesNotContain(t, "expected no neverlink_lib header jar in foo d8 classpath",
+		fooD8.Args["d8Flags"], neverlinkLibHeader.String())
+}
+
+func TestNeverlinkStaticLib(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["aidl/foo/IFoo.aidl"],
+			static_libs: ["lib", "neverlink_lib"],
+		}
+
+		java_library {
+			name: "lib",
+			srcs: ["foo.java"],
+		}
+
+		java_library {
+			name: "neverlink_lib",
+			srcs: ["foo.java"],
+			neverlink: true,
+			aidl: {
+				export_include_dirs: ["aidl/neverlink"],
+			},
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	lib := result.ModuleForTests("lib", "android_common")
+	libJavaInfo, _ := android.SingletonModuleProvider(result, lib.Module(), JavaInfoProvider)
+	neverlinkLib := result.ModuleForTests("neverlink_lib", "android_common")
+	neverlinkLibJavaInfo, _ := android.SingletonModuleProvider(result, neverlinkLib.Module(), JavaInfoProvider)
+	neverlinkLibHeader := neverlinkLib.Output("turbine-combined/neverlink_lib.jar").Output
+
+	fooCombined := foo.Output("combined/foo.jar").Inputs.Strings()
+	android.AssertStringListContains(t, "expected lib in foo combined jar",
+		fooCombined, libJavaInfo.ImplementationJars[0].String())
+	android.AssertStringListDoesNotContain(t, "expected no neverlink_lib in foo combined jar",
+		fooCombined, neverlinkLibJavaInfo.ImplementationJars[0].String())
+	android.AssertStringDoesContain(t, "expected neverlink_lib header jar in foo javac classpath",
+		foo.Rule("javac").Args["classpath"], neverlinkLibHeader.String())
+	android.AssertStringDoesContain(t, "expected neverlink_lib aidl include dirs",
+		foo.Rule("aidl").RuleParams.Command, "-Iaidl/neverlink")
+}
+
 func TestProguardFlagsInheritanceStatic(t *testing.T) {
 	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
 		android_app {
@@ -382,6 +599,116 @@ func TestProguardFlagsInheritanceStatic(t *testing.T) {
 		appR8.Args["r8Flags"], "tertiary.flags")
 }
 
+func TestProguardFlagsProvenance(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "app",
+			static_libs: ["static_lib"],
+			libs: ["exported_lib", "unexported_lib"],
+			platform_apis: true,
+			optimize: {
+				proguard_flags_files: ["app.flags"],
+				proguard_flags_provenance: true,
+			},
+		}
+
+		java_library {
+			name: "static_lib",
+			static_libs: ["transitive_lib"],
+			optimize: {
+				proguard_flags_files: ["static.flags"],
+			},
+		}
+
+		java_library {
+			name: "transitive_lib",
+			optimize: {
+				proguard_flags_files: ["transitive.flags"],
+			},
+		}
+
+		java_library {
+			name: "exported_lib",
+			optimize: {
+				proguard_flags_files: ["exported.flags"],
+				export_proguard_flags_files: true,
+			},
+		}
+
+		java_library {
+			name: "unexported_lib",
+			optimize: {
+				proguard_flags_files: ["unexported.flags"],
+			},
+		}
+	`)
+
+	app := result.ModuleForTests("app", "android_common")
+	provenance := android.ContentFromFileRuleForTests(t, result.TestContext,
+		app.Output("app-proguard-flags-provenance.txt"))
+	lines := strings.Split(provenance, "\n")
+	sort.Strings(lines)
+	android.AssertArrayString(t, "proguard flags provenance", []string{
+		"app.flags app self",
+		"exported.flags exported_lib libs exported_lib",
+		"static.flags static_lib static_libs static_lib",
+		"transitive.flags transitive_lib static_libs static_lib",
+	}, lines)
+
+	outputs, err := app.Module().(*AndroidApp).OutputFiles(".proguard_flags_provenance")
+	android.AssertSame(t, "output files error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "output files",
+		[]string{"out/soong/.intermediates/app/android_common/app-proguard-flags-provenance.txt"}, outputs)
+}
+
+func TestProguardFlagsFilesExclude(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "app",
+			static_libs: ["static_lib"],
+			libs: ["exported_lib"],
+			platform_apis: true,
+			optimize: {
+				proguard_flags_files: ["app.flags"],
+				proguard_flags_files_exclude: ["transitive_lib", "exported.flags"],
+			},
+		}
+
+		java_library {
+			name: "static_lib",
+			static_libs: ["transitive_lib"],
+			optimize: {
+				proguard_flags_files: ["static.flags"],
+			},
+		}
+
+		java_library {
+			name: "transitive_lib",
+			optimize: {
+				proguard_flags_files: ["transitive.flags"],
+			},
+		}
+
+		java_library {
+			name: "exported_lib",
+			optimize: {
+				proguard_flags_files: ["exported.flags"],
+				export_proguard_flags_files: true,
+			},
+		}
+	`)
+
+	appR8 := result.ModuleForTests("app", "android_common").Rule("r8")
+	android.AssertStringDoesContain(t, "expected app's own proguard flags",
+		appR8.Args["r8Flags"], "-include app.flags")
+	android.AssertStringDoesContain(t, "expected static_lib's proguard flags",
+		appR8.Args["r8Flags"], "-include static.flags")
+	android.AssertStringDoesNotContain(t, "expected transitive_lib's proguard flags to be excluded by module name",
+		appR8.Args["r8Flags"], "transitive.flags")
+	android.AssertStringDoesNotContain(t, "expected exported_lib's proguard flags to be excluded by basename",
+		appR8.Args["r8Flags"], "exported.flags")
+}
+
 func TestProguardFlagsInheritance(t *testing.T) {
 	directDepFlagsFileName := "direct_dep.flags"
 	transitiveDepFlagsFileName := "transitive_dep.flags"
@@ -690,6 +1017,42 @@ func TestR8FlagsArtProfile(t *testing.T) {
 	)
 }
 
+func TestCompactDex(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "app",
+			srcs: ["foo.java"],
+			platform_apis: true,
+			compact_dex: true,
+		}
+
+		android_app {
+			name: "app_default",
+			srcs: ["foo.java"],
+			platform_apis: true,
+		}
+	`)
+
+	appDexpreopt := result.ModuleForTests("app", "android_common").Rule("dexpreopt")
+	android.AssertStringDoesContain(t, "expected --compact-dex-level in app dexpreopt command",
+		appDexpreopt.RuleParams.Command, "--compact-dex-level=fast")
+
+	appDefaultDexpreopt := result.ModuleForTests("app_default", "android_common").Rule("dexpreopt")
+	android.AssertStringDoesNotContain(t, "unexpected --compact-dex-level in app_default dexpreopt command",
+		appDefaultDexpreopt.RuleParams.Command, "--compact-dex-level")
+
+	PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`compact_dex: only applies to modules that are compiled to dex`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["foo.java"],
+			installable: false,
+			compact_dex: true,
+		}
+	`)
+}
+
 // This test checks that users explicitly set `enable_profile_rewriting` to true when the following are true
 // 1. optimize or obfuscate is enabled AND
 // 2. dex_preopt.profile_guided is enabled
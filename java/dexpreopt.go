@@ -115,6 +115,7 @@ type dexpreopter struct {
 
 	installPath         android.InstallPath
 	uncompressedDex     bool
+	compactDex          *bool
 	isSDKLibrary        bool
 	isApp               bool
 	isTest              bool
@@ -464,6 +465,7 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, libName string, dexJa
 		DexPath:         dexJarFile,
 		ManifestPath:    android.OptionalPathForPath(d.manifestFile),
 		UncompressedDex: d.uncompressedDex,
+		CompactDex:      d.compactDex,
 		HasApkLibraries: false,
 		PreoptFlags:     nil,
 
@@ -294,6 +294,7 @@ func (j *Javadoc) collectAidlFlags(ctx android.ModuleContext, deps deps) droiddo
 	var flags droiddocBuilderFlags
 
 	flags.aidlFlags, flags.aidlDeps = j.aidlFlags(ctx, deps.aidlPreprocess, deps.aidlIncludeDirs)
+	flags.aidlDeps = append(flags.aidlDeps, deps.aidlIncludeDeps...)
 
 	return flags
 }
@@ -391,6 +392,7 @@ func (j *Javadoc) collectDeps(ctx android.ModuleContext) deps {
 			} else if dep, ok := android.OtherModuleProvider(ctx, module, JavaInfoProvider); ok {
 				deps.classpath = append(deps.classpath, dep.HeaderJars...)
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs...)
+				deps.aidlIncludeDeps = append(deps.aidlIncludeDeps, dep.AidlIncludeDeps...)
 				deps.aconfigProtoFiles = append(deps.aconfigProtoFiles, dep.AconfigIntermediateCacheOutputPaths...)
 			} else if dep, ok := module.(android.SourceFileProducer); ok {
 				checkProducesJars(ctx, dep)
@@ -0,0 +1,96 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton lists the java resources that are provided by the resource jars of more than one
+// module in $OUT/soong/duplicate_resources_report.txt, one line per resource with the names of the
+// modules that provide it.  The report is only generated when
+// SOONG_DUPLICATE_RESOURCES_REPORT=true is set in the environment, and is built by the
+// duplicate_resources_report phony target.
+
+func registerDuplicateResourcesReportBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("duplicate_resources_report", duplicateResourcesReportSingletonFactory)
+}
+
+func duplicateResourcesReportSingletonFactory() android.Singleton {
+	return &duplicateResourcesReportSingleton{}
+}
+
+type duplicateResourcesReportSingleton struct{}
+
+const duplicateResourcesReportFileName = "duplicate_resources_report.txt"
+
+// The rsp file lists a <module>:<resource jar> entry for each resource jar.  The entries of every
+// jar, other than directories and the manifest, are prefixed with the module name, and the paths
+// found for more than one module are printed along with those modules.
+var duplicateResourcesReport = pctx.AndroidStaticRule("duplicateResourcesReport",
+	blueprint.RuleParams{
+		Command: `for entry in $$(cat $out.rsp); do ` +
+			`unzip -Z1 $${entry#*:} | grep -v -e '/$$' -e '^META-INF/MANIFEST\.MF$$' | sed "s|^|$${entry%%:*} |"; ` +
+			`done | sort -u | ` +
+			`awk '{ n[$$2]++; mods[$$2] = mods[$$2] " " $$1 } END { for (p in n) if (n[p] > 1) print p ":" mods[p] }' | ` +
+			`sort > $out`,
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$entries",
+	},
+	"entries")
+
+func (s *duplicateResourcesReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("SOONG_DUPLICATE_RESOURCES_REPORT") {
+		return
+	}
+
+	var entries []string
+	var jars android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, JavaInfoProvider)
+		if !ok {
+			return
+		}
+		// The resource jars of static_libs dependencies are merged into ResourceJars, which would
+		// report every resource of a static library as provided by each of its users.
+		for _, jar := range info.LocalResourceJars {
+			entries = append(entries, ctx.ModuleName(module)+":"+jar.String())
+			jars = append(jars, jar)
+		}
+	})
+	// The rsp file content must not depend on the order the modules are visited in.
+	entries = android.FirstUniqueStrings(entries)
+	sort.Strings(entries)
+
+	reportPath := android.PathForOutput(ctx, duplicateResourcesReportFileName)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        duplicateResourcesReport,
+		Description: "duplicate resources report",
+		Output:      reportPath,
+		Inputs:      android.SortedUniquePaths(jars),
+		Args: map[string]string{
+			"entries": strings.Join(entries, " "),
+		},
+	})
+	ctx.Phony("duplicate_resources_report", reportPath)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+const duplicateResourcesReportBp = `
+	java_library {
+		name: "foo",
+		srcs: ["a.java"],
+		java_resources: ["res/shared.properties"],
+	}
+
+	java_library {
+		name: "bar",
+		srcs: ["a.java"],
+		java_resources: ["res/shared.properties"],
+	}
+
+	java_library {
+		name: "baz",
+		srcs: ["a.java"],
+		static_libs: ["foo"],
+	}
+`
+
+func TestDuplicateResourcesReport(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_DUPLICATE_RESOURCES_REPORT": "true",
+		}),
+	).RunTestWithBp(t, duplicateResourcesReportBp)
+
+	fooRes := result.ModuleForTests("foo", "android_common").Output("res/foo.jar").Output
+	barRes := result.ModuleForTests("bar", "android_common").Output("res/bar.jar").Output
+
+	// The resource jars of both modules are listed with their module names, so that a resource
+	// found in both jars is reported along with both modules.
+	report := result.SingletonForTests("duplicate_resources_report").Output(duplicateResourcesReportFileName)
+	entries := strings.Fields(report.Args["entries"])
+	android.AssertStringListContains(t, "report entries", entries, "foo:"+fooRes.String())
+	android.AssertStringListContains(t, "report entries", entries, "bar:"+barRes.String())
+	android.AssertStringListContains(t, "report inputs", report.Inputs.Strings(), fooRes.String())
+	android.AssertStringListContains(t, "report inputs", report.Inputs.Strings(), barRes.String())
+	android.AssertPathsRelativeToTopEquals(t, "phony deps",
+		[]string{"out/soong/" + duplicateResourcesReportFileName},
+		android.PhonyDepsForTests(result.Config, "duplicate_resources_report"))
+
+	// The resources of foo are merged into the resources of baz, which only lists its own.
+	for _, entry := range entries {
+		android.AssertBoolEquals(t, "baz listed: "+entry, false, strings.HasPrefix(entry, "baz:"))
+	}
+}
+
+func TestDuplicateResourcesReportDisabledByDefault(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, duplicateResourcesReportBp)
+
+	report := result.SingletonForTests("duplicate_resources_report").MaybeOutput(duplicateResourcesReportFileName)
+	android.AssertBoolEquals(t, "report generated", false, report.Rule != nil)
+}
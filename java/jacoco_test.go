@@ -14,7 +14,11 @@
 
 package java
 
-import "testing"
+import (
+	"testing"
+
+	"android/soong/android"
+)
 
 func TestJacocoFilterToSpecs(t *testing.T) {
 	testCases := []struct {
@@ -103,3 +107,32 @@ func TestJacocoFiltersToZipCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestJacocoFiltersInstrumentation(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJacocoInstrumentation,
+	).RunTestWithBp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			platform_apis: true,
+			jacoco: {
+				include_filter: ["com.android.foo.**"],
+				exclude_filter: ["com.android.foo.Excluded*"],
+			},
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	jacoco := foo.Rule("jacoco")
+
+	// Only the included classes that are not excluded are extracted into the report classes jar
+	// and instrumented.
+	android.AssertStringDoesContain(t, "exclude spec", jacoco.Args["stripSpec"],
+		"-x 'com/android/foo/Excluded*.class' ")
+	android.AssertStringDoesContain(t, "include spec", jacoco.Args["stripSpec"],
+		"'com/android/foo/**/*.class'")
+	android.AssertPathRelativeToTopEquals(t, "report classes jar",
+		"out/soong/.intermediates/foo/android_common/jacoco-report-classes/foo.jar", jacoco.ImplicitOutput)
+}
@@ -21,8 +21,10 @@ package java
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"android/soong/remoteexec"
@@ -76,6 +78,11 @@ func registerJavaBuildComponents(ctx android.RegistrationContext) {
 	})
 
 	ctx.RegisterParallelSingletonType("kythe_java_extract", kytheExtractJavaFactory)
+	registerUnusedExportedPluginsBuildComponents(ctx)
+	registerClasspathManifestsBuildComponents(ctx)
+	registerBuildCostReportBuildComponents(ctx)
+	registerDuplicateResourcesReportBuildComponents(ctx)
+	registerTestIndexBuildComponents(ctx)
 }
 
 func RegisterJavaSdkMemberTypes() {
@@ -231,8 +238,44 @@ var (
 			`exec app_process /$partition/bin $main_class "$$@"'> ${out}`,
 		Description: "Generating device binary wrapper ${jar_name}",
 	}, "jar_name", "partition", "main_class")
+
+	// Rule for generating the host wrapper of a java_binary launcher alias
+	hostBinaryAliasWrapper = pctx.StaticRule("hostBinaryAliasWrapper", blueprint.RuleParams{
+		Command: `echo -e '#!/bin/bash\n` +
+			`exec java $jvm_flags -cp "$$(dirname "$$0")/../framework/$jar_name" $main_class "$$@"'> ${out}`,
+		Description: "Generating host binary alias wrapper ${out}",
+	}, "jar_name", "main_class", "jvm_flags")
+
+	// Rule for generating the host wrapper of a java_binary with create_fat_jar, which runs the
+	// fat jar without looking for any other jar
+	hostBinaryFatJarWrapper = pctx.StaticRule("hostBinaryFatJarWrapper", blueprint.RuleParams{
+		Command: `echo -e '#!/bin/bash\n` +
+			`exec java $jvm_flags -jar "$$(dirname "$$0")/../framework/$jar_name" "$$@"'> ${out}`,
+		Description: "Generating host binary fat jar wrapper ${out}",
+	}, "jar_name", "jvm_flags")
+
+	// Rule for generating the Windows host wrapper of a java_binary launcher alias
+	windowsBinaryAliasWrapper = pctx.StaticRule("windowsBinaryAliasWrapper", blueprint.RuleParams{
+		Command:     `echo -e '@java $jvm_flags -cp "%~dp0..\\framework\\$jar_name" $main_class %*\r'> ${out}`,
+		Description: "Generating windows binary alias wrapper ${out}",
+	}, "jar_name", "main_class", "jvm_flags")
+
+	// Rule for adding the runtime JVM flags of the dependencies of a host java_binary to the
+	// default host wrapper.  $jvm_flags must be escaped with hostBinaryJvmFlagsEscaper.  The flags
+	// are inserted after the "exec java " at the start of a line of the wrapper, and the rule fails if
+	// there is none instead of silently dropping them.
+	hostBinaryJvmFlagsWrapper = pctx.StaticRule("hostBinaryJvmFlagsWrapper", blueprint.RuleParams{
+		Command: `if ! grep -q '^exec java ' $in; then ` +
+			`echo '$in: no line starting with "exec java " to add the runtime JVM flags to' >&2; exit 1; fi && ` +
+			`sed -e 's|^exec java |exec java $jvm_flags |' $in > $out && chmod a+x $out`,
+		Description: "Generating host binary wrapper ${out}",
+	}, "jvm_flags")
 )
 
+// hostBinaryJvmFlagsEscaper escapes the JVM flags for the replacement of the sed command of the
+// hostBinaryJvmFlagsWrapper rule, which is in single quotes in the shell.
+var hostBinaryJvmFlagsEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `&`, `\&`, `'`, `'\''`)
+
 type ProguardSpecInfo struct {
 	// If true, proguard flags files will be exported to reverse dependencies across libs edges
 	// If false, proguard flags files will only be exported to reverse dependencies across
@@ -246,6 +289,16 @@ type ProguardSpecInfo struct {
 
 	// implementation detail to store transitive proguard flags files from exporting shared deps
 	UnconditionallyExportedProguardFlags *android.DepSet[android.Path]
+
+	// ProguardFlagsFileOwners pairs the proguard flags files of this module and all its transitive
+	// deps with the module that provides them, used to write optimize.proguard_flags_provenance.
+	ProguardFlagsFileOwners *android.DepSet[ProguardFlagsFileOwner]
+}
+
+// ProguardFlagsFileOwner pairs a proguard flags file with the module that provides it.
+type ProguardFlagsFileOwner struct {
+	File   android.Path
+	Module string
 }
 
 var ProguardSpecInfoProvider = blueprint.NewProvider[ProguardSpecInfo]()
@@ -275,10 +328,21 @@ type JavaInfo struct {
 	// ResourceJars is a list of jars that contain the resources included in the module.
 	ResourceJars android.Paths
 
+	// LocalResourceJars is a list of jars that contain the resources of the module itself, without
+	// the resources of its static_libs dependencies that are merged into ResourceJars.
+	LocalResourceJars android.Paths
+
+	// The resource jars of this module and all its transitive static dependencies.
+	TransitiveResourceJars *android.DepSet[android.Path]
+
 	// AidlIncludeDirs is a list of directories that should be passed to the aidl tool when
 	// depending on this module.
 	AidlIncludeDirs android.Paths
 
+	// AidlIncludeDeps is a list of files that must be built before the AidlIncludeDirs can be used,
+	// e.g. the stamp file of a directory that aidl files are extracted into.
+	AidlIncludeDeps android.Paths
+
 	// SrcJarArgs is a list of arguments to pass to soong_zip to package the sources of this
 	// module.
 	SrcJarArgs []string
@@ -289,6 +353,20 @@ type JavaInfo struct {
 	// The source files of this module and all its transitive static dependencies.
 	TransitiveSrcFiles *android.DepSet[android.Path]
 
+	// The runtime JVM flags of this module and all its transitive static dependencies.
+	TransitiveJvmFlags *android.DepSet[string]
+
+	// The kotlinc flags exported by this module and all its transitive static dependencies.
+	TransitiveExportedKotlincFlags *android.DepSet[string]
+
+	// ClasspathPriority is the classpath_priority of this module, which orders it among the
+	// static_libs of java_binary and java_test modules.
+	ClasspathPriority int
+
+	// JpmsModuleName is the name of the JPMS module whose descriptor is kept in the jars of this
+	// module, or empty if the module is not a JPMS module.
+	JpmsModuleName string
+
 	// ExportedPlugins is a list of paths that should be used as annotation processors for any
 	// module that depends on this module.
 	ExportedPlugins android.Paths
@@ -301,6 +379,12 @@ type JavaInfo struct {
 	// requiring disbling turbine for any modules that depend on it.
 	ExportedPluginDisableTurbine bool
 
+	// ExportedPluginGeneratesApiOptions maps the processor class of each of this module's
+	// annotation processors that only generate APIs when passed an annotation processor option to
+	// that option.  Turbine is disabled for the modules that depend on this module and pass one of
+	// the options to javac.
+	ExportedPluginGeneratesApiOptions map[string]string
+
 	// JacocoReportClassesFile is the path to a jar containing uninstrumented classes that will be
 	// instrumented by jacoco.
 	JacocoReportClassesFile android.Path
@@ -313,10 +397,30 @@ type JavaInfo struct {
 	// AconfigIntermediateCacheOutputPaths is a path to the cache files collected from the
 	// java_aconfig_library modules that are statically linked to this module.
 	AconfigIntermediateCacheOutputPaths android.Paths
+
+	// TransitiveAconfigFiles is the aconfig cache files of this module and all its transitive libs
+	// and static_libs dependencies.  Use TransitiveAconfigFilesForModule to read it from other
+	// modules.
+	TransitiveAconfigFiles *android.DepSet[android.Path]
+
+	// Neverlink is true if the module was built with neverlink, in which case the modules that
+	// depend on it only compile against HeaderJars and never package or dex against its jars.
+	Neverlink bool
 }
 
 var JavaInfoProvider = blueprint.NewProvider[JavaInfo]()
 
+// TransitiveAconfigFilesForModule returns the aconfig cache files of the given java module and all
+// its transitive libs and static_libs dependencies, or nil if the module does not provide
+// JavaInfo.  The depset is in postorder, so the files of a dependency come before the files of the
+// modules that depend on it, and a module's own files come last.
+func TransitiveAconfigFilesForModule(ctx android.OtherModuleProviderContext, module blueprint.Module) *android.DepSet[android.Path] {
+	if info, ok := android.OtherModuleProvider(ctx, module, JavaInfoProvider); ok {
+		return info.TransitiveAconfigFiles
+	}
+	return nil
+}
+
 // SyspropPublicStubInfo contains info about the sysprop public stub library that corresponds to
 // the sysprop implementation library.
 type SyspropPublicStubInfo struct {
@@ -340,15 +444,39 @@ type UsesLibraryDependency interface {
 	ClassLoaderContexts() dexpreopt.ClassLoaderContextMap
 }
 
-// TODO(jungjw): Move this to kythe.go once it's created.
-type xref interface {
-	XrefJavaFiles() android.Paths
+// KytheInfo contains the kythe extraction files of a module.  It is set by the modules so that the
+// kythe_java_extract singleton only has to merge the files collected while the modules were
+// generated in parallel.
+type KytheInfo struct {
+	// The kythe extraction files of the module.
+	XrefJavaFiles android.Paths
 }
 
-func (j *Module) XrefJavaFiles() android.Paths {
-	return j.kytheFiles
+var KytheInfoProvider = blueprint.NewProvider[KytheInfo]()
+
+// BuildCostInfo contains a rough estimate of the work needed to build a java module.  It is used by
+// the java_build_cost_report singleton to rank the most expensive modules.
+type BuildCostInfo struct {
+	// Number of java and kotlin source files compiled by the module.
+	SrcCount int
+
+	// Number of direct dependencies of the module.
+	DepCount int
+
+	// Whether the module is compiled to a dex jar.
+	Dex bool
+
+	// Whether the dex jar of the module is optimized with R8.
+	Optimize bool
 }
 
+// Cost returns the estimated cost of building the module.
+func (i BuildCostInfo) Cost() int {
+	return i.SrcCount + i.DepCount
+}
+
+var BuildCostInfoProvider = blueprint.NewProvider[BuildCostInfo]()
+
 func (d dependencyTag) PropagateAconfigValidation() bool {
 	return d.static
 }
@@ -411,13 +539,16 @@ func IsJniDepTag(depTag blueprint.DependencyTag) bool {
 var (
 	dataNativeBinsTag       = dependencyTag{name: "dataNativeBins"}
 	dataDeviceBinsTag       = dependencyTag{name: "dataDeviceBins"}
+	dataTestAppsTag         = dependencyTag{name: "dataTestApps"}
 	staticLibTag            = dependencyTag{name: "staticlib", static: true}
 	libTag                  = dependencyTag{name: "javalib", runtimeLinked: true}
 	sdkLibTag               = dependencyTag{name: "sdklib", runtimeLinked: true}
 	java9LibTag             = dependencyTag{name: "java9lib", runtimeLinked: true}
 	pluginTag               = dependencyTag{name: "plugin", toolchain: true}
+	processorClasspathTag   = dependencyTag{name: "processor-classpath", toolchain: true}
 	errorpronePluginTag     = dependencyTag{name: "errorprone-plugin", toolchain: true}
 	exportedPluginTag       = dependencyTag{name: "exported-plugin", toolchain: true}
+	excludedStaticLibTag    = dependencyTag{name: "excluded-staticlib"}
 	bootClasspathTag        = dependencyTag{name: "bootclasspath", runtimeLinked: true}
 	systemModulesTag        = dependencyTag{name: "system modules", runtimeLinked: true}
 	frameworkResTag         = dependencyTag{name: "framework-res"}
@@ -436,6 +567,7 @@ var (
 	aconfigDeclarationTag   = dependencyTag{name: "aconfig-declaration"}
 	jniInstallTag           = dependencyTag{name: "jni install", runtimeLinked: true, installable: true}
 	binaryInstallTag        = dependencyTag{name: "binary install", runtimeLinked: true, installable: true}
+	hostRequiredInstallTag  = dependencyTag{name: "host required install", installable: true}
 	usesLibReqTag           = makeUsesLibraryDependencyTag(dexpreopt.AnySdkVersion, false)
 	usesLibOptTag           = makeUsesLibraryDependencyTag(dexpreopt.AnySdkVersion, true)
 	usesLibCompat28OptTag   = makeUsesLibraryDependencyTag(28, true)
@@ -541,6 +673,7 @@ type deps struct {
 	staticHeaderJars        android.Paths
 	staticResourceJars      android.Paths
 	aidlIncludeDirs         android.Paths
+	aidlIncludeDeps         android.Paths
 	srcs                    android.Paths
 	srcJars                 android.Paths
 	systemModules           *systemModules
@@ -551,6 +684,16 @@ type deps struct {
 	aconfigProtoFiles       android.Paths
 
 	disableTurbine bool
+
+	// annotation processor options that make one of the plugins in use generate API, which
+	// requires disabling turbine when they are passed to javac.
+	generatesApiOptions []string
+
+	// kotlinc flags exported by libs and static_libs dependencies.
+	exportedKotlincFlags []string
+
+	// classpath_priority of the static_libs dependencies that set it, by implementation jar.
+	staticJarsClasspathPriority map[android.Path]int
 }
 
 func checkProducesJars(ctx android.ModuleContext, dep android.SourceFileProducer) {
@@ -564,7 +707,7 @@ func checkProducesJars(ctx android.ModuleContext, dep android.SourceFileProducer
 
 func getJavaVersion(ctx android.ModuleContext, javaVersion string, sdkContext android.SdkContext) javaVersion {
 	if javaVersion != "" {
-		return normalizeJavaVersion(ctx, javaVersion)
+		return normalizeJavaVersion(ctx, "java_version", javaVersion)
 	} else if ctx.Device() {
 		return defaultJavaLanguageVersion(ctx, sdkContext.SdkVersion(ctx))
 	} else if ctx.Config().TargetsJava21() {
@@ -635,12 +778,26 @@ func (v javaVersion) StringForKotlinc() string {
 	}
 }
 
+func (v javaVersion) StringForJavacRelease() string {
+	// javac --release only accepts the feature release number, e.g. 8 instead of 1.8.
+	switch v {
+	case JAVA_VERSION_6, JAVA_VERSION_7, JAVA_VERSION_8:
+		return "8"
+	case JAVA_VERSION_9:
+		return "9"
+	default:
+		return v.String()
+	}
+}
+
 // Returns true if javac targeting this version uses system modules instead of a bootclasspath.
 func (v javaVersion) usesJavaModules() bool {
 	return v >= 9
 }
 
-func normalizeJavaVersion(ctx android.BaseModuleContext, javaVersion string) javaVersion {
+// normalizeJavaVersion returns the javaVersion for the java language level set in the given
+// property, reporting an error on the property if the level is not supported.
+func normalizeJavaVersion(ctx android.BaseModuleContext, property string, javaVersion string) javaVersion {
 	switch javaVersion {
 	case "1.6", "6":
 		// Java version 1.6 no longer supported, bumping to 1.8
@@ -656,13 +813,13 @@ func normalizeJavaVersion(ctx android.BaseModuleContext, javaVersion string) jav
 		return JAVA_VERSION_11
 	case "17":
 		return JAVA_VERSION_17
-	case "21":
+	case "1.21", "21":
 		return JAVA_VERSION_21
 	case "10", "12", "13", "14", "15", "16":
-		ctx.PropertyErrorf("java_version", "Java language level %s is not supported", javaVersion)
+		ctx.PropertyErrorf(property, "Java language level %s is not supported", javaVersion)
 		return JAVA_VERSION_UNSUPPORTED
 	default:
-		ctx.PropertyErrorf("java_version", "Unrecognized Java language level")
+		ctx.PropertyErrorf(property, "Unrecognized Java language level")
 		return JAVA_VERSION_UNSUPPORTED
 	}
 }
@@ -677,6 +834,22 @@ type Library struct {
 	combinedExportedProguardFlagsFile android.Path
 
 	InstallMixin func(ctx android.ModuleContext, installPath android.Path) (extraInstallDeps android.InstallPaths)
+
+	// If true, the installed jar has the line number and local variable tables stripped from its
+	// classes.
+	stripDebugInfo bool
+
+	// If true, the installed jar also contains the runtime jars of the transitive libs
+	// dependencies.
+	createFatJar bool
+
+	// jarjar rules relocating packages in the fat jar, only set if createFatJar is true and the
+	// module is a java_binary with shade.relocations.
+	shadeJarjarRules android.Path
+
+	// The stem of the installed jar, see installStem. Empty for modules that do not go through
+	// Library.GenerateAndroidBuildActions.
+	installedStem string
 }
 
 var _ android.ApexModule = (*Library)(nil)
@@ -931,10 +1104,11 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 
 	j.stem = proptools.StringDefault(j.overridableProperties.Stem, ctx.ModuleName())
+	j.installedStem = j.installStem(ctx)
 
 	proguardSpecInfo := j.collectProguardSpecInfo(ctx)
 	android.SetProvider(ctx, ProguardSpecInfoProvider, proguardSpecInfo)
-	exportedProguardFlagsFiles := proguardSpecInfo.ProguardFlagsFiles.ToList()
+	exportedProguardFlagsFiles := j.excludeProguardFlagsFiles(ctx, proguardSpecInfo.ProguardFlagsFiles.ToList())
 	j.extraProguardFlagsFiles = append(j.extraProguardFlagsFiles, exportedProguardFlagsFiles...)
 
 	combinedExportedProguardFlagFile := android.PathForModuleOut(ctx, "export_proguard_flags")
@@ -948,13 +1122,17 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	j.checkSdkVersions(ctx)
 	j.checkHeadersOnly(ctx)
+	j.checkStemByPartition(ctx)
+	if proptools.Bool(j.properties.Enforce_no_split_packages) {
+		j.splitPackagesCheckFile = j.checkNoSplitPackages(ctx)
+	}
 	if ctx.Device() {
 		libName := j.Name()
 		if j.SdkLibraryName() != nil && strings.HasSuffix(libName, ".impl") {
 			libName = proptools.String(j.SdkLibraryName())
 		}
 		j.dexpreopter.installPath = j.dexpreopter.getInstallPath(
-			ctx, libName, android.PathForModuleInstall(ctx, "framework", j.Stem()+".jar"))
+			ctx, libName, android.PathForModuleInstall(ctx, "framework", j.installStem(ctx)+".jar"))
 		j.dexpreopter.isSDKLibrary = j.deviceProperties.IsSDKLibrary
 		setUncompressDex(ctx, &j.dexpreopter, &j.dexer)
 		j.dexpreopter.uncompressedDex = *j.dexProperties.Uncompress_dex
@@ -979,10 +1157,37 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	})
 }
 
+// checkNoSplitPackages creates a rule that fails if a package is found in the header jars of more
+// than one static_libs dependency, and returns the stamp file written by the rule.
+func (j *Library) checkNoSplitPackages(ctx android.ModuleContext) android.Path {
+	var modules []string
+	var jars android.Paths
+	var excludedJars []android.Paths
+	ctx.VisitDirectDepsWithTag(staticLibTag, func(m android.Module) {
+		if dep, ok := android.OtherModuleProvider(ctx, m, JavaInfoProvider); ok {
+			// The header jars of a dependency also contain the classes of its own static_libs, which
+			// would be reported as split when two dependencies share a static_libs dependency.
+			var staticLibsJars android.Paths
+			if dep.TransitiveStaticLibsHeaderJars != nil {
+				staticLibsJars = dep.TransitiveStaticLibsHeaderJars.ToList()
+			}
+			for _, jar := range dep.HeaderJars {
+				modules = append(modules, ctx.OtherModuleName(m))
+				jars = append(jars, jar)
+				excludedJars = append(excludedJars, staticLibsJars)
+			}
+		}
+	})
+
+	stamp := android.PathForModuleOut(ctx, "split-package-check.stamp")
+	CheckNoSplitPackages(ctx, stamp, modules, jars, excludedJars)
+	return stamp
+}
+
 func (j *Library) setInstallRules(ctx android.ModuleContext, installModuleName string) {
 	apexInfo, _ := android.ModuleProvider(ctx, android.ApexInfoProvider)
 
-	if (Bool(j.properties.Installable) || ctx.Host()) && apexInfo.IsForPlatform() {
+	if (Bool(j.properties.Installable) || ctx.Host()) && !Bool(j.properties.Neverlink) && apexInfo.IsForPlatform() {
 		var extraInstallDeps android.InstallPaths
 		if j.InstallMixin != nil {
 			extraInstallDeps = j.InstallMixin(ctx, j.outputFile)
@@ -1003,10 +1208,81 @@ func (j *Library) setInstallRules(ctx android.ModuleContext, installModuleName s
 		} else {
 			installDir = android.PathForModuleInstall(ctx, "framework")
 		}
-		j.installFile = ctx.InstallFile(installDir, j.Stem()+".jar", j.outputFile, extraInstallDeps...)
+		installJar := j.outputFile
+		if j.createFatJar {
+			fatJar := android.PathForModuleOut(ctx, "fat", j.installStem(ctx)+".jar")
+			TransformJarsToFatJar(ctx, fatJar, append(android.Paths{j.outputFile}, j.runtimeLibsJars(ctx)...),
+				j.overrideManifest)
+			installJar = fatJar
+			if j.shadeJarjarRules != nil {
+				shadedJar := android.PathForModuleOut(ctx, "shade", j.installStem(ctx)+".jar")
+				TransformJarJar(ctx, shadedJar, installJar, j.shadeJarjarRules)
+				installJar = shadedJar
+			}
+		}
+		if j.stripDebugInfo {
+			strippedJar := android.PathForModuleOut(ctx, "stripped", j.installStem(ctx)+".jar")
+			TransformStripClassDebugInfo(ctx, strippedJar, installJar)
+			installJar = strippedJar
+		}
+		j.installFile = ctx.InstallFile(installDir, j.installStem(ctx)+".jar", installJar, extraInstallDeps...)
 	}
 }
 
+// runtimeLibsJars returns the implementation and resource jars of the transitive libs dependencies
+// of the library, which are not linked into its jar.  The static_libs of those dependencies are
+// already part of their jars, but the libs of static_libs dependencies are followed.
+func (j *Library) runtimeLibsJars(ctx android.ModuleContext) android.Paths {
+	var jars android.Paths
+	ctx.WalkDeps(func(child, parent android.Module) bool {
+		tag := ctx.OtherModuleDependencyTag(child)
+		if tag != libTag && tag != staticLibTag {
+			return false
+		}
+		dep, ok := android.OtherModuleProvider(ctx, child, JavaInfoProvider)
+		if !ok || dep.Neverlink {
+			return false
+		}
+		if tag == libTag {
+			jars = append(jars, dep.ImplementationAndResourcesJars...)
+		}
+		return true
+	})
+	return android.FirstUniquePaths(jars)
+}
+
+var stemByPartitionPartitions = []string{"system", "system_ext", "product", "vendor", "odm"}
+
+func (j *Library) checkStemByPartition(ctx android.ModuleContext) {
+	seen := make(map[string]bool)
+	for _, s := range j.properties.Stem_by_partition {
+		if !android.InList(s.Partition, stemByPartitionPartitions) {
+			ctx.PropertyErrorf("stem_by_partition", "unknown partition %q, must be one of %q",
+				s.Partition, stemByPartitionPartitions)
+		} else if seen[s.Partition] {
+			ctx.PropertyErrorf("stem_by_partition", "partition %q is listed more than once", s.Partition)
+		}
+		if s.Stem == "" {
+			ctx.PropertyErrorf("stem_by_partition", "stem for partition %q must not be empty", s.Partition)
+		}
+		seen[s.Partition] = true
+	}
+}
+
+// installStem returns the stem of the installed jar, which is the stem_by_partition entry for
+// the partition the module is installed on if there is one, and Stem() otherwise.
+func (j *Library) installStem(ctx android.ModuleContext) string {
+	if ctx.Device() {
+		partition := j.PartitionTag(ctx.DeviceConfig())
+		for _, s := range j.properties.Stem_by_partition {
+			if s.Partition == partition {
+				return s.Stem
+			}
+		}
+	}
+	return j.Stem()
+}
+
 func (j *Library) DepsMutator(ctx android.BottomUpMutatorContext) {
 	j.usesLibrary.deps(ctx, false)
 	j.deps(ctx)
@@ -1202,6 +1478,7 @@ func LibraryHostFactory() android.Module {
 	module := &Library{}
 
 	module.addHostProperties()
+	module.AddProperties(&module.hostOnlyProperties)
 
 	module.Module.properties.Installable = proptools.BoolPtr(true)
 
@@ -1228,6 +1505,117 @@ type TestOptions struct {
 	// Extra <option> tags to add to the auto generated test xml file under the test runner, e.g., AndroidJunitTest.
 	// The "key" is optional in each of these.
 	Test_runner_options []tradefed.Option
+
+	// The JUnit version to compile and run the test against, e.g. "4".  The matching JUnit library
+	// is added to static_libs, replacing any other JUnit library listed in libs or static_libs.
+	Junit_version *string
+
+	// The number of shards TradeFed should split the test into. Only applies to auto generated
+	// test configs, and is ignored for host unit tests.
+	Shards *int64
+
+	// The ABI the test must run on, e.g. "arm64-v8a".  TradeFed skips the test when running it
+	// against any other ABI.
+	Required_abi *string
+
+	// System properties that must have the given values on the device for the test to run.
+	// TradeFed skips the test when any of them has a different value.
+	Required_device_properties []TestDeviceProperty
+
+	// Extra <result_reporter> tags to add to the auto generated test xml file.
+	Result_reporters []TestResultReporter
+
+	// Names of the packages to uninstall from the device before the test runs, e.g. to remove
+	// stale state left by a previous run.  They are uninstalled before any data_test_apps are
+	// installed.
+	Uninstall_packages []string
+
+	// The number of times TradeFed retries the test cases according to retry_strategy.  Only
+	// applies to auto generated test configs.
+	Retry_count *int64
+
+	// The TradeFed retry strategy, one of "NO_RETRY", "RETRY_ANY_FAILURE",
+	// "RETRY_TEST_CASE_FAILURE", "RETRY_TEST_RUN_FAILURE", "RERUN_UNTIL_FAILURE" or "ITERATIONS".
+	// Defaults to "RETRY_ANY_FAILURE" when retry_count is set.  Only applies to auto generated
+	// test configs.
+	Retry_strategy *string
+}
+
+type TestResultReporter struct {
+	// Fully qualified name of the result reporter class, e.g.
+	// "com.android.tradefed.result.suite.SuiteResultReporter".
+	Class string
+
+	// Options of the result reporter.  The "key" is optional in each of these.
+	Options []tradefed.Option
+}
+
+var javaClassNameRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*(\.[a-zA-Z_$][a-zA-Z0-9_$]*)*$`)
+
+type TestDeviceProperty struct {
+	// Name of the system property, e.g. "ro.product.cpu.abi".
+	Name string
+
+	// Value the system property must have.
+	Value string
+}
+
+var devicePropertyNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// retryStrategies are the values accepted by test_options.retry_strategy, which are passed to the
+// retry-strategy option of TradeFed.
+var retryStrategies = []string{
+	"NO_RETRY",
+	"RETRY_ANY_FAILURE",
+	"RETRY_TEST_CASE_FAILURE",
+	"RETRY_TEST_RUN_FAILURE",
+	"RERUN_UNTIL_FAILURE",
+	"ITERATIONS",
+}
+
+var packageNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)+$`)
+
+// requiredAbiArchs maps the values accepted by test_options.required_abi to the architecture
+// name that the TradeFed ArchModuleController matches against.
+var requiredAbiArchs = map[string]string{
+	"armeabi-v7a": "arm",
+	"arm64-v8a":   "arm64",
+	"x86":         "x86",
+	"x86_64":      "x86_64",
+	"riscv64":     "riscv64",
+}
+
+// jniLibsArchs returns the set of architecture names of the jni_libs_abis property, or nil if it
+// is not set.
+func (j *Test) jniLibsArchs(ctx android.ModuleContext) map[string]bool {
+	if j.testProperties.Jni_libs_abis == nil {
+		return nil
+	}
+	archs := make(map[string]bool)
+	for _, abi := range j.testProperties.Jni_libs_abis {
+		if arch, ok := requiredAbiArchs[abi]; ok {
+			archs[arch] = true
+		} else {
+			ctx.PropertyErrorf("jni_libs_abis", "unknown ABI %q, must be one of %q", abi,
+				android.SortedKeys(requiredAbiArchs))
+		}
+	}
+	return archs
+}
+
+// junitVersionModules maps the values accepted by test_options.junit_version to the module that
+// provides that version of JUnit.
+var junitVersionModules = map[string]string{
+	"4": "junit",
+}
+
+type SuiteData struct {
+	// Name of the compatibility suite, for example "cts".
+	Suite string
+
+	// list of files or filegroup modules that provide data that should be installed alongside
+	// the test for the suite
+	Data []string `android:"path"`
 }
 
 type testProperties struct {
@@ -1243,10 +1631,20 @@ type testProperties struct {
 	// should be installed with the module.
 	Test_config_template *string `android:"path,arch_variant"`
 
+	// the name of a partial test configuration whose top level <option> and <target_preparer>
+	// elements are merged into the autogenerated test configuration.  Options replace the options
+	// of the autogenerated test configuration with the same name and key.
+	Test_config_merge *string `android:"path,arch_variant"`
+
 	// list of files or filegroup modules that provide data that should be installed alongside
 	// the test
 	Data []string `android:"path"`
 
+	// Data that is only installed alongside the test in the testcases directory of the given
+	// suite of test_suites, in addition to data, which is installed for every suite.  Entries for
+	// suites that are not in test_suites are ignored.
+	Suite_data []SuiteData
+
 	// Flag to indicate whether or not to create test config automatically. If AndroidTest.xml
 	// doesn't exist next to the Android.bp, this attribute doesn't need to be set to true
 	// explicitly.
@@ -1256,12 +1654,25 @@ type testProperties struct {
 	// handled by TradeFed to do downloading and installing the specified modules on the device.
 	Test_mainline_modules []string
 
+	// Names of android_app modules whose apks are installed alongside the test and installed on
+	// the device before the test runs by a TestAppInstallSetup target preparer in the auto
+	// generated test config.
+	Data_test_apps []string
+
 	// Test options.
 	Test_options TestOptions
 
 	// Names of modules containing JNI libraries that should be installed alongside the test.
 	Jni_libs []string
 
+	// ABIs of the jni_libs variants that are installed alongside the test, one of "armeabi-v7a",
+	// "arm64-v8a", "x86", "x86_64" or "riscv64".  Defaults to all the variants.
+	Jni_libs_abis []string
+
+	// If set to true, don't check that the min_sdk_version of the jni_libs is not higher than the
+	// min_sdk_version of the test.  Only meant for legacy modules.  Defaults to false.
+	Skip_jni_libs_min_sdk_version_check *bool
+
 	// Install the test into a folder named for the module in all test suites.
 	Per_testcase_directory *bool
 }
@@ -1290,6 +1701,14 @@ type hostTestProperties struct {
 	// list of device binary modules that should be installed alongside the test
 	// This property only adds 32bit variants of the dependency
 	Data_device_bins_32 []string `android:"arch_variant"`
+
+	// list of device binary modules that should be installed alongside the test
+	// This property only adds riscv64 variants of the dependency
+	Data_device_bins_riscv64 []string `android:"arch_variant"`
+
+	// Absolute path of the directory under /data on the device that the data_device_bins are
+	// pushed to.  Defaults to /data/local/tests/unrestricted/<module name>.
+	Data_device_dir *string
 }
 
 type testHelperLibraryProperties struct {
@@ -1319,6 +1738,10 @@ type Test struct {
 	testConfig       android.Path
 	extraTestConfigs android.Paths
 	data             android.Paths
+
+	// The suite_data of each suite in test_suites, which is only installed into the testcases
+	// directory of that suite.
+	suiteData map[string]android.Paths
 }
 
 type TestHost struct {
@@ -1435,9 +1858,60 @@ func (j *TestHost) addDataDeviceBinsDeps(ctx android.BottomUpMutatorContext) {
 		deviceVariations := maybeAndroid64Target.Variations()
 		ctx.AddFarVariationDependencies(deviceVariations, dataDeviceBinsTag, j.testHostProperties.Data_device_bins_64...)
 	}
+
+	if len(j.testHostProperties.Data_device_bins_riscv64) > 0 {
+		var riscv64Targets []android.Target
+		for _, target := range ctx.Config().Targets[android.Android] {
+			if target.Arch.ArchType == android.Riscv64 {
+				riscv64Targets = append(riscv64Targets, target)
+			}
+		}
+		riscv64TargetList := android.FirstTarget(riscv64Targets, "lib64")
+		if len(riscv64TargetList) == 0 {
+			ctx.PropertyErrorf("data_device_bins_riscv64", "cannot find riscv64 device target. Targets: %q", ctx.Config().Targets)
+			return
+		}
+		deviceVariations := riscv64TargetList[0].Variations()
+		ctx.AddFarVariationDependencies(deviceVariations, dataDeviceBinsTag, j.testHostProperties.Data_device_bins_riscv64...)
+	}
+}
+
+// pinJunitVersion replaces any JUnit library in the libs and static_libs properties with the one
+// selected by test_options.junit_version.
+func (j *Test) pinJunitVersion(ctx android.BottomUpMutatorContext) {
+	version := proptools.String(j.testProperties.Test_options.Junit_version)
+	if version == "" {
+		return
+	}
+	junitModule, ok := junitVersionModules[version]
+	if !ok {
+		ctx.PropertyErrorf("test_options.junit_version", "unknown JUnit version %q, must be one of %q",
+			version, android.SortedKeys(junitVersionModules))
+		return
+	}
+	junitModules := android.SortedUniqueStringValues(junitVersionModules)
+	j.properties.Libs = android.RemoveListFromList(j.properties.Libs, junitModules)
+	j.properties.Static_libs = append(android.RemoveListFromList(j.properties.Static_libs, junitModules), junitModule)
+}
+
+// addDataTestAppsDeps adds dependencies on the apps listed in data_test_apps, which are always
+// built for the device.
+func (j *Test) addDataTestAppsDeps(ctx android.BottomUpMutatorContext) {
+	if len(j.testProperties.Data_test_apps) > 0 {
+		ctx.AddFarVariationDependencies(ctx.Config().AndroidCommonTarget.Variations(),
+			dataTestAppsTag, j.testProperties.Data_test_apps...)
+	}
+}
+
+func (j *Test) DepsMutator(ctx android.BottomUpMutatorContext) {
+	j.pinJunitVersion(ctx)
+	j.addDataTestAppsDeps(ctx)
+	j.Library.DepsMutator(ctx)
 }
 
 func (j *TestHost) DepsMutator(ctx android.BottomUpMutatorContext) {
+	j.pinJunitVersion(ctx)
+
 	if len(j.testHostProperties.Data_native_bins) > 0 {
 		for _, target := range ctx.MultiTargets() {
 			ctx.AddVariationDependencies(target.Variations(), dataNativeBinsTag, j.testHostProperties.Data_native_bins...)
@@ -1452,6 +1926,7 @@ func (j *TestHost) DepsMutator(ctx android.BottomUpMutatorContext) {
 	}
 
 	j.addDataDeviceBinsDeps(ctx)
+	j.addDataTestAppsDeps(ctx)
 	j.deps(ctx)
 }
 
@@ -1465,7 +1940,8 @@ func (j *TestHost) dataDeviceBins() []string {
 			len(j.testHostProperties.Data_device_bins_both)+
 			len(j.testHostProperties.Data_device_bins_prefer32)+
 			len(j.testHostProperties.Data_device_bins_32)+
-			len(j.testHostProperties.Data_device_bins_64),
+			len(j.testHostProperties.Data_device_bins_64)+
+			len(j.testHostProperties.Data_device_bins_riscv64),
 	)
 
 	ret = append(ret, j.testHostProperties.Data_device_bins_first...)
@@ -1473,6 +1949,7 @@ func (j *TestHost) dataDeviceBins() []string {
 	ret = append(ret, j.testHostProperties.Data_device_bins_prefer32...)
 	ret = append(ret, j.testHostProperties.Data_device_bins_32...)
 	ret = append(ret, j.testHostProperties.Data_device_bins_64...)
+	ret = append(ret, j.testHostProperties.Data_device_bins_riscv64...)
 
 	return ret
 }
@@ -1483,6 +1960,13 @@ func (j *TestHost) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	if len(dataDeviceBins) > 0 {
 		// add Tradefed configuration to push device bins to device for testing
 		remoteDir := filepath.Join("/data/local/tests/unrestricted/", j.Name())
+		if dir := j.testHostProperties.Data_device_dir; dir != nil {
+			if cleaned := filepath.Clean(*dir); !strings.HasPrefix(cleaned, "/data/") {
+				ctx.PropertyErrorf("data_device_dir", "must be an absolute path under /data, got %q", *dir)
+			} else {
+				remoteDir = cleaned
+			}
+		}
 		options := []tradefed.Option{{Name: "cleanup", Value: "true"}}
 		for _, bin := range dataDeviceBins {
 			fullPath := filepath.Join(remoteDir, bin)
@@ -1497,35 +1981,195 @@ func (j *TestHost) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	j.Test.generateAndroidBuildActionsWithConfig(ctx, configs)
 	android.SetProvider(ctx, testing.TestModuleProviderKey, testing.TestModuleProviderData{})
+	j.setBaseTestProvider(ctx)
+}
+
+// dataTestApp is implemented by the app modules that can be listed in data_test_apps.
+type dataTestApp interface {
+	IsInstallable() bool
+	InstallApkName() string
+	OutputFile() android.Path
+}
+
+// checkJniLibMinSdkVersion reports an error if a JNI library that sets min_sdk_version requires a
+// newer API level than the min_sdk_version of the test, as it would fail to load on older devices.
+func (j *Test) checkJniLibMinSdkVersion(ctx android.ModuleContext, dep android.Module) {
+	ccDep, ok := dep.(*cc.Module)
+	if !ok || ccDep.MinSdkVersion() == "" {
+		return
+	}
+	minSdkVersion, err := j.MinSdkVersion(ctx).EffectiveVersion(ctx)
+	if err != nil {
+		return
+	}
+	jniSdkVersion, err := android.SdkSpecFrom(ctx, ccDep.MinSdkVersion()).EffectiveVersion(ctx)
+	if err != nil || minSdkVersion.LessThan(jniSdkVersion) {
+		ctx.ModuleErrorf("jni_libs: min_sdk_version(%v) of %q is higher than min_sdk_version(%v) of the test",
+			ccDep.MinSdkVersion(), ctx.OtherModuleName(dep), minSdkVersion)
+	}
+}
+
+// suiteDataBySuite returns the suite_data of the suites in test_suites, keyed by suite.
+func (j *Test) suiteDataBySuite(ctx android.ModuleContext) map[string]android.Paths {
+	data := make(map[string]android.Paths)
+	for _, suiteData := range j.testProperties.Suite_data {
+		if android.InList(suiteData.Suite, j.testProperties.Test_suites) {
+			data[suiteData.Suite] = append(data[suiteData.Suite],
+				android.PathsForModuleSrc(ctx, suiteData.Data)...)
+		}
+	}
+	return data
+}
+
+// retryOptions returns the TradeFed options for test_options.retry_count and
+// test_options.retry_strategy.
+func (j *Test) retryOptions(ctx android.ModuleContext) []tradefed.Option {
+	count := j.testProperties.Test_options.Retry_count
+	strategy := j.testProperties.Test_options.Retry_strategy
+	if count == nil && strategy == nil {
+		return nil
+	}
+	if strategy != nil && !android.InList(*strategy, retryStrategies) {
+		ctx.PropertyErrorf("test_options.retry_strategy", "unknown retry strategy %q, must be one of %q",
+			*strategy, retryStrategies)
+		return nil
+	}
+	var options []tradefed.Option
+	options = append(options, tradefed.Option{Name: "retry-strategy",
+		Value: proptools.StringDefault(strategy, "RETRY_ANY_FAILURE")})
+	if count != nil {
+		if *count <= 0 {
+			ctx.PropertyErrorf("test_options.retry_count", "must be a positive number, got %d", *count)
+			return nil
+		}
+		// The first run of the test cases counts towards max-testcase-run-count.
+		options = append(options, tradefed.Option{Name: "max-testcase-run-count",
+			Value: strconv.FormatInt(*count+1, 10)})
+	}
+	return options
+}
+
+func (j *Test) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	j.generateAndroidBuildActionsWithConfig(ctx, nil)
+	android.SetProvider(ctx, testing.TestModuleProviderKey, testing.TestModuleProviderData{})
+	j.setBaseTestProvider(ctx)
+}
+
+func (j *Test) setBaseTestProvider(ctx android.ModuleContext) {
 	android.SetProvider(ctx, tradefed.BaseTestProviderKey, tradefed.BaseTestProviderData{
 		InstalledFiles:      j.data,
 		OutputFile:          j.outputFile,
 		TestConfig:          j.testConfig,
 		RequiredModuleNames: j.RequiredModuleNames(ctx),
 		TestSuites:          j.testProperties.Test_suites,
-		IsHost:              true,
+		IsHost:              ctx.Host(),
 		LocalSdkVersion:     j.sdkVersion.String(),
 		IsUnitTest:          Bool(j.testProperties.Test_options.Unit_test),
 	})
 }
 
-func (j *Test) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	j.generateAndroidBuildActionsWithConfig(ctx, nil)
-	android.SetProvider(ctx, testing.TestModuleProviderKey, testing.TestModuleProviderData{})
-}
-
 func (j *Test) generateAndroidBuildActionsWithConfig(ctx android.ModuleContext, configs []tradefed.Config) {
 	if j.testProperties.Test_options.Unit_test == nil && ctx.Host() {
 		// TODO(b/): Clean temporary heuristic to avoid unexpected onboarding.
 		defaultUnitTest := !inList("tradefed", j.properties.Libs) && !inList("cts", j.testProperties.Test_suites)
 		j.testProperties.Test_options.Unit_test = proptools.BoolPtr(defaultUnitTest)
 	}
+	if abi := j.testProperties.Test_options.Required_abi; abi != nil {
+		if arch, ok := requiredAbiArchs[*abi]; ok {
+			configs = append(slices.Clone(configs), tradefed.Object{
+				Type:    "module_controller",
+				Class:   "com.android.tradefed.testtype.suite.module.ArchModuleController",
+				Options: []tradefed.Option{{Name: "arch", Value: arch}},
+			})
+		} else {
+			ctx.PropertyErrorf("test_options.required_abi", "unknown ABI %q, must be one of %q",
+				*abi, android.SortedKeys(requiredAbiArchs))
+		}
+	}
+	for _, prop := range j.testProperties.Test_options.Required_device_properties {
+		if !devicePropertyNameRegexp.MatchString(prop.Name) {
+			ctx.PropertyErrorf("test_options.required_device_properties", "invalid property name %q", prop.Name)
+			continue
+		}
+		configs = append(slices.Clone(configs), tradefed.Object{
+			Type:  "module_controller",
+			Class: "com.android.tradefed.testtype.suite.module.SystemPropertyModuleController",
+			Options: []tradefed.Option{
+				{Name: "property-name", Value: prop.Name},
+				{Name: "expected-value", Value: prop.Value},
+			},
+		})
+	}
+	for _, reporter := range j.testProperties.Test_options.Result_reporters {
+		if !javaClassNameRegexp.MatchString(reporter.Class) {
+			ctx.PropertyErrorf("test_options.result_reporters", "invalid class name %q", reporter.Class)
+			continue
+		}
+		configs = append(slices.Clone(configs), tradefed.Object{
+			Type:    "result_reporter",
+			Class:   reporter.Class,
+			Options: reporter.Options,
+		})
+	}
+	var uninstallOptions []tradefed.Option
+	for _, pkg := range j.testProperties.Test_options.Uninstall_packages {
+		if !packageNameRegexp.MatchString(pkg) {
+			ctx.PropertyErrorf("test_options.uninstall_packages", "invalid package name %q", pkg)
+			continue
+		}
+		uninstallOptions = append(uninstallOptions, tradefed.Option{Name: "run-command", Value: "pm uninstall " + pkg})
+	}
+	if len(uninstallOptions) > 0 {
+		configs = append(slices.Clone(configs), tradefed.Object{
+			Type:    "target_preparer",
+			Class:   "com.android.tradefed.targetprep.RunCommandTargetPreparer",
+			Options: uninstallOptions,
+		})
+	}
+	var dataTestApps android.Paths
+	var dataTestAppOptions []tradefed.Option
+	ctx.VisitDirectDepsWithTag(dataTestAppsTag, func(dep android.Module) {
+		app, ok := dep.(dataTestApp)
+		if !ok || !app.IsInstallable() {
+			ctx.PropertyErrorf("data_test_apps", "%q is not an installable android_app", ctx.OtherModuleName(dep))
+			return
+		}
+		dataTestApps = append(dataTestApps, app.OutputFile())
+		dataTestAppOptions = append(dataTestAppOptions,
+			tradefed.Option{Name: "test-file-name", Value: app.InstallApkName() + ".apk"})
+	})
+	if len(dataTestAppOptions) > 0 {
+		configs = append(slices.Clone(configs), tradefed.Object{
+			Type:    "target_preparer",
+			Class:   "com.android.tradefed.targetprep.TestAppInstallSetup",
+			Options: append(dataTestAppOptions, tradefed.Option{Name: "cleanup-apks", Value: "true"}),
+		})
+	}
+	optionsForAutogenerated := j.testProperties.Test_options.Tradefed_options
+	if shards := j.testProperties.Test_options.Shards; shards != nil {
+		if *shards <= 0 {
+			ctx.PropertyErrorf("test_options.shards", "must be a positive number, got %d", *shards)
+		} else if ctx.Host() && Bool(j.testProperties.Test_options.Unit_test) {
+			fmt.Printf("Warning: Module '%s' sets test_options.shards, which is ignored for unit tests\n", ctx.ModuleName())
+		} else if BoolDefault(j.testProperties.Auto_gen_config, true) {
+			optionsForAutogenerated = append(slices.Clone(optionsForAutogenerated),
+				tradefed.Option{Name: "shard-count", Value: strconv.FormatInt(*shards, 10)})
+		}
+	}
+	if retryOptions := j.retryOptions(ctx); len(retryOptions) > 0 && BoolDefault(j.testProperties.Auto_gen_config, true) {
+		optionsForAutogenerated = append(slices.Clone(optionsForAutogenerated), retryOptions...)
+	}
+	// The stem is normally set by Library.GenerateAndroidBuildActions, but the autogenerated test
+	// config must reference the jar by the same name it is installed with.
+	j.stem = proptools.StringDefault(j.overridableProperties.Stem, ctx.ModuleName())
 	j.testConfig = tradefed.AutoGenTestConfig(ctx, tradefed.AutoGenTestConfigOptions{
+		Name:                    j.installStem(ctx),
 		TestConfigProp:          j.testProperties.Test_config,
 		TestConfigTemplateProp:  j.testProperties.Test_config_template,
+		TestConfigMergeProp:     j.testProperties.Test_config_merge,
 		TestSuites:              j.testProperties.Test_suites,
 		Config:                  configs,
-		OptionsForAutogenerated: j.testProperties.Test_options.Tradefed_options,
+		OptionsForAutogenerated: optionsForAutogenerated,
 		TestRunnerOptions:       j.testProperties.Test_options.Test_runner_options,
 		AutoGenConfig:           j.testProperties.Auto_gen_config,
 		UnitTest:                j.testProperties.Test_options.Unit_test,
@@ -1535,6 +2179,8 @@ func (j *Test) generateAndroidBuildActionsWithConfig(ctx android.ModuleContext,
 	})
 
 	j.data = android.PathsForModuleSrc(ctx, j.testProperties.Data)
+	j.data = append(j.data, dataTestApps...)
+	j.suiteData = j.suiteDataBySuite(ctx)
 
 	j.extraTestConfigs = android.PathsForModuleSrc(ctx, j.testProperties.Test_options.Extra_test_configs)
 
@@ -1546,9 +2192,16 @@ func (j *Test) generateAndroidBuildActionsWithConfig(ctx android.ModuleContext,
 		j.data = append(j.data, android.OutputFileForModule(ctx, dep, ""))
 	})
 
+	jniLibsArchs := j.jniLibsArchs(ctx)
 	ctx.VisitDirectDepsWithTag(jniLibTag, func(dep android.Module) {
+		if !Bool(j.testProperties.Skip_jni_libs_min_sdk_version_check) {
+			j.checkJniLibMinSdkVersion(ctx, dep)
+		}
 		sharedLibInfo, _ := android.OtherModuleProvider(ctx, dep, cc.SharedLibraryInfoProvider)
 		if sharedLibInfo.SharedLibrary != nil {
+			if jniLibsArchs != nil && !jniLibsArchs[sharedLibInfo.Target.Arch.ArchType.String()] {
+				return
+			}
 			// Copy to an intermediate output directory to append "lib[64]" to the path,
 			// so that it's compatible with the default rpath values.
 			var relPath string
@@ -1569,6 +2222,7 @@ func (j *Test) generateAndroidBuildActionsWithConfig(ctx android.ModuleContext,
 		}
 	})
 
+	j.orderStaticJarsByClasspathPriority = true
 	j.Library.GenerateAndroidBuildActions(ctx)
 }
 
@@ -1749,12 +2403,57 @@ type binaryProperties struct {
 	// installable script to execute the resulting jar
 	Wrapper *string `android:"path,arch_variant"`
 
-	// Name of the class containing main to be inserted into the manifest as Main-Class.
-	Main_class *string
+	// Name of the class containing main to be inserted into the manifest as Main-Class.  The
+	// generated wrapper of each device architecture runs the value set for that architecture,
+	// which cannot differ from the common value when wrapper is set.
+	Main_class *string `android:"arch_variant"`
 
 	// Names of modules containing JNI libraries that should be installed alongside the host
 	// variant of the binary.
 	Jni_libs []string `android:"arch_variant"`
+
+	// Names of host tools that the host variant of the binary runs, which are installed whenever
+	// the binary is installed.
+	Host_required []string `android:"arch_variant"`
+
+	// Additional launcher scripts to install into bin/ alongside the default wrapper, each
+	// running a different main class from the same jar.
+	Aliases []BinaryAlias
+
+	// If true, strip the line number and local variable tables from the classes of the installed
+	// jar of a host binary.  Defaults to false.
+	Strip_debug_info *bool
+
+	// If true, the installed jar of a host binary also contains the classes and resources of all
+	// its transitive libs dependencies, so that it can be run without them.  The META-INF/services
+	// files of the jars are concatenated.  Unless wrapper is set, the generated wrapper only runs
+	// the fat jar.  Requires main_class.  Defaults to false.
+	Create_fat_jar *bool
+
+	Shade struct {
+		// Packages of the dependencies to move under a different package in the fat jar of the
+		// binary, so that they cannot conflict with other copies of the same dependencies when the
+		// jar is distributed.  Each relocation applies to the from package and its subpackages.
+		// The relocations are applied to the merged fat jar, so they also cover the libs
+		// dependencies.  Requires create_fat_jar: true.
+		Relocations []ShadeRelocation
+	}
+}
+
+type ShadeRelocation struct {
+	// Package to relocate, e.g. com.google.common.
+	From string
+
+	// Package to move the classes of from to, e.g. com.android.foo.shaded.com.google.common.
+	To string
+}
+
+type BinaryAlias struct {
+	// Name of the launcher script, must be different from the name of the module.
+	Name string
+
+	// Fully qualified name of the class containing main to run.
+	Main_class string
 }
 
 type Binary struct {
@@ -1786,12 +2485,42 @@ func (j *Binary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			j.overrideManifest = android.OptionalPathForPath(manifestFile)
 		}
 
+		if Bool(j.binaryProperties.Strip_debug_info) {
+			if ctx.Device() {
+				ctx.PropertyErrorf("strip_debug_info", "only supported for host binaries")
+			} else {
+				j.stripDebugInfo = true
+			}
+		}
+
+		if Bool(j.binaryProperties.Create_fat_jar) {
+			if ctx.Device() {
+				ctx.PropertyErrorf("create_fat_jar", "only supported for host binaries")
+			} else if j.binaryProperties.Main_class == nil {
+				ctx.PropertyErrorf("create_fat_jar", "requires main_class")
+			} else {
+				j.createFatJar = true
+			}
+		}
+
+		if len(j.binaryProperties.Shade.Relocations) > 0 {
+			if !Bool(j.binaryProperties.Create_fat_jar) {
+				ctx.PropertyErrorf("shade.relocations", "requires create_fat_jar: true")
+			} else if j.createFatJar {
+				j.shadeJarjarRules = j.buildShadeJarjarRules(ctx)
+			}
+		}
+
+		j.orderStaticJarsByClasspathPriority = true
 		j.Library.GenerateAndroidBuildActions(ctx)
 	} else {
 		// Handle the binary wrapper
 		j.isWrapperVariant = true
 
 		if j.binaryProperties.Wrapper != nil {
+			if ctx.Device() && String(j.binaryProperties.Main_class) != j.commonMainClass(ctx) {
+				ctx.PropertyErrorf("main_class", "cannot be set for %s when wrapper is set", ctx.Arch().ArchType)
+			}
 			j.wrapperFile = android.PathForModuleSrc(ctx, *j.binaryProperties.Wrapper)
 		} else {
 			if ctx.Windows() {
@@ -1820,8 +2549,45 @@ func (j *Binary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 					})
 					j.wrapperFile = wrapper
 				}
+			} else if Bool(j.binaryProperties.Create_fat_jar) {
+				// The fat jar contains all the runtime dependencies of the binary, so the wrapper
+				// only runs the installed fat jar.
+				wrapper := android.PathForModuleOut(ctx, "wrapper", ctx.ModuleName())
+				ctx.Build(pctx, android.BuildParams{
+					Rule:   hostBinaryFatJarWrapper,
+					Output: wrapper,
+					Args: map[string]string{
+						"jar_name":  j.Stem() + ".jar",
+						"jvm_flags": strings.Join(j.runtimeJvmFlags(ctx), " "),
+					},
+				})
+				j.wrapperFile = wrapper
 			} else {
-				j.wrapperFile = android.PathForSource(ctx, "build/soong/scripts/jar-wrapper.sh")
+				wrapper, overridden := config.HostBinaryWrapper(ctx)
+				if overridden {
+					// The default wrapper is part of the build system, but the product configured one
+					// may name any file in the source tree.
+					if path := android.ExistentPathForSource(ctx, wrapper); path.Valid() {
+						j.wrapperFile = path.Path()
+					} else {
+						ctx.ModuleErrorf("JavaHostBinaryWrapper product variable %q does not exist", wrapper)
+						j.wrapperFile = android.PathForSource(ctx, config.DefaultHostBinaryWrapper)
+					}
+				} else {
+					j.wrapperFile = android.PathForSource(ctx, wrapper)
+				}
+				if jvmFlags := j.runtimeJvmFlags(ctx); len(jvmFlags) > 0 {
+					wrapper := android.PathForModuleOut(ctx, "wrapper", ctx.ModuleName())
+					ctx.Build(pctx, android.BuildParams{
+						Rule:   hostBinaryJvmFlagsWrapper,
+						Input:  j.wrapperFile,
+						Output: wrapper,
+						Args: map[string]string{
+							"jvm_flags": hostBinaryJvmFlagsEscaper.Replace(strings.Join(jvmFlags, " ")),
+						},
+					})
+					j.wrapperFile = wrapper
+				}
 			}
 		}
 
@@ -1835,9 +2601,100 @@ func (j *Binary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		// libraries.  This is verified by TestBinary.
 		j.binaryFile = ctx.InstallExecutable(android.PathForModuleInstall(ctx, "bin"),
 			ctx.ModuleName()+ext, j.wrapperFile)
+
+		j.installAliasWrappers(ctx, ext)
 	}
 }
 
+// buildShadeJarjarRules writes a jarjar rules file implementing the shade.relocations property.
+func (j *Binary) buildShadeJarjarRules(ctx android.ModuleContext) android.Path {
+	seen := make(map[string]bool)
+	var rules strings.Builder
+	for _, relocation := range j.binaryProperties.Shade.Relocations {
+		if relocation.From == "" || relocation.To == "" {
+			ctx.PropertyErrorf("shade.relocations", "from and to must be set for each relocation")
+			continue
+		}
+		if seen[relocation.From] {
+			ctx.PropertyErrorf("shade.relocations", "duplicate relocation of %q", relocation.From)
+			continue
+		}
+		seen[relocation.From] = true
+		fmt.Fprintf(&rules, "rule %s.** %s.@1\n", relocation.From, relocation.To)
+	}
+
+	rulesFile := android.PathForModuleOut(ctx, "shade", "jarjar-rules.txt")
+	android.WriteFileRule(ctx, rulesFile, rules.String())
+	return rulesFile
+}
+
+// installAliasWrappers generates and installs a wrapper script for each of the aliases property,
+// running the alias's main class from the jar of the common variant.
+func (j *Binary) installAliasWrappers(ctx android.ModuleContext, ext string) {
+	seen := make(map[string]bool)
+	for _, alias := range j.binaryProperties.Aliases {
+		if alias.Name == "" || alias.Main_class == "" {
+			ctx.PropertyErrorf("aliases", "name and main_class must be set for each alias")
+			continue
+		}
+		if alias.Name == ctx.ModuleName() {
+			ctx.PropertyErrorf("aliases", "alias %q collides with the module name", alias.Name)
+			continue
+		}
+		if seen[alias.Name] {
+			ctx.PropertyErrorf("aliases", "duplicate alias %q", alias.Name)
+			continue
+		}
+		seen[alias.Name] = true
+
+		wrapper := android.PathForModuleOut(ctx, "aliases", alias.Name+ext)
+		args := map[string]string{
+			"jar_name":   j.Stem() + ".jar",
+			"main_class": alias.Main_class,
+		}
+		rule := hostBinaryAliasWrapper
+		if ctx.Device() {
+			rule = deviceBinaryWrapper
+			args["partition"] = j.PartitionTag(ctx.DeviceConfig())
+		} else {
+			args["jvm_flags"] = strings.Join(j.runtimeJvmFlags(ctx), " ")
+			if ctx.Windows() {
+				rule = windowsBinaryAliasWrapper
+			}
+		}
+		ctx.Build(pctx, android.BuildParams{
+			Rule:   rule,
+			Output: wrapper,
+			Args:   args,
+		})
+		ctx.InstallExecutable(android.PathForModuleInstall(ctx, "bin"), alias.Name+ext, wrapper)
+	}
+}
+
+// commonMainClass returns the main_class of the common variant, which is the one written to the
+// manifest of the jar.
+func (j *Binary) commonMainClass(ctx android.ModuleContext) string {
+	var mainClass string
+	ctx.VisitDirectDepsWithTag(binaryInstallTag, func(module android.Module) {
+		if common, ok := module.(*Binary); ok {
+			mainClass = String(common.binaryProperties.Main_class)
+		}
+	})
+	return mainClass
+}
+
+// runtimeJvmFlags returns the sorted runtime_jvm_flags of the jar of the common variant and all its
+// transitive static dependencies.
+func (j *Binary) runtimeJvmFlags(ctx android.ModuleContext) []string {
+	var flags []string
+	ctx.VisitDirectDepsWithTag(binaryInstallTag, func(module android.Module) {
+		if info, ok := android.OtherModuleProvider(ctx, module, JavaInfoProvider); ok && info.TransitiveJvmFlags != nil {
+			flags = append(flags, info.TransitiveJvmFlags.ToList()...)
+		}
+	})
+	return android.SortedUniqueStrings(flags)
+}
+
 func (j *Binary) DepsMutator(ctx android.BottomUpMutatorContext) {
 	if ctx.Arch().ArchType == android.Common {
 		j.deps(ctx)
@@ -1846,6 +2703,9 @@ func (j *Binary) DepsMutator(ctx android.BottomUpMutatorContext) {
 		// These dependencies ensure the host installation rules will install the jar file and
 		// the jni libraries when the wrapper is installed.
 		ctx.AddVariationDependencies(nil, jniInstallTag, j.binaryProperties.Jni_libs...)
+		if ctx.Host() {
+			ctx.AddVariationDependencies(nil, hostRequiredInstallTag, j.binaryProperties.Host_required...)
+		}
 		ctx.AddVariationDependencies(
 			[]blueprint.Variation{{Mutator: "arch", Variation: android.CommonArch.String()}},
 			binaryInstallTag, ctx.ModuleName())
@@ -1954,6 +2814,10 @@ type ApiLibrary struct {
 
 	stubsType StubsType
 
+	apiVersionsXml android.WritablePath
+
+	apiDiff android.WritablePath
+
 	aconfigProtoFiles android.Paths
 }
 
@@ -1976,15 +2840,28 @@ type JavaApiLibraryProperties struct {
 	// merge zipped after metalava invocation
 	Static_libs []string
 
-	// Java Api library to provide the full API surface stub jar file.
+	// Java Api libraries to provide the full API surface stub jar files.
 	// If this property is set, the stub jar of this module is created by
 	// extracting the compiled class files provided by the
-	// full_api_surface_stub module.
-	Full_api_surface_stub *string
+	// full_api_surface_stubs modules.  A surface can extend classes from
+	// multiple API domains, in which case the class files of all the listed
+	// modules are merged.
+	Full_api_surface_stubs []string
+
+	// If true, fail the build when more than one of the full_api_surface_stubs
+	// modules provides the same class file.  Otherwise the class file from the
+	// module listed last is used.  Defaults to false.
+	Error_on_full_api_surface_stubs_conflict *bool
 
 	// Version of previously released API file for compatibility check.
 	Previous_api *string `android:"path"`
 
+	// Previously released API files to migrate nullness annotations from, for staged nullability
+	// migrations across several API finalizations.  The API level of each file is taken from its
+	// path, e.g. prebuilts/sdk/33/public/api/foo.txt, and the files are passed to metalava in
+	// ascending API level order.  Cannot be set together with previous_api.
+	Previous_apis []string `android:"path"`
+
 	// java_system_modules module providing the jar to be added to the
 	// bootclasspath when compiling the stubs.
 	// The jar will also be passed to metalava as a classpath to
@@ -2010,6 +2887,35 @@ type JavaApiLibraryProperties struct {
 	// List of aconfig_declarations module names that the stubs generated in this module
 	// depend on.
 	Aconfig_declarations []string
+
+	// If true, generate <module>-api-versions.xml listing the API level each API of the surface
+	// was introduced in, available through the ".api_versions.xml" output tag.  The API levels
+	// are taken from previous_apis, all other APIs are listed as added in the current version.
+	// Defaults to false.
+	Emit_api_versions *bool
+
+	// If true, generate <module>-api-diff.txt listing the members of the API surface that were
+	// added or removed since previous_api, available through the ".api_diff" output tag and built
+	// by the java_api_diffs phony target.  Requires previous_api to be set.  Defaults to false.
+	Api_diff_output *bool
+
+	// The java language level the stubs are compiled at, for surfaces whose stubs need newer
+	// language constructs such as default methods.  Defaults to 1.8.
+	Stubs_java_version *string
+
+	// If true, the classes of the static_libs are also put on the classpath of metalava and of the
+	// compilation of the stubs, for API surfaces whose API files reference classes that are
+	// defined in the static_libs.  The static_libs are merged into the stubs jar either way.
+	// Defaults to false.
+	Merge_static_libs_before_metalava *bool
+}
+
+// stubsJavaVersion returns the java language level the stubs of the module are compiled at.
+func (al *ApiLibrary) stubsJavaVersion(ctx android.BaseModuleContext) javaVersion {
+	if v := al.properties.Stubs_java_version; v != nil {
+		return normalizeJavaVersion(ctx, "stubs_java_version", *v)
+	}
+	return getStubsJavaVersion()
 }
 
 func ApiLibraryFactory() android.Module {
@@ -2032,11 +2938,10 @@ func (al *ApiLibrary) StubsJar() android.Path {
 func metalavaStubCmd(ctx android.ModuleContext, rule *android.RuleBuilder,
 	srcs android.Paths, homeDir android.WritablePath,
 	classpath android.Paths) *android.RuleBuilderCommand {
-	rule.Command().Text("rm -rf").Flag(homeDir.String())
-	rule.Command().Text("mkdir -p").Flag(homeDir.String())
-
+	// homeDir is the sbox output directory, which sbox creates empty for every run of the rule both
+	// locally and on the remote builder, so it doesn't need to be cleaned up or created first.
 	cmd := rule.Command()
-	cmd.FlagWithArg("ANDROID_PREFS_ROOT=", homeDir.String())
+	cmd.FlagWithArg("ANDROID_PREFS_ROOT=", cmd.PathForOutput(homeDir))
 
 	if metalavaUseRbe(ctx) {
 		rule.Remoteable(android.RemoteRuleSupports{RBE: true})
@@ -2105,12 +3010,12 @@ func (al *ApiLibrary) addValidation(ctx android.ModuleContext, cmd *android.Rule
 	}
 }
 
-// This method extracts the stub class files from the stub jar file provided
-// from full_api_surface_stub module instead of compiling the srcjar generated from invoking metalava.
+// This method extracts the stub class files from the stub jar files provided
+// from full_api_surface_stubs modules instead of compiling the srcjar generated from invoking metalava.
 // This method is used because metalava can generate compilable from-text stubs only when
 // the codebase encompasses all classes listed in the input API text file, and a class can extend
 // a class that is not within the same API domain.
-func (al *ApiLibrary) extractApiSrcs(ctx android.ModuleContext, rule *android.RuleBuilder, stubsDir android.OptionalPath, fullApiSurfaceStubJar android.Path) {
+func (al *ApiLibrary) extractApiSrcs(ctx android.ModuleContext, rule *android.RuleBuilder, stubsDir android.OptionalPath, fullApiSurfaceStubJars android.Paths) {
 	classFilesList := android.PathForModuleOut(ctx, "metalava", "classes.txt")
 	unzippedSrcJarDir := android.PathForModuleOut(ctx, "metalava", "unzipDir")
 
@@ -2122,11 +3027,23 @@ func (al *ApiLibrary) extractApiSrcs(ctx android.ModuleContext, rule *android.Ru
 		FlagWithArg("--root ", unzippedSrcJarDir.String()).
 		Flag("--classes")
 
-	rule.Command().
-		Text("unzip").
-		Flag("-q").
-		Input(fullApiSurfaceStubJar).
-		FlagWithArg("-d ", unzippedSrcJarDir.String())
+	if Bool(al.properties.Error_on_full_api_surface_stubs_conflict) && len(fullApiSurfaceStubJars) > 1 {
+		rule.Command().
+			Text("duplicates=$(for jar in").
+			Inputs(fullApiSurfaceStubJars).
+			Text("; do unzip -Z1 $jar; done | grep '\\.class$' | sort | uniq -d) &&").
+			Text(`if [ -n "$duplicates" ]; then echo "classes provided by more than one of full_api_surface_stubs:" $duplicates >&2; exit 1; fi`)
+	}
+
+	// Later jars overwrite the class files extracted from earlier ones.
+	for _, fullApiSurfaceStubJar := range fullApiSurfaceStubJars {
+		rule.Command().
+			Text("unzip").
+			Flag("-q").
+			Flag("-o").
+			Input(fullApiSurfaceStubJar).
+			FlagWithArg("-d ", unzippedSrcJarDir.String())
+	}
 
 	rule.Command().
 		BuiltTool("soong_zip").
@@ -2167,9 +3084,7 @@ func (al *ApiLibrary) DepsMutator(ctx android.BottomUpMutatorContext) {
 	}
 	ctx.AddVariationDependencies(nil, libTag, al.properties.Libs...)
 	ctx.AddVariationDependencies(nil, staticLibTag, al.properties.Static_libs...)
-	if al.properties.Full_api_surface_stub != nil {
-		ctx.AddVariationDependencies(nil, depApiSrcsTag, String(al.properties.Full_api_surface_stub))
-	}
+	ctx.AddVariationDependencies(nil, depApiSrcsTag, al.properties.Full_api_surface_stubs...)
 	if al.properties.System_modules != nil {
 		ctx.AddVariationDependencies(nil, systemModulesTag, String(al.properties.System_modules))
 	}
@@ -2211,12 +3126,112 @@ func (al *ApiLibrary) validateProperties(ctx android.ModuleContext) {
 	}
 }
 
+// previousApiLevel returns the API level of a previously released API file, which is the last
+// numeric directory in its path, e.g. 33 for prebuilts/sdk/33/public/api/foo.txt.
+func previousApiLevel(path android.Path) (int, bool) {
+	dirs := strings.Split(filepath.Dir(path.String()), string(filepath.Separator))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if level, err := strconv.Atoi(dirs[i]); err == nil {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// sortedPreviousApis returns the previous_apis files sorted by ascending API level.
+func (al *ApiLibrary) sortedPreviousApis(ctx android.ModuleContext) android.Paths {
+	previousApis := android.PathsForModuleSrc(ctx, al.properties.Previous_apis)
+	for _, previousApi := range previousApis {
+		if _, ok := previousApiLevel(previousApi); !ok {
+			ctx.PropertyErrorf("previous_apis", "cannot determine the API level of %q", previousApi)
+		}
+	}
+	sort.SliceStable(previousApis, func(i, j int) bool {
+		levelI, _ := previousApiLevel(previousApis[i])
+		levelJ, _ := previousApiLevel(previousApis[j])
+		return levelI < levelJ
+	})
+	return previousApis
+}
+
+// apiVersionsFlags adds the metalava flags to generate the api-versions.xml file from the
+// previous API files, which must be sorted by ascending API level, and the current API.
+func (al *ApiLibrary) apiVersionsFlags(ctx android.ModuleContext, cmd *android.RuleBuilderCommand, previousApis android.Paths) {
+	var names []string
+	for _, previousApi := range previousApis {
+		level, _ := previousApiLevel(previousApi)
+		names = append(names, strconv.Itoa(level))
+	}
+	names = append(names, ctx.Config().PlatformSdkVersion().String())
+
+	cmd.FlagWithOutput("--generate-api-version-history ", al.apiVersionsXml)
+	if len(previousApis) > 0 {
+		cmd.FlagWithInputList("--api-version-signature-files ", previousApis, ":")
+	}
+	cmd.FlagWithArg("--api-version-names ", proptools.ShellEscape(strings.Join(names, " ")))
+}
+
+// buildApiDiff generates the <module>-api-diff.txt file listing the members that were removed
+// from (prefixed with "-") or added to (prefixed with "+") the API surface since previousApi.
+// Both signature files are first written out by metalava so that the diff does not depend on the
+// formatting or ordering of the input files.
+func (al *ApiLibrary) buildApiDiff(ctx android.ModuleContext, srcFiles android.Paths,
+	previousApi android.Path, classpath android.Paths) {
+	rule := android.NewRuleBuilder(pctx, ctx)
+
+	outDir := android.PathForModuleOut(ctx, "api_diff")
+	rule.Sbox(outDir, android.PathForModuleOut(ctx, "api_diff.sbox.textproto")).
+		SandboxInputs()
+
+	previousSignatures := outDir.Join(ctx, "previous.txt")
+	metalavaStubCmd(ctx, rule, android.Paths{previousApi}, outDir, classpath).
+		FlagWithOutput("--api ", previousSignatures)
+
+	currentSignatures := outDir.Join(ctx, "current.txt")
+	metalavaStubCmd(ctx, rule, srcFiles, outDir, classpath).
+		FlagWithOutput("--api ", currentSignatures)
+
+	al.apiDiff = outDir.Join(ctx, ctx.ModuleName()+"-api-diff.txt")
+	rule.Command().
+		Text("(diff").
+		Flag("--unchanged-line-format=").
+		Flag("--old-line-format='- %L'").
+		Flag("--new-line-format='+ %L'").
+		Input(previousSignatures).
+		Input(currentSignatures).
+		FlagWithOutput("> ", al.apiDiff).
+		// diff exits with 1 when the files differ, which is expected here.
+		Text("|| test $? -eq 1)")
+
+	rule.Build("metalava_api_diff", "metalava api diff")
+
+	ctx.Phony("java_api_diffs", al.apiDiff)
+}
+
+func (al *ApiLibrary) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case ".api_versions.xml":
+		if al.apiVersionsXml == nil {
+			return nil, fmt.Errorf("emit_api_versions is not set")
+		}
+		return android.Paths{al.apiVersionsXml}, nil
+	case ".api_diff":
+		if al.apiDiff == nil {
+			return nil, fmt.Errorf("api_diff_output is not set")
+		}
+		return android.Paths{al.apiDiff}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
 func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	al.validateProperties(ctx)
 
 	rule := android.NewRuleBuilder(pctx, ctx)
 
-	rule.Sbox(android.PathForModuleOut(ctx, "metalava"),
+	metalavaOutDir := android.PathForModuleOut(ctx, "metalava")
+	rule.Sbox(metalavaOutDir,
 		android.PathForModuleOut(ctx, "metalava.sbox.textproto")).
 		SandboxInputs()
 
@@ -2224,13 +3239,12 @@ func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	rule.Command().Text("rm -rf").Text(stubsDir.String())
 	rule.Command().Text("mkdir -p").Text(stubsDir.String())
 
-	homeDir := android.PathForModuleOut(ctx, "metalava", "home")
-
 	var srcFilesInfo []JavaApiImportInfo
 	var classPaths android.Paths
 	var staticLibs android.Paths
-	var depApiSrcsStubsJar android.Path
+	var depApiSrcsStubsJars android.Paths
 	var systemModulesPaths android.Paths
+	var systemModulesDirAndDeps *systemModules
 	ctx.VisitDirectDeps(func(dep android.Module) {
 		tag := ctx.OtherModuleDependencyTag(dep)
 		switch tag {
@@ -2248,10 +3262,12 @@ func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			staticLibs = append(staticLibs, provider.HeaderJars...)
 		case depApiSrcsTag:
 			provider, _ := android.OtherModuleProvider(ctx, dep, JavaInfoProvider)
-			depApiSrcsStubsJar = provider.HeaderJars[0]
+			depApiSrcsStubsJars = append(depApiSrcsStubsJars, provider.HeaderJars[0])
 		case systemModulesTag:
 			module := dep.(SystemModulesProvider)
 			systemModulesPaths = append(systemModulesPaths, module.HeaderJars()...)
+			outputDir, outputDeps := module.OutputDirAndDeps()
+			systemModulesDirAndDeps = &systemModules{outputDir, outputDeps}
 		case metalavaCurrentApiTimestampTag:
 			if currentApiTimestampProvider, ok := dep.(currentApiTimestampProvider); ok {
 				al.validationPaths = append(al.validationPaths, currentApiTimestampProvider.CurrentApiTimestamp())
@@ -2281,15 +3297,43 @@ func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		ctx.ModuleErrorf("Error: %s has an empty api file.", ctx.ModuleName())
 	}
 
-	cmd := metalavaStubCmd(ctx, rule, srcFiles, homeDir, systemModulesPaths)
+	metalavaClasspath := systemModulesPaths
+	if Bool(al.properties.Merge_static_libs_before_metalava) {
+		// Metalava resolves the classes referenced by the API files that are not defined in them
+		// from the classpath, which requires --api-class-resolution api:classpath.
+		metalavaClasspath = append(slices.Clone(systemModulesPaths), staticLibs...)
+		classPaths = append(classPaths, staticLibs...)
+	}
+	cmd := metalavaStubCmd(ctx, rule, srcFiles, metalavaOutDir, metalavaClasspath)
 
 	al.stubsFlags(ctx, cmd, stubsDir)
 
+	if String(al.properties.Previous_api) != "" && len(al.properties.Previous_apis) > 0 {
+		ctx.PropertyErrorf("previous_apis", "cannot be set together with previous_api")
+	}
 	migratingNullability := String(al.properties.Previous_api) != ""
 	if migratingNullability {
 		previousApi := android.PathForModuleSrc(ctx, String(al.properties.Previous_api))
 		cmd.FlagWithInput("--migrate-nullness ", previousApi)
 	}
+	previousApis := al.sortedPreviousApis(ctx)
+	for _, previousApi := range previousApis {
+		cmd.FlagWithInput("--migrate-nullness ", previousApi)
+	}
+
+	if Bool(al.properties.Api_diff_output) {
+		if migratingNullability {
+			previousApi := android.PathForModuleSrc(ctx, String(al.properties.Previous_api))
+			al.buildApiDiff(ctx, srcFiles, previousApi, systemModulesPaths)
+		} else {
+			ctx.PropertyErrorf("api_diff_output", "requires previous_api to be set")
+		}
+	}
+
+	if Bool(al.properties.Emit_api_versions) {
+		al.apiVersionsXml = android.PathForModuleOut(ctx, "metalava", ctx.ModuleName()+"-api-versions.xml")
+		al.apiVersionsFlags(ctx, cmd, previousApis)
+	}
 
 	al.addValidation(ctx, cmd, al.validationPaths)
 
@@ -2299,8 +3343,8 @@ func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	al.stubsJarWithoutStaticLibs = android.PathForModuleOut(ctx, "metalava", "stubs.jar")
 	al.stubsJar = android.PathForModuleOut(ctx, ctx.ModuleName(), fmt.Sprintf("%s.jar", ctx.ModuleName()))
 
-	if depApiSrcsStubsJar != nil {
-		al.extractApiSrcs(ctx, rule, stubsDir, depApiSrcsStubsJar)
+	if len(depApiSrcsStubsJars) > 0 {
+		al.extractApiSrcs(ctx, rule, stubsDir, depApiSrcsStubsJars)
 	}
 	rule.Command().
 		BuiltTool("soong_zip").
@@ -2312,12 +3356,14 @@ func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	rule.Build("metalava", "metalava merged text")
 
-	if depApiSrcsStubsJar == nil {
+	if len(depApiSrcsStubsJars) == 0 {
 		var flags javaBuilderFlags
-		flags.javaVersion = getStubsJavaVersion()
+		flags.javaVersion = al.stubsJavaVersion(ctx)
 		flags.javacFlags = strings.Join(al.properties.Javacflags, " ")
 		flags.classpath = classpath(classPaths)
 		flags.bootClasspath = classpath(systemModulesPaths)
+		// Stubs compiled at java 9 or higher use the system modules instead of the bootclasspath.
+		flags.systemModules = systemModulesDirAndDeps
 
 		annoSrcJar := android.PathForModuleOut(ctx, ctx.ModuleName(), "anno.srcjar")
 
@@ -2392,6 +3438,16 @@ var _ UsesLibraryDependency = (*ApiLibrary)(nil)
 type ImportProperties struct {
 	Jars []string `android:"path,arch_variant"`
 
+	// List of jars containing the API of jars that modules depending on this module compile
+	// against, for example stubs with the implementation details removed.  If empty the jars in
+	// jars are used.
+	Header_jars []string `android:"path,arch_variant"`
+
+	// if set to true, check that every class in header_jars is also in jars, so that the header
+	// jars don't expose classes that are missing at runtime.  Requires header_jars.  Defaults to
+	// false.
+	Check_header_jars *bool
+
 	// The version of the SDK that the source prebuilt file was built against. Defaults to the
 	// current version if not specified.
 	Sdk_version *string
@@ -2421,9 +3477,35 @@ type ImportProperties struct {
 	// List of directories to remove from the jar file(s)
 	Exclude_dirs []string
 
+	// if set to true, remove the directory entries from the combined jar file.  Defaults to false.
+	Strip_dir_entries *bool
+
+	// if set, flatten multi-release jars into a single-release jar for this Java version, with
+	// the classes in META-INF/versions/N for the highest N up to this version replacing the base
+	// classes.  Must be at least 9.  If unset the multi-release structure is preserved.
+	Multi_release_version *int
+
+	// List of java modules whose classes were baked into the jar file(s) and should be removed
+	// from them, e.g. because they conflict with platform classes.
+	Exclude_static_libs []string
+
 	// if set to true, run Jetifier against .jar file. Defaults to false.
 	Jetifier *bool
 
+	// if not blank, run jarjar using the specified rules file over the combined jar, after
+	// jetifier unless jarjar_before_jetifier is set.
+	Jarjar_rules *string `android:"path"`
+
+	// if set to true, run jarjar before jetifier rather than after it, for rules that match the
+	// original package names of the jar. Defaults to false.
+	Jarjar_before_jetifier *bool
+
+	// if set to true, check that the header of the dex files produced when compile_dex is set
+	// matches their contents, i.e. that the checksum, signature and file size are correct,
+	// failing the build otherwise.  The bytecode is not verified.  Requires compile_dex.
+	// Defaults to false.
+	Check_dex_checksum *bool
+
 	// set the name of the output
 	Stem *string
 
@@ -2431,6 +3513,11 @@ type ImportProperties struct {
 		// directories that should be added as include directories for any aidl sources of modules
 		// that depend on this module, as well as to aidl for this module.
 		Export_include_dirs []string
+
+		// zip file containing the aidl files that should be available to the aidl sources of
+		// modules that depend on this module.  It is extracted into a directory that is added as an
+		// include directory for them.  Cannot be set together with export_include_dirs.
+		Export_include_zip *string `android:"path"`
 	}
 
 	// Name of the source soong module that gets shadowed by this prebuilt
@@ -2471,6 +3558,7 @@ type Import struct {
 	combinedHeaderFile         android.Path
 	classLoaderContexts        dexpreopt.ClassLoaderContextMap
 	exportAidlIncludeDirs      android.Paths
+	exportAidlIncludeDeps      android.Paths
 
 	hideApexVariantFromMake bool
 
@@ -2555,8 +3643,17 @@ func (j *Import) setStrictUpdatabilityLinting(bool) {
 func (j *Import) DepsMutator(ctx android.BottomUpMutatorContext) {
 	ctx.AddVariationDependencies(nil, libTag, j.properties.Libs...)
 	ctx.AddVariationDependencies(nil, staticLibTag, j.properties.Static_libs...)
+	ctx.AddVariationDependencies(nil, excludedStaticLibTag, j.properties.Exclude_static_libs...)
 
 	if ctx.Device() && Bool(j.dexProperties.Compile_dex) {
+		// Report an sdk_version that cannot be used for dexing here, instead of letting it fail
+		// when resolving the sdk dependencies or in the dex rule.
+		switch sdkVersion := j.SdkVersion(ctx); sdkVersion.Kind {
+		case android.SdkInvalid, android.SdkToolchain:
+			ctx.PropertyErrorf("sdk_version", "%q cannot be used with compile_dex, it is not an sdk "+
+				"that device code can be dexed against", sdkVersion.Raw)
+			return
+		}
 		sdkDeps(ctx, android.SdkContext(j), j.dexer)
 	}
 }
@@ -2591,8 +3688,17 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	j.collectTransitiveHeaderJars(ctx)
 	var staticJars android.Paths
 	var staticHeaderJars android.Paths
+	var excludedStaticLibJars android.Paths
 	ctx.VisitDirectDeps(func(module android.Module) {
 		tag := ctx.OtherModuleDependencyTag(module)
+		if tag == excludedStaticLibTag {
+			if dep, ok := android.OtherModuleProvider(ctx, module, JavaInfoProvider); ok {
+				excludedStaticLibJars = append(excludedStaticLibJars, dep.ImplementationJars...)
+			} else {
+				ctx.PropertyErrorf("exclude_static_libs", "%q is not a java module", ctx.OtherModuleName(module))
+			}
+			return
+		}
 		if dep, ok := android.OtherModuleProvider(ctx, module, JavaInfoProvider); ok {
 			switch tag {
 			case libTag, sdkLibTag:
@@ -2623,20 +3729,77 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	outputFile := android.PathForModuleOut(ctx, "combined", jarName)
 	implementationJars := append(slices.Clone(jars), staticJars...)
 	TransformJarsToJar(ctx, outputFile, "combine prebuilt implementation jars", implementationJars, android.OptionalPath{},
-		false, j.properties.Exclude_files, j.properties.Exclude_dirs)
+		Bool(j.properties.Strip_dir_entries), j.properties.Exclude_files, j.properties.Exclude_dirs)
+	if len(excludedStaticLibJars) > 0 {
+		strippedOutputFile := android.PathForModuleOut(ctx, "exclude-static-libs", jarName)
+		TransformStripJarClasses(ctx, strippedOutputFile, outputFile, excludedStaticLibJars)
+		outputFile = strippedOutputFile
+	}
 
-	// If no dependencies have separate header jars then there is no need to create a separate
-	// header jar for this module.
-	reuseImplementationJarAsHeaderJar := slices.Equal(staticJars, staticHeaderJars)
+	multiReleaseVersion := 0
+	if v := j.properties.Multi_release_version; v != nil {
+		if *v < 9 {
+			ctx.PropertyErrorf("multi_release_version", "must be at least 9, got %d", *v)
+		} else {
+			multiReleaseVersion = *v
+			flattenedOutputFile := android.PathForModuleOut(ctx, "multi-release", jarName)
+			TransformFlattenMultiReleaseJar(ctx, flattenedOutputFile, outputFile, multiReleaseVersion)
+			outputFile = flattenedOutputFile
+		}
+	}
+
+	// If neither this module nor its dependencies have separate header jars then there is no need
+	// to create a separate header jar for this module.
+	headerJarsOfModule := android.PathsForModuleSrc(ctx, j.properties.Header_jars)
+	reuseImplementationJarAsHeaderJar := len(headerJarsOfModule) == 0 && slices.Equal(staticJars, staticHeaderJars)
 
 	var headerOutputFile android.ModuleOutPath
 	if reuseImplementationJarAsHeaderJar {
 		headerOutputFile = outputFile
 	} else {
-		headerJars := append(slices.Clone(jars), staticHeaderJars...)
+		headerJars := slices.Clone(jars)
+		if len(headerJarsOfModule) > 0 {
+			headerJars = slices.Clone(headerJarsOfModule)
+		}
+		headerJars = append(headerJars, staticHeaderJars...)
 		headerOutputFile = android.PathForModuleOut(ctx, "turbine-combined", jarName)
 		TransformJarsToJar(ctx, headerOutputFile, "combine prebuilt header jars", headerJars, android.OptionalPath{},
-			false, j.properties.Exclude_files, j.properties.Exclude_dirs)
+			Bool(j.properties.Strip_dir_entries), j.properties.Exclude_files, j.properties.Exclude_dirs)
+		if len(excludedStaticLibJars) > 0 {
+			strippedHeaderOutputFile := android.PathForModuleOut(ctx, "exclude-static-libs-headers", jarName)
+			TransformStripJarClasses(ctx, strippedHeaderOutputFile, headerOutputFile, excludedStaticLibJars)
+			headerOutputFile = strippedHeaderOutputFile
+		}
+		if multiReleaseVersion != 0 {
+			flattenedHeaderOutputFile := android.PathForModuleOut(ctx, "multi-release-headers", jarName)
+			TransformFlattenMultiReleaseJar(ctx, flattenedHeaderOutputFile, headerOutputFile, multiReleaseVersion)
+			headerOutputFile = flattenedHeaderOutputFile
+		}
+	}
+
+	jarjar := func() {
+		if j.properties.Jarjar_rules == nil {
+			return
+		}
+		rulesFile := android.PathForModuleSrc(ctx, *j.properties.Jarjar_rules)
+		inputFile := outputFile
+		outputFile = android.PathForModuleOut(ctx, "jarjar", jarName)
+		TransformJarJar(ctx, outputFile, inputFile, rulesFile)
+
+		if !reuseImplementationJarAsHeaderJar {
+			headerInputFile := headerOutputFile
+			headerOutputFile = android.PathForModuleOut(ctx, "jarjar-headers", jarName)
+			TransformJarJar(ctx, headerOutputFile, headerInputFile, rulesFile)
+		} else {
+			headerOutputFile = outputFile
+		}
+	}
+
+	if Bool(j.properties.Jarjar_before_jetifier) {
+		if j.properties.Jarjar_rules == nil {
+			ctx.PropertyErrorf("jarjar_before_jetifier", "requires jarjar_rules to be set")
+		}
+		jarjar()
 	}
 
 	if Bool(j.properties.Jetifier) {
@@ -2653,6 +3816,29 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		}
 	}
 
+	if !Bool(j.properties.Jarjar_before_jetifier) {
+		jarjar()
+	}
+
+	if Bool(j.properties.Check_header_jars) {
+		if len(headerJarsOfModule) == 0 {
+			ctx.PropertyErrorf("check_header_jars", "requires header_jars to be set")
+		} else {
+			checkFile := android.PathForModuleOut(ctx, "header-jar-check", jarName+".stamp")
+			CheckHeaderJarClasses(ctx, checkFile, headerOutputFile, outputFile)
+
+			// Make any dependency on the header jar run the check.
+			headerInputFile := headerOutputFile
+			headerOutputFile = android.PathForModuleOut(ctx, "checked-headers", jarName)
+			ctx.Build(pctx, android.BuildParams{
+				Rule:       android.Cp,
+				Input:      headerInputFile,
+				Output:     headerOutputFile,
+				Validation: checkFile,
+			})
+		}
+	}
+
 	// Save the output file with no relative path so that it doesn't end up in a subdirectory when used as a resource.
 	// Also strip the relative path from the header output file so that the reuseImplementationJarAsHeaderJar check
 	// in a module that depends on this module considers them equal.
@@ -2662,6 +3848,32 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	j.maybeInstall(ctx, jarName, outputFile)
 
 	j.exportAidlIncludeDirs = android.PathsForModuleSrc(ctx, j.properties.Aidl.Export_include_dirs)
+	if j.properties.Aidl.Export_include_zip != nil {
+		if len(j.properties.Aidl.Export_include_dirs) > 0 {
+			ctx.PropertyErrorf("aidl.export_include_zip", "cannot be set together with aidl.export_include_dirs")
+		}
+		includeZip := android.PathForModuleSrc(ctx, *j.properties.Aidl.Export_include_zip)
+		includeDir := android.PathForModuleOut(ctx, "aidl_includes")
+		includeStamp := android.PathForModuleOut(ctx, "aidl_includes.stamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        unzipAidlIncludes,
+			Description: "unzip aidl includes",
+			Input:       includeZip,
+			Output:      includeStamp,
+			Args: map[string]string{
+				"outDir": includeDir.String(),
+			},
+		})
+		j.exportAidlIncludeDirs = append(j.exportAidlIncludeDirs, includeDir)
+		j.exportAidlIncludeDeps = android.Paths{includeStamp}
+	}
+
+	if Bool(j.properties.Check_dex_checksum) && !Bool(j.dexProperties.Compile_dex) {
+		ctx.PropertyErrorf("check_dex_checksum", "requires compile_dex to be set")
+	}
+	if j.dexProperties.Compact_dex != nil && !Bool(j.dexProperties.Compile_dex) {
+		ctx.PropertyErrorf("compact_dex", "requires compile_dex to be set")
+	}
 
 	if ctx.Device() {
 		// If this is a variant created for a prebuilt_apex then use the dex implementation jar
@@ -2715,6 +3927,7 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 				ctx, android.RemoveOptionalPrebuiltPrefix(ctx.ModuleName()), android.PathForModuleInstall(ctx, "framework", jarName))
 			setUncompressDex(ctx, &j.dexpreopter, &j.dexer)
 			j.dexpreopter.uncompressedDex = *j.dexProperties.Uncompress_dex
+			j.dexpreopter.compactDex = j.dexProperties.Compact_dex
 
 			var dexOutputFile android.OutputPath
 			dexParams := &compileDexParams{
@@ -2730,6 +3943,12 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 				return
 			}
 
+			if Bool(j.properties.Check_dex_checksum) {
+				checkedDexOutputFile := android.PathForModuleOut(ctx, "dex-checked", jarName).OutputPath
+				TransformCheckDexChecksum(ctx, checkedDexOutputFile, dexOutputFile)
+				dexOutputFile = checkedDexOutputFile
+			}
+
 			// Initialize the hiddenapi structure.
 			j.initHiddenAPI(ctx, makeDexJarPathFromPath(dexOutputFile), outputFile, j.dexProperties.Uncompress_dex)
 
@@ -2747,7 +3966,12 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		TransitiveStaticLibsHeaderJars: j.transitiveStaticLibsHeaderJars,
 		ImplementationAndResourcesJars: android.PathsIfNonNil(j.combinedImplementationFile),
 		ImplementationJars:             android.PathsIfNonNil(j.combinedImplementationFile),
+		TransitiveResourceJars:         collectTransitive(ctx, nil, transitiveResourceJars, nil, staticLibTag),
+		TransitiveJvmFlags:             collectTransitive(ctx, nil, transitiveJvmFlags, nil, staticLibTag),
+		TransitiveExportedKotlincFlags: collectTransitive(ctx, nil, transitiveExportedKotlincFlags, nil, staticLibTag),
+		TransitiveAconfigFiles:         collectTransitive(ctx, nil, transitiveAconfigFiles, nil, libTag, staticLibTag),
 		AidlIncludeDirs:                j.exportAidlIncludeDirs,
+		AidlIncludeDeps:                j.exportAidlIncludeDeps,
 		StubsLinkType:                  j.stubsLinkType,
 		// TODO(b/289117800): LOCAL_ACONFIG_FILES for prebuilts
 	})
@@ -2936,6 +4160,28 @@ type DexImportProperties struct {
 
 	// set the name of the output
 	Stem *string
+
+	// Alignment in bytes of the uncompressed dex files, must be a power of two.  Defaults to 4.
+	// Use 16384 for devices with 16KB pages.
+	Dex_align *int
+
+	// If true, the jar is a boot jar.  Its dex files are stored uncompressed and its classes are
+	// checked to be in permitted_packages.  Defaults to false.
+	Is_boot_jar *bool
+
+	// If is_boot_jar is true, the list of packages, including their subpackages, that the classes
+	// of the jar must be in.
+	Permitted_packages []string
+
+	// If set, forces the dex files in the jar to be stored uncompressed (true) or compressed
+	// (false), overriding the default that is based on the module.  Cannot be false when
+	// is_boot_jar is true, as the dex files of boot jars are always stored uncompressed.
+	Uncompress_dex *bool
+
+	// If set, the build fails if the version of any of the dex files in the jar requires a newer
+	// API level than this one, e.g. a dex file compiled for API level 28 cannot be used with
+	// min_api_level: "26".
+	Min_api_level *string
 }
 
 type DexImport struct {
@@ -3001,6 +4247,23 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	j.dexpreopter.installPath = j.dexpreopter.getInstallPath(
 		ctx, android.RemoveOptionalPrebuiltPrefix(ctx.ModuleName()), android.PathForModuleInstall(ctx, "framework", j.Stem()+".jar"))
 	j.dexpreopter.uncompressedDex = shouldUncompressDex(ctx, android.RemoveOptionalPrebuiltPrefix(ctx.ModuleName()), &j.dexpreopter)
+	isBootJar := Bool(j.properties.Is_boot_jar)
+	if isBootJar {
+		j.dexpreopter.uncompressedDex = true
+		if len(j.properties.Permitted_packages) == 0 {
+			ctx.PropertyErrorf("permitted_packages", "must be set when is_boot_jar is true")
+		}
+		if j.properties.Uncompress_dex != nil && !*j.properties.Uncompress_dex {
+			ctx.PropertyErrorf("uncompress_dex", "cannot be false when is_boot_jar is true")
+		}
+	} else {
+		if len(j.properties.Permitted_packages) > 0 {
+			ctx.PropertyErrorf("permitted_packages", "can only be set when is_boot_jar is true")
+		}
+		if j.properties.Uncompress_dex != nil {
+			j.dexpreopter.uncompressedDex = *j.properties.Uncompress_dex
+		}
+	}
 
 	inputJar := ctx.ExpandSource(j.properties.Jars[0], "jars")
 	dexOutputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".jar")
@@ -3022,7 +4285,7 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		rule.Command().
 			BuiltTool("zipalign").
 			Flag("-f").
-			Text("4").
+			Text(strconv.Itoa(dexAlignment(ctx, j.properties.Dex_align))).
 			Input(temporary).
 			Output(dexOutputFile)
 
@@ -3037,6 +4300,39 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		})
 	}
 
+	if isBootJar && len(j.properties.Permitted_packages) > 0 {
+		// Copy the dex jar to another path with a validation dependency on the package check, as is
+		// done for the boot jars built from source.
+		pkgckFile := android.PathForModuleOut(ctx, "package-check.stamp")
+		checkedDexJar := android.PathForModuleOut(ctx, "package-check", ctx.ModuleName()+".jar")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:       android.Cp,
+			Input:      dexOutputFile,
+			Output:     checkedDexJar,
+			Validation: pkgckFile,
+		})
+		buildRuleForDexJarPackageCheck(ctx, pkgckFile, dexOutputFile, j.properties.Permitted_packages)
+		dexOutputFile = checkedDexJar
+	}
+
+	if j.properties.Min_api_level != nil {
+		minApiLevel, err := android.ApiLevelFromUser(ctx, *j.properties.Min_api_level)
+		if err != nil {
+			ctx.PropertyErrorf("min_api_level", "%s", err)
+		} else {
+			apiLevelCheckFile := android.PathForModuleOut(ctx, "dex-api-level-check.stamp")
+			checkedDexJar := android.PathForModuleOut(ctx, "dex-api-level-check", ctx.ModuleName()+".jar")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:       android.Cp,
+				Input:      dexOutputFile,
+				Output:     checkedDexJar,
+				Validation: apiLevelCheckFile,
+			})
+			CheckDexApiLevel(ctx, apiLevelCheckFile, inputJar, minApiLevel.FinalOrFutureInt())
+			dexOutputFile = checkedDexJar
+		}
+	}
+
 	j.dexJarFile = makeDexJarPathFromPath(dexOutputFile)
 
 	j.dexpreopt(ctx, android.RemoveOptionalPrebuiltPrefix(ctx.ModuleName()), dexOutputFile)
@@ -3153,18 +4449,22 @@ func kytheExtractJavaFactory() android.Singleton {
 }
 
 type kytheExtractJavaSingleton struct {
+	// The inputs of the xref_java phony target, sorted so that they don't depend on the order in
+	// which the modules are visited.
+	xrefTargets android.Paths
 }
 
 func (ks *kytheExtractJavaSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 	var xrefTargets android.Paths
 	ctx.VisitAllModules(func(module android.Module) {
-		if javaModule, ok := module.(xref); ok {
-			xrefTargets = append(xrefTargets, javaModule.XrefJavaFiles()...)
+		if info, ok := android.SingletonModuleProvider(ctx, module, KytheInfoProvider); ok {
+			xrefTargets = append(xrefTargets, info.XrefJavaFiles...)
 		}
 	})
+	ks.xrefTargets = android.SortedUniquePaths(xrefTargets)
 	// TODO(asmundak): perhaps emit a rule to output a warning if there were no xrefTargets
-	if len(xrefTargets) > 0 {
-		ctx.Phony("xref_java", xrefTargets...)
+	if len(ks.xrefTargets) > 0 {
+		ctx.Phony("xref_java", ks.xrefTargets...)
 	}
 }
 
@@ -3182,6 +4482,8 @@ func addCLCFromDep(ctx android.ModuleContext, depModule android.Module,
 		return
 	}
 
+	checkProvidesUsesLib(ctx, depModule)
+
 	depName := android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(depModule))
 
 	var sdkLib *string
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -325,6 +326,41 @@ func TestExportedPlugins(t *testing.T) {
 				{library: "bar", processors: "-processor com.android.TestPlugin", disableTurbine: true},
 			},
 		},
+		{
+			name: "Exports plugin with generates_api to dependee that lets turbine process annotations",
+			extra: `
+				java_library{name: "exports", exported_plugins: ["plugin_generates_api"]}
+				java_library{name: "foo", srcs: ["a.java"], libs: ["exports"], turbine_process_annotations: true}
+			`,
+			results: []Result{
+				{library: "foo", processors: "-processor com.android.TestPlugin"},
+			},
+		},
+		{
+			name: "Exports plugin that generates API only when an option is passed",
+			extra: `
+				java_library{name: "exports", exported_plugins: ["plugin", "plugin_generates_api_when_option"]}
+				java_library{name: "foo", srcs: ["a.java"], libs: ["exports"]}
+				java_library{name: "bar", srcs: ["a.java"], static_libs: ["exports"], javacflags: ["-Acom.android.generate_api=true"]}
+				java_library{name: "baz", srcs: ["a.java"], libs: ["exports"], javacflags: ["-Acom.android.generate_api_other"]}
+			`,
+			results: []Result{
+				{library: "foo", processors: "-processor com.android.TestPlugin,com.android.TestPlugin2"},
+				{library: "bar", processors: "-processor com.android.TestPlugin,com.android.TestPlugin2", disableTurbine: true},
+				{library: "baz", processors: "-processor com.android.TestPlugin,com.android.TestPlugin2"},
+			},
+		},
+		{
+			name: "Plugin that generates API only when an option is passed",
+			extra: `
+				java_library{name: "foo", srcs: ["a.java"], plugins: ["plugin_generates_api_when_option"]}
+				java_library{name: "bar", srcs: ["a.java"], plugins: ["plugin_generates_api_when_option"], javacflags: ["-Acom.android.generate_api"]}
+			`,
+			results: []Result{
+				{library: "foo", processors: "-processor com.android.TestPlugin2"},
+				{library: "bar", processors: "-processor com.android.TestPlugin2", disableTurbine: true},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -339,6 +375,11 @@ func TestExportedPlugins(t *testing.T) {
 					generates_api: true,
 					processor_class: "com.android.TestPlugin",
 				}
+				java_plugin {
+					name: "plugin_generates_api_when_option",
+					generates_api_when_option: "com.android.generate_api",
+					processor_class: "com.android.TestPlugin2",
+				}
 			`+test.extra)
 
 			for _, want := range test.results {
@@ -356,6 +397,87 @@ func TestExportedPlugins(t *testing.T) {
 	}
 }
 
+func TestTurbineProcessAnnotations(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_plugin {
+			name: "plugin_generates_api",
+			srcs: ["plugin.java"],
+			generates_api: true,
+			processor_class: "com.android.TestPlugin",
+		}
+
+		java_library {
+			name: "exports",
+			exported_plugins: ["plugin_generates_api"],
+		}
+
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["exports"],
+			turbine_process_annotations: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			libs: ["exports"],
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+	plugin := ctx.ModuleForTests("plugin_generates_api", buildOS+"_common").Rule("javac").Output.String()
+
+	turbine := ctx.ModuleForTests("foo", "android_common").Rule("turbine")
+	android.AssertStringDoesContain(t, "turbine processors", turbine.Args["turbineFlags"],
+		"--processors com.android.TestPlugin")
+	android.AssertStringDoesContain(t, "turbine processorpath", turbine.Args["turbineFlags"],
+		"--processorpath "+plugin)
+	android.AssertStringListContains(t, "turbine implicits", turbine.Implicits.Strings(), plugin)
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if bar.MaybeRule("turbine").Rule != nil {
+		t.Errorf("expected turbine to be disabled for bar")
+	}
+}
+
+func TestTurbineOutputFile(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_plugin {
+			name: "plugin_generates_api",
+			generates_api: true,
+			processor_class: "com.android.TestPlugin",
+		}
+
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			plugins: ["plugin_generates_api"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	turbine := foo.Rule("turbine")
+	outputs, err := foo.Module().(*Library).OutputFiles(".turbine")
+	android.AssertSame(t, "foo output files error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "foo turbine output",
+		[]string{turbine.Output.String()}, outputs)
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if bar.MaybeRule("turbine").Rule != nil {
+		t.Errorf("expected turbine to be disabled for bar")
+	}
+	_, err = bar.Module().(*Library).OutputFiles(".turbine")
+	if err == nil {
+		t.Errorf("expected an error for the turbine output of bar")
+	}
+}
+
 func TestSdkVersionByPartition(t *testing.T) {
 	testJavaError(t, "sdk_version must have a value when the module is located at vendor or product", `
 		java_library {
@@ -459,6 +581,76 @@ func TestBinary(t *testing.T) {
 	}
 }
 
+func TestBinaryHostWrapperOverride(t *testing.T) {
+	bp := `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_binary_host {
+			name: "bar",
+			srcs: ["b.java"],
+			wrapper: "bar.sh",
+		}
+	`
+	overrideWrapper := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.JavaHostBinaryWrapper = proptools.StringPtr("vendor/tools/wrapper.sh")
+	})
+
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		overrideWrapper,
+		android.FixtureAddFile("vendor/tools/wrapper.sh", nil),
+		android.FixtureAddFile("bar.sh", nil),
+	).RunTestWithBp(t, bp)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_x86_64").Output("foo")
+	android.AssertPathRelativeToTopEquals(t, "foo wrapper", "vendor/tools/wrapper.sh", foo.Input)
+	bar := result.ModuleForTests("bar", buildOS+"_x86_64").Output("bar")
+	android.AssertPathRelativeToTopEquals(t, "bar wrapper", "bar.sh", bar.Input)
+
+	android.GroupFixturePreparers(
+		prepareForJavaTest,
+		overrideWrapper,
+		android.FixtureAddFile("bar.sh", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`JavaHostBinaryWrapper product variable "vendor/tools/wrapper.sh" does not exist`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestBinaryHostRequired(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			host_required: ["helper"],
+		}
+
+		cc_binary_host {
+			name: "helper",
+			stl: "none",
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+
+	fooWrapperDeps := ctx.ModuleForTests("foo", buildOS+"_x86_64").Output("foo").Implicits.Strings()
+	helperInstalls := ctx.ModuleForTests("helper", buildOS+"_x86_64").Module().FilesToInstall().Strings()
+	if len(helperInstalls) == 0 {
+		t.Fatalf("expected helper to be installed")
+	}
+
+	// Test that the install binary wrapper depends on the installed host tool
+	for _, w := range helperInstalls {
+		if !android.InList(w, fooWrapperDeps) {
+			t.Errorf("expected binary wrapper implicits to contain %q, got %q", w, fooWrapperDeps)
+		}
+	}
+}
+
 func TestTest(t *testing.T) {
 	ctx, _ := testJava(t, `
 		java_test_host {
@@ -491,6 +683,73 @@ func TestTest(t *testing.T) {
 	}
 }
 
+func TestTestJniLibsAbis(t *testing.T) {
+	bp := `
+		java_test_host {
+			name: "foo",
+			srcs: ["a.java"],
+			jni_libs: ["libjni"],
+			jni_libs_abis: [%s],
+		}
+
+		cc_library_shared {
+			name: "libjni",
+			host_supported: true,
+			device_supported: false,
+			stl: "none",
+		}
+	`
+
+	expected := "lib64/libjni.so"
+	if runtime.GOOS == "darwin" {
+		expected = "lib64/libjni.dylib"
+	}
+
+	ctx, _ := testJava(t, fmt.Sprintf(bp, `"x86_64"`))
+	buildOS := ctx.Config().BuildOS.String()
+	foo := ctx.ModuleForTests("foo", buildOS+"_common")
+	fooTestData := foo.Module().(*TestHost).data
+	if len(fooTestData) != 1 || fooTestData[0].Rel() != expected {
+		t.Errorf(`expected foo test data relative path [%q], got %q`, expected, fooTestData.Strings())
+	}
+	relocated := "out/soong/.intermediates/foo/" + buildOS + "_common/relocated/" + expected
+	foo.Output(relocated)
+
+	ctx, _ = testJava(t, fmt.Sprintf(bp, `"arm64-v8a"`))
+	foo = ctx.ModuleForTests("foo", buildOS+"_common")
+	android.AssertIntEquals(t, "foo test data", 0, len(foo.Module().(*TestHost).data))
+	if foo.MaybeOutput(relocated).Rule != nil {
+		t.Errorf("expected %s not to be relocated", expected)
+	}
+
+	testJavaError(t, `jni_libs_abis: unknown ABI "mips"`, fmt.Sprintf(bp, `"mips"`))
+}
+
+func TestTestJniLibsMinSdkVersion(t *testing.T) {
+	bp := `
+		java_test_host {
+			name: "foo",
+			srcs: ["a.java"],
+			min_sdk_version: "29",
+			jni_libs: ["libjni"],
+			%s
+		}
+
+		cc_library_shared {
+			name: "libjni",
+			host_supported: true,
+			device_supported: false,
+			stl: "none",
+			min_sdk_version: "31",
+		}
+	`
+
+	testJavaError(t, `jni_libs: min_sdk_version\(31\) of "libjni" is higher than min_sdk_version\(29\) of the test`,
+		fmt.Sprintf(bp, ""))
+
+	testJava(t, fmt.Sprintf(bp, "skip_jni_libs_min_sdk_version_check: true,"))
+}
+
 func TestHostBinaryNoJavaDebugInfoOverride(t *testing.T) {
 	bp := `
 		java_library {
@@ -848,6 +1107,42 @@ func TestResources(t *testing.T) {
 	}
 }
 
+func TestResourcesNotCompileInputs(t *testing.T) {
+	ctx, _ := testJavaWithFS(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			java_resource_dirs: ["java-res"],
+			java_resources: ["res.txt"],
+		}
+	`,
+		map[string][]byte{
+			"java-res/a/a": nil,
+			"res.txt":      nil,
+		},
+	)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooRes := foo.Output("res/foo.jar")
+	resources := []string{"java-res/a/a", "res.txt", fooRes.Output.String()}
+
+	// The resources are only inputs of the resource jar and the jars it is merged into, so that
+	// changing a resource doesn't rerun turbine or javac.
+	for _, output := range []string{"javac/foo.jar", "turbine/foo.jar"} {
+		params := foo.Output(output)
+		inputs := append(android.PathsIfNonNil(params.Input), params.Inputs...)
+		inputs = append(inputs, params.Implicits...)
+		for _, resource := range resources {
+			android.AssertStringListDoesNotContain(t, output+" inputs", inputs.Strings(), resource)
+		}
+	}
+
+	android.AssertStringListContains(t, "resource jar inputs", fooRes.Implicits.Strings(), "java-res/a/a")
+	android.AssertStringListContains(t, "resource jar inputs", fooRes.Implicits.Strings(), "res.txt")
+	android.AssertStringListContains(t, "combined jar inputs", foo.Output("withres/foo.jar").Inputs.Strings(),
+		fooRes.Output.String())
+}
+
 func TestIncludeSrcs(t *testing.T) {
 	ctx, _ := testJavaWithFS(t, `
 		java_library {
@@ -915,6 +1210,42 @@ func TestIncludeSrcs(t *testing.T) {
 	}
 }
 
+func TestSourcesJarExtraFiles(t *testing.T) {
+	ctx, _ := testJavaWithFS(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			include_srcs: true,
+			sources_jar_extra_files: ["LICENSE"],
+		}
+	`, map[string][]byte{
+		"LICENSE": nil,
+	})
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooSourcesJar := foo.Output("sources/foo-sources.jar")
+	android.AssertStringEquals(t, "foo sources jar args", "-C . -f a.java -f LICENSE", fooSourcesJar.Args["jarArgs"])
+	outputs, err := foo.Module().(*Library).OutputFiles(".srcjar")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "foo .srcjar output",
+		[]string{"out/soong/.intermediates/foo/android_common/sources/foo-sources.jar"}, outputs)
+
+	// The extra files are not part of the sources included in the implementation jar.
+	fooIncludeSrcJar := foo.Output("foo.srcjar")
+	android.AssertStringEquals(t, "foo include_srcs jar args", "-C . -f a.java", fooIncludeSrcJar.Args["jarArgs"])
+	fooJavaInfo, _ := android.SingletonModuleProvider(ctx, foo.Module(), JavaInfoProvider)
+	android.AssertDeepEquals(t, "foo SrcJarArgs", []string{"-C", ".", "-f", "a.java"}, fooJavaInfo.SrcJarArgs)
+
+	testJavaError(t, `sources_jar_extra_files: "a.java" collides with a source file`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			include_srcs: true,
+			sources_jar_extra_files: ["a.java"],
+		}
+	`)
+}
+
 func TestGeneratedSources(t *testing.T) {
 	ctx, _ := testJavaWithFS(t, `
 		java_library {
@@ -1148,58 +1479,843 @@ func TestJavaImport(t *testing.T) {
 		[]string{"import_deps.jar", importWithNoDepsJar.Output.String()}, importWithImportDepsJar.Inputs)
 }
 
-var compilerFlagsTestCases = []struct {
-	in  string
-	out bool
-}{
-	{
-		in:  "a",
-		out: false,
-	},
-	{
-		in:  "-a",
-		out: true,
-	},
-	{
-		in:  "-no-jdk",
-		out: false,
-	},
-	{
-		in:  "-no-stdlib",
-		out: false,
-	},
-	{
-		in:  "-kotlin-home",
-		out: false,
-	},
-	{
-		in:  "-kotlin-home /some/path",
-		out: false,
-	},
-	{
-		in:  "-include-runtime",
-		out: false,
-	},
-	{
-		in:  "-Xintellij-plugin-root",
-		out: false,
-	},
-}
+func TestJavaImportCheckDexChecksum(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			compile_dex: true,
+			check_dex_checksum: true,
+		}
+	`)
 
-type mockContext struct {
-	android.ModuleContext
-	result bool
-}
+	foo := result.ModuleForTests("foo", "android_common")
+	check := foo.Rule("checkDexChecksum")
+	android.AssertPathRelativeToTopEquals(t, "check dex checksum input",
+		"out/soong/.intermediates/foo/android_common/dex/foo.jar", check.Input)
+	android.AssertPathRelativeToTopEquals(t, "check dex checksum output",
+		"out/soong/.intermediates/foo/android_common/dex-checked/foo.jar", check.Output)
 
-func (ctx *mockContext) PropertyErrorf(property, format string, args ...interface{}) {
-	// CheckBadCompilerFlags calls this function when the flag should be rejected
-	ctx.result = false
+	dexJar := foo.Module().(*Import).DexJarBuildPath(moduleErrorfTestCtx{})
+	android.AssertPathRelativeToTopEquals(t, "dex jar build path",
+		"out/soong/.intermediates/foo/android_common/dex-checked/foo.jar", dexJar.Path())
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`check_dex_checksum: requires compile_dex to be set`,
+	)).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			check_dex_checksum: true,
+		}
+	`)
 }
 
-func TestCompilerFlags(t *testing.T) {
-	for _, testCase := range compilerFlagsTestCases {
-		ctx := &mockContext{result: true}
-		CheckKotlincFlags(ctx, []string{testCase.in})
+func TestLibrarySourcesJar(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "b.java"],
+		}
+
+		genrule {
+			name: "gen",
+			srcs: [":foo{.srcjar}"],
+			out: ["out.srcjar"],
+			cmd: "cp $(in) $(out)",
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	sourcesJar := foo.Output("sources/foo-sources.jar")
+	android.AssertPathsRelativeToTopEquals(t, "sources jar inputs",
+		[]string{"a.java", "b.java"}, sourcesJar.Implicits)
+
+	gen := ctx.ModuleForTests("gen", "").Rule("generator")
+	android.AssertStringListContains(t, "genrule inputs", android.PathsRelativeToTop(gen.Implicits),
+		"out/soong/.intermediates/foo/android_common/sources/foo-sources.jar")
+}
+
+func TestXlint(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			xlint: {
+				enable: ["rawtypes", "cast", "rawtypes"],
+				disable: ["deprecation"],
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+	`)
+
+	fooFlags := ctx.ModuleForTests("foo", "android_common").Module().VariablesForTests()["javacFlags"]
+	android.AssertStringDoesContain(t, "foo javacFlags", fooFlags, "-Xlint:cast,rawtypes,-deprecation")
+
+	barFlags := ctx.ModuleForTests("bar", "android_common").Module().VariablesForTests()["javacFlags"]
+	android.AssertStringEquals(t, "bar -Xlint flags", "-Xlint:-dep-ann",
+		strings.Join(android.FilterListPred(strings.Fields(barFlags), func(s string) bool {
+			return strings.HasPrefix(s, "-Xlint")
+		}), " "))
+
+	testJavaError(t, `xlint.disable: category names must not be empty`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			xlint: {
+				disable: [""],
+			},
+		}
+	`)
+}
+
+func TestJavaModuleVersion(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "module-info.java"],
+			java_module: {
+				version: "1.2.3-beta+42",
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java", "module-info.java"],
+		}
+	`)
+
+	fooFlags := ctx.ModuleForTests("foo", "android_common").Module().VariablesForTests()["javacFlags"]
+	android.AssertStringDoesContain(t, "foo javacFlags", fooFlags, "--module-version 1.2.3-beta+42")
+
+	barFlags := ctx.ModuleForTests("bar", "android_common").Module().VariablesForTests()["javacFlags"]
+	android.AssertStringDoesNotContain(t, "bar javacFlags", barFlags, "--module-version")
+
+	testJavaError(t, `java_module.version: "v1" is not a valid module version`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "module-info.java"],
+			java_module: {
+				version: "v1",
+			},
+		}
+	`)
+
+	testJavaError(t, `java_module.version: requires a module-info.java in srcs`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			java_module: {
+				version: "1.0",
+			},
+		}
+	`)
+}
+
+func TestJpmsModule(t *testing.T) {
+	ctx, config := testJava(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java", "module-info.java"],
+			static_libs: ["baz"],
+			jpms_module: true,
+			java_module: {
+				name: "com.example.foo",
+			},
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["a.java", "module-info.java"],
+			static_libs: ["baz"],
+		}
+
+		java_library_host {
+			name: "baz",
+			srcs: ["b.java"],
+		}
+	`)
+
+	variant := config.BuildOS.String() + "_common"
+
+	foo := ctx.ModuleForTests("foo", variant)
+	android.AssertStringListContains(t, "foo javac inputs", foo.Output("javac/foo.jar").Inputs.Strings(),
+		"module-info.java")
+	android.AssertStringDoesNotContain(t, "foo combined jarArgs", foo.Output("combined/foo.jar").Args["jarArgs"],
+		"-stripFile module-info.class")
+	fooInfo, _ := android.SingletonModuleProvider(ctx, foo.Module(), JavaInfoProvider)
+	android.AssertStringEquals(t, "foo JpmsModuleName", "com.example.foo", fooInfo.JpmsModuleName)
+
+	bar := ctx.ModuleForTests("bar", variant)
+	android.AssertStringDoesContain(t, "bar combined jarArgs", bar.Output("combined/bar.jar").Args["jarArgs"],
+		"-stripFile module-info.class")
+	barInfo, _ := android.SingletonModuleProvider(ctx, bar.Module(), JavaInfoProvider)
+	android.AssertStringEquals(t, "bar JpmsModuleName", "", barInfo.JpmsModuleName)
+
+	testJavaError(t, `jpms_module: requires java 9 or later, got java 1.8`, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java", "module-info.java"],
+			java_version: "1.8",
+			jpms_module: true,
+			java_module: {
+				name: "com.example.foo",
+			},
+		}
+	`)
+
+	testJavaError(t, `jpms_module: requires a module-info.java in srcs`, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			jpms_module: true,
+			java_module: {
+				name: "com.example.foo",
+			},
+		}
+	`)
+
+	testJavaError(t, `java_module.name: is required when jpms_module is true`, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java", "module-info.java"],
+			jpms_module: true,
+		}
+	`)
+}
+
+func TestReleaseSrcs(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			release_srcs: [
+				{
+					build_releases: "S-Tiramisu",
+					srcs: ["tiramisu/b.java"],
+				},
+				{
+					build_releases: "UpsideDownCake+",
+					srcs: ["upsidedowncake/b.java"],
+				},
+			],
+		}
+	`
+
+	testCases := []struct {
+		name               string
+		platformSdkVersion int
+		expected           []string
+	}{
+		{
+			name:               "Tiramisu",
+			platformSdkVersion: 33,
+			expected:           []string{"a.java", "tiramisu/b.java"},
+		},
+		{
+			name:               "UpsideDownCake",
+			platformSdkVersion: 34,
+			expected:           []string{"a.java", "upsidedowncake/b.java"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				PrepareForTestWithJavaDefaultModules,
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.Platform_sdk_version = proptools.IntPtr(tc.platformSdkVersion)
+					variables.Platform_sdk_final = proptools.BoolPtr(true)
+				}),
+			).RunTestWithBp(t, bp)
+
+			javac := result.ModuleForTests("foo", "android_common").Rule("javac")
+			android.AssertPathsRelativeToTopEquals(t, "javac inputs", tc.expected, javac.Inputs)
+		})
+	}
+
+	t.Run("kotlin", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.Platform_sdk_version = proptools.IntPtr(34)
+				variables.Platform_sdk_final = proptools.BoolPtr(true)
+			}),
+		).RunTestWithBp(t, `
+			java_library {
+				name: "foo",
+				srcs: ["a.java"],
+				release_srcs: [
+					{
+						build_releases: "UpsideDownCake+",
+						srcs: ["b.kt"],
+					},
+				],
+			}
+		`)
+
+		foo := result.ModuleForTests("foo", "android_common")
+		kotlinc := foo.Rule("kotlinc")
+		android.AssertPathsRelativeToTopEquals(t, "kotlinc inputs", []string{"a.java", "b.kt"}, kotlinc.Inputs)
+		kotlinStdlib := result.ModuleForTests("kotlin-stdlib", "android_common").
+			Output("turbine-combined/kotlin-stdlib.jar").Output
+		android.AssertStringListContains(t, "combined jar inputs", foo.Output("combined/foo.jar").Inputs.Strings(), kotlinStdlib.String())
+	})
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`release_srcs: invalid build_releases "Donut\+": unknown release "Donut"`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			release_srcs: [
+				{
+					build_releases: "Donut+",
+					srcs: ["b.java"],
+				},
+			],
+		}
+	`)
+}
+
+func TestKytheExtractJava(t *testing.T) {
+	modules := []string{"c", "a", "b"}
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+		bp := ""
+		for _, i := range order {
+			bp += `
+				java_library {
+					name: "` + modules[i] + `",
+					srcs: ["a.java"],
+				}
+			`
+		}
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			android.FixtureMergeEnv(map[string]string{"XREF_CORPUS": "android"}),
+		).RunTestWithBp(t, bp)
+
+		singleton := result.SingletonForTests("kythe_java_extract").Singleton().(*kytheExtractJavaSingleton)
+		var xrefTargets []string
+		for _, path := range android.PathsRelativeToTop(singleton.xrefTargets) {
+			if strings.HasPrefix(path, "out/soong/.intermediates/a/") ||
+				strings.HasPrefix(path, "out/soong/.intermediates/b/") ||
+				strings.HasPrefix(path, "out/soong/.intermediates/c/") {
+				xrefTargets = append(xrefTargets, path)
+			}
+		}
+		android.AssertDeepEquals(t, fmt.Sprintf("xref_java inputs for order %v", order), []string{
+			"out/soong/.intermediates/a/android_common/a.kzip",
+			"out/soong/.intermediates/b/android_common/b.kzip",
+			"out/soong/.intermediates/c/android_common/c.kzip",
+		}, xrefTargets)
+	}
+}
+
+func TestExportResourcesAs(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"res/a.txt": nil,
+		}),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			java_resources: ["res/a.txt"],
+			export_resources_as: "foo-res",
+		}
+
+		genrule {
+			name: "gen",
+			srcs: [":foo{.resources}"],
+			out: ["out.jar"],
+			cmd: "cp $(in) $(out)",
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	exported := foo.Output("exported_resources/foo-res.jar")
+	android.AssertPathRelativeToTopEquals(t, "exported resources input",
+		"out/soong/.intermediates/foo/android_common/res/foo.jar", exported.Input)
+
+	resourceJar := foo.Output("res/foo.jar")
+	android.AssertPathsRelativeToTopEquals(t, "resource jar inputs", []string{"res/a.txt"}, resourceJar.Implicits)
+	android.AssertStringDoesNotContain(t, "resource jar args", resourceJar.Args["jarArgs"], ".class")
+
+	withRes := foo.Output("withres/foo.jar")
+	android.AssertPathsRelativeToTopEquals(t, "implementation and resources jar inputs", []string{
+		"out/soong/.intermediates/foo/android_common/res/foo.jar",
+		"out/soong/.intermediates/foo/android_common/javac/foo.jar",
+	}, withRes.Inputs)
+
+	gen := result.ModuleForTests("gen", "").Rule("generator")
+	android.AssertStringListContains(t, "genrule inputs", android.PathsRelativeToTop(gen.Implicits),
+		"out/soong/.intermediates/foo/android_common/exported_resources/foo-res.jar")
+
+	PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`export_resources_as: module has no java resources to export`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			export_resources_as: "foo-res",
+		}
+	`)
+}
+
+func TestVersionStamp(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.BuildFingerprintFile = proptools.StringPtr("build_fingerprint.txt")
+		}),
+		android.FixtureMergeMockFs(android.MockFS{
+			"version.txt.in": nil,
+		}),
+	).RunTestWithBp(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			version_stamp: {
+				template: "version.txt.in",
+				output: "com/android/foo/version.txt",
+			},
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common")
+	versionStamp := foo.Rule("versionStamp")
+	android.AssertStringEquals(t, "build fingerprint",
+		"$$(cat out/target/product/test_device/build_fingerprint.txt)",
+		android.StringRelativeToTop(result.Config, versionStamp.Args["buildFingerprint"]))
+	android.AssertStringEquals(t, "build number",
+		"$$(cat out/soong/build_number.txt)",
+		android.StringRelativeToTop(result.Config, versionStamp.Args["buildNumber"]))
+	android.AssertPathRelativeToTopEquals(t, "version stamp output",
+		"out/soong/.intermediates/foo/"+buildOS+"_common/gen/version_stamp/com/android/foo/version.txt", versionStamp.Output)
+
+	resourceJar := foo.Output("res/foo.jar")
+	android.AssertStringListContains(t, "resource jar inputs", android.PathsRelativeToTop(resourceJar.Implicits),
+		"out/soong/.intermediates/foo/"+buildOS+"_common/gen/version_stamp/com/android/foo/version.txt")
+}
+
+func TestEmbedProvenance(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["b.java", "a.java"],
+			embed_provenance: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	foo := result.ModuleForTests("foo", "android_common")
+	rule := foo.Rule("provenance")
+	android.AssertStringEquals(t, "module", "foo", rule.Args["module"])
+	android.AssertPathsRelativeToTopEquals(t, "provenance inputs", []string{"a.java", "b.java"}, rule.Inputs)
+	android.AssertPathRelativeToTopEquals(t, "provenance output",
+		"out/soong/.intermediates/foo/android_common/gen/provenance/META-INF/provenance.json", rule.Output)
+
+	javac := foo.Rule("javac")
+	android.AssertStringEquals(t, "java version",
+		"-source "+rule.Args["javaVersion"]+" -target "+rule.Args["javaVersion"], javac.Args["javaVersionFlags"])
+	android.AssertStringEquals(t, "javac flags", javac.Args["javacFlags"], rule.Args["javacFlags"])
+
+	resourceJar := foo.Output("res/foo.jar")
+	android.AssertStringListContains(t, "resource jar inputs", android.PathsRelativeToTop(resourceJar.Implicits),
+		"out/soong/.intermediates/foo/android_common/gen/provenance/META-INF/provenance.json")
+	android.AssertStringDoesContain(t, "resource jar args", resourceJar.Args["jarArgs"], "META-INF/provenance.json")
+
+	if result.ModuleForTests("bar", "android_common").MaybeRule("provenance").Rule != nil {
+		t.Errorf("expected no provenance rule for bar")
+	}
+}
+
+func TestJavaImportExcludeStaticLibs(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			exclude_static_libs: ["bar"],
+		}
+	`)
+
+	bar := result.ModuleForTests("bar", "android_common")
+	barJavaInfo, _ := android.SingletonModuleProvider(result, bar.Module(), JavaInfoProvider)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	strip := foo.Rule("stripJarClasses")
+	android.AssertPathRelativeToTopEquals(t, "strip input",
+		"out/soong/.intermediates/foo/android_common/combined/foo.jar", strip.Input)
+	android.AssertPathsRelativeToTopEquals(t, "strip jars",
+		android.PathsRelativeToTop(barJavaInfo.ImplementationJars), strip.Implicits)
+	android.AssertStringDoesContain(t, "strip command", strip.RuleParams.Command, "xargs -0 rm -f")
+
+	fooJavaInfo, _ := android.SingletonModuleProvider(result, foo.Module(), JavaInfoProvider)
+	android.AssertPathsRelativeToTopEquals(t, "implementation jars",
+		[]string{"out/soong/.intermediates/foo/android_common/exclude-static-libs/foo.jar"},
+		fooJavaInfo.ImplementationJars)
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`exclude_static_libs: "bar" is not a java module`,
+	)).RunTestWithBp(t, `
+		filegroup {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			exclude_static_libs: ["bar"],
+		}
+	`)
+}
+
+func TestJavaImportJarjarRules(t *testing.T) {
+	bp := `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			jarjar_rules: "jarjar-rules.txt",
+			jetifier: true,
+			jarjar_before_jetifier: %t,
+		}
+	`
+
+	testCases := []struct {
+		name                 string
+		jarjarBeforeJetifier bool
+		expectedJarjarInput  string
+		expectedJetifierIn   string
+		expectedOutput       string
+	}{
+		{
+			name:                "jarjar after jetifier",
+			expectedJarjarInput: "out/soong/.intermediates/foo/android_common/jetifier/foo.jar",
+			expectedJetifierIn:  "out/soong/.intermediates/foo/android_common/combined/foo.jar",
+			expectedOutput:      "out/soong/.intermediates/foo/android_common/jarjar/foo.jar",
+		},
+		{
+			name:                 "jarjar before jetifier",
+			jarjarBeforeJetifier: true,
+			expectedJarjarInput:  "out/soong/.intermediates/foo/android_common/combined/foo.jar",
+			expectedJetifierIn:   "out/soong/.intermediates/foo/android_common/jarjar/foo.jar",
+			expectedOutput:       "out/soong/.intermediates/foo/android_common/jetifier/foo.jar",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				PrepareForTestWithJavaDefaultModules,
+				android.FixtureAddFile("jarjar-rules.txt", nil),
+			).RunTestWithBp(t, fmt.Sprintf(bp, tc.jarjarBeforeJetifier))
+
+			foo := result.ModuleForTests("foo", "android_common")
+			jarjar := foo.Rule("jarjar")
+			android.AssertPathRelativeToTopEquals(t, "jarjar input", tc.expectedJarjarInput, jarjar.Input)
+			android.AssertStringEquals(t, "jarjar rules", "jarjar-rules.txt", jarjar.Args["rulesFile"])
+
+			jetifier := foo.Rule("jetifier")
+			android.AssertPathRelativeToTopEquals(t, "jetifier input", tc.expectedJetifierIn, jetifier.Input)
+
+			fooJavaInfo, _ := android.SingletonModuleProvider(result, foo.Module(), JavaInfoProvider)
+			android.AssertPathsRelativeToTopEquals(t, "implementation jars",
+				[]string{tc.expectedOutput}, fooJavaInfo.ImplementationJars)
+		})
+	}
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`jarjar_before_jetifier: requires jarjar_rules to be set`,
+	)).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			jetifier: true,
+			jarjar_before_jetifier: true,
+		}
+	`)
+}
+
+func TestJavaImportStripDirEntries(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			strip_dir_entries: true,
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["bar.jar"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common").Output("combined/foo.jar")
+	android.AssertStringDoesContain(t, "foo jarArgs", foo.Args["jarArgs"], "-D")
+
+	bar := result.ModuleForTests("bar", "android_common").Output("combined/bar.jar")
+	android.AssertStringDoesNotContain(t, "bar jarArgs", bar.Args["jarArgs"], "-D")
+}
+
+func TestJavaImportMultiReleaseVersion(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			multi_release_version: 11,
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["bar.jar"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	flatten := foo.Rule("flattenMultiReleaseJar")
+	android.AssertStringEquals(t, "foo version", "11", flatten.Args["version"])
+	android.AssertPathRelativeToTopEquals(t, "foo flatten input",
+		"out/soong/.intermediates/foo/android_common/combined/foo.jar", flatten.Input)
+	android.AssertPathRelativeToTopEquals(t, "foo header jar",
+		"out/soong/.intermediates/foo/android_common/multi-release/foo.jar",
+		foo.Module().(*Import).HeaderJars()[0])
+
+	bar := result.ModuleForTests("bar", "android_common")
+	android.AssertDeepEquals(t, "bar flatten rule", nil, bar.MaybeRule("flattenMultiReleaseJar").Rule)
+	android.AssertPathRelativeToTopEquals(t, "bar header jar",
+		"out/soong/.intermediates/bar/android_common/combined/bar.jar",
+		bar.Module().(*Import).HeaderJars()[0])
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`multi_release_version: must be at least 9, got 8`,
+	)).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			multi_release_version: 8,
+		}
+	`)
+}
+
+func TestJavaImportHeaderJars(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo-impl.jar"],
+			header_jars: ["foo-api.jar"],
+			check_header_jars: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			libs: ["foo"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	android.AssertPathsRelativeToTopEquals(t, "foo header jar inputs", []string{"foo-api.jar"},
+		foo.Output("turbine-combined/foo.jar").Inputs)
+	android.AssertPathsRelativeToTopEquals(t, "foo implementation jar inputs", []string{"foo-impl.jar"},
+		foo.Output("combined/foo.jar").Inputs)
+
+	// Any dependency on the header jar runs the check against the implementation jar.
+	check := foo.Rule("headerJarClassesCheck")
+	android.AssertStringEquals(t, "check header jar", "out/soong/.intermediates/foo/android_common/turbine-combined/foo.jar",
+		check.Args["headerJar"])
+	android.AssertStringEquals(t, "check impl jar", "out/soong/.intermediates/foo/android_common/combined/foo.jar",
+		check.Args["implJar"])
+	checkedHeaders := foo.Output("checked-headers/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "checked headers validation",
+		"out/soong/.intermediates/foo/android_common/header-jar-check/foo.jar.stamp", checkedHeaders.Validation)
+
+	fooHeaderJar := "out/soong/.intermediates/foo/android_common/checked-headers/foo.jar"
+	android.AssertPathRelativeToTopEquals(t, "foo header jar", fooHeaderJar,
+		foo.Module().(*Import).HeaderJars()[0])
+
+	barClasspath := result.ModuleForTests("bar", "android_common").Rule("javac").Args["classpath"]
+	android.AssertStringDoesContain(t, "bar classpath", barClasspath, fooHeaderJar)
+	android.AssertStringDoesNotContain(t, "bar classpath", barClasspath,
+		"out/soong/.intermediates/foo/android_common/combined/foo.jar")
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`check_header_jars: requires header_jars to be set`,
+	)).RunTestWithBp(t, `
+		java_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			check_header_jars: true,
+		}
+	`)
+}
+
+func TestJavaImportCompileDexSdkVersion(t *testing.T) {
+	testJava(t, `
+		java_import {
+			name: "foo",
+			jars: ["a.jar"],
+			compile_dex: true,
+			sdk_version: "module_current",
+		}
+	`)
+
+	testJavaError(t, `sdk_version: "foo_current" cannot be used with compile_dex`, `
+		java_import {
+			name: "foo",
+			jars: ["a.jar"],
+			compile_dex: true,
+			sdk_version: "foo_current",
+		}
+	`)
+}
+
+func TestStemByPartition(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_defaults {
+			name: "stem_defaults",
+			srcs: ["a.java"],
+			installable: true,
+			stem_by_partition: [
+				{
+					partition: "system_ext",
+					stem: "foo-ext",
+				},
+			],
+		}
+
+		java_library {
+			name: "foo",
+			defaults: ["stem_defaults"],
+		}
+
+		java_library {
+			name: "foo_system_ext",
+			defaults: ["stem_defaults"],
+			stem: "foo",
+			system_ext_specific: true,
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common").Module().(*Library)
+	android.AssertStringEquals(t, "system install name", "foo.jar", foo.installFile.Base())
+
+	fooSystemExt := result.ModuleForTests("foo_system_ext", "android_common").Module().(*Library)
+	android.AssertStringEquals(t, "system_ext install name", "foo-ext.jar", fooSystemExt.installFile.Base())
+
+	entries := android.AndroidMkEntriesForTest(t, result.TestContext, fooSystemExt)[0]
+	android.AssertStringEquals(t, "system_ext LOCAL_MODULE_STEM", "foo-ext", entries.EntryMap["LOCAL_MODULE_STEM"][0])
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`stem_by_partition: unknown partition "data"`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			stem_by_partition: [
+				{
+					partition: "data",
+					stem: "foo-data",
+				},
+			],
+		}
+	`)
+}
+
+var compilerFlagsTestCases = []struct {
+	in  string
+	out bool
+}{
+	{
+		in:  "a",
+		out: false,
+	},
+	{
+		in:  "-a",
+		out: true,
+	},
+	{
+		in:  "-no-jdk",
+		out: false,
+	},
+	{
+		in:  "-no-stdlib",
+		out: false,
+	},
+	{
+		in:  "-kotlin-home",
+		out: false,
+	},
+	{
+		in:  "-kotlin-home /some/path",
+		out: false,
+	},
+	{
+		in:  "-include-runtime",
+		out: false,
+	},
+	{
+		in:  "-Xintellij-plugin-root",
+		out: false,
+	},
+}
+
+type mockContext struct {
+	android.ModuleContext
+	result bool
+}
+
+func (ctx *mockContext) PropertyErrorf(property, format string, args ...interface{}) {
+	// CheckBadCompilerFlags calls this function when the flag should be rejected
+	ctx.result = false
+}
+
+func TestCompilerFlags(t *testing.T) {
+	for _, testCase := range compilerFlagsTestCases {
+		ctx := &mockContext{result: true}
+		CheckKotlincFlags(ctx, []string{testCase.in})
 		if ctx.result != testCase.out {
 			t.Errorf("incorrect output:")
 			t.Errorf("     input: %#v", testCase.in)
@@ -1299,6 +2415,74 @@ func TestPatchModule(t *testing.T) {
 	})
 }
 
+func TestJavaVersion21(t *testing.T) {
+	for _, javaVersion := range []string{"21", "1.21"} {
+		t.Run(javaVersion, func(t *testing.T) {
+			result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+				java_library_host {
+					name: "foo",
+					srcs: ["a.java"],
+					java_version: "`+javaVersion+`",
+				}
+			`)
+
+			buildOS := result.Config.BuildOS.String()
+			javac := result.ModuleForTests("foo", buildOS+"_common").Rule("javac")
+			android.AssertStringEquals(t, "javac java version", "-source 21 -target 21", javac.Args["javaVersionFlags"])
+			android.AssertStringDoesContain(t, "javac command", javac.RuleParams.Command, "$javaVersionFlags")
+		})
+	}
+}
+
+func TestUseReleaseFlag(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			java_version: "17",
+			use_release_flag: true,
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["a.java"],
+			java_version: "17",
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common").Rule("javac")
+	android.AssertStringEquals(t, "foo java version", "--release 17", foo.Args["javaVersionFlags"])
+	bar := result.ModuleForTests("bar", buildOS+"_common").Rule("javac")
+	android.AssertStringEquals(t, "bar java version", "-source 17 -target 17", bar.Args["javaVersionFlags"])
+
+	PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`use_release_flag: cannot be used with a custom bootclasspath or system_modules`,
+	)).RunTestWithBp(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			java_version: "11",
+			system_modules: "trimmed-system-modules",
+			use_release_flag: true,
+		}
+
+		java_system_modules {
+			name: "trimmed-system-modules",
+			libs: ["trimmed-jar"],
+			host_supported: true,
+		}
+
+		java_library {
+			name: "trimmed-jar",
+			srcs: ["b.java"],
+			host_supported: true,
+			sdk_version: "none",
+			system_modules: "none",
+		}
+	`)
+}
+
 func TestJavaLibraryWithSystemModules(t *testing.T) {
 	ctx, _ := testJava(t, `
 		java_library {
@@ -1347,6 +2531,57 @@ func TestJavaLibraryWithSystemModules(t *testing.T) {
 	checkBootClasspathForSystemModule(t, ctx, "lib-with-prebuilt-system-modules", "/prebuilt-jar.jar")
 }
 
+func TestJavaLibraryHostSystemModules(t *testing.T) {
+	bp := `
+		java_system_modules {
+			name: "trimmed-system-modules",
+			libs: ["trimmed-jar"],
+			host_supported: true,
+		}
+
+		java_library {
+			name: "trimmed-jar",
+			srcs: ["a.java"],
+			host_supported: true,
+			sdk_version: "none",
+			system_modules: "none",
+		}
+	`
+
+	t.Run("java 11", func(t *testing.T) {
+		result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp+`
+			java_library_host {
+				name: "foo",
+				srcs: ["a.java"],
+				java_version: "11",
+				system_modules: "trimmed-system-modules",
+			}
+		`)
+
+		buildOS := result.Config.BuildOS.String()
+		javac := result.ModuleForTests("foo", buildOS+"_common").Rule("javac")
+		android.AssertStringEquals(t, "javac java version", "-source 11 -target 11", javac.Args["javaVersionFlags"])
+		android.AssertStringEquals(t, "javac system modules",
+			"--system=out/soong/.intermediates/trimmed-system-modules/"+buildOS+"_common/system",
+			javac.Args["bootClasspath"])
+	})
+
+	t.Run("java 8", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`system_modules: cannot be set when targeting java 1.8, which uses the bootclasspath`,
+		)).RunTestWithBp(t, bp+`
+			java_library_host {
+				name: "foo",
+				srcs: ["a.java"],
+				java_version: "1.8",
+				system_modules: "trimmed-system-modules",
+			}
+		`)
+	})
+}
+
 func checkBootClasspathForSystemModule(t *testing.T, ctx *android.TestContext, moduleName string, expectedSuffix string) {
 	javacRule := ctx.ModuleForTests(moduleName, "android_common").Rule("javac")
 	bootClasspath := javacRule.Args["bootClasspath"]
@@ -1379,19 +2614,87 @@ func TestAidlExportIncludeDirsFromImports(t *testing.T) {
 	}
 }
 
+func TestAidlExportIncludeZipFromImports(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["aidl/foo/IFoo.aidl"],
+			libs: ["bar"],
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["a.jar"],
+			aidl: {
+				export_include_zip: "aidl/bar.zip",
+			},
+		}
+	`)
+
+	unzip := ctx.ModuleForTests("bar", "android_common").Rule("unzipAidlIncludes")
+	android.AssertPathRelativeToTopEquals(t, "unzip input", "aidl/bar.zip", unzip.Input)
+	android.AssertStringEquals(t, "unzip outDir",
+		"out/soong/.intermediates/bar/android_common/aidl_includes", android.StringRelativeToTop(ctx.Config(), unzip.Args["outDir"]))
+
+	aidl := ctx.ModuleForTests("foo", "android_common").Rule("aidl")
+	android.AssertStringDoesContain(t, "aidl command", aidl.RuleParams.Command,
+		"-I"+unzip.Args["outDir"])
+	android.AssertPathsRelativeToTopEquals(t, "aidl implicits",
+		[]string{"out/soong/.intermediates/bar/android_common/aidl_includes.stamp"},
+		aidl.Implicits.FilterByExt(".stamp"))
+
+	testJavaError(t, `aidl.export_include_zip: cannot be set together with aidl.export_include_dirs`, `
+		java_import {
+			name: "bar",
+			jars: ["a.jar"],
+			aidl: {
+				export_include_dirs: ["aidl/bar"],
+				export_include_zip: "aidl/bar.zip",
+			},
+		}
+	`)
+}
+
 func TestAidlFlagsArePassedToTheAidlCompiler(t *testing.T) {
 	ctx, _ := testJava(t, `
 		java_library {
-			name: "foo",
-			srcs: ["aidl/foo/IFoo.aidl"],
-			aidl: { flags: ["-Werror"], },
+			name: "foo",
+			srcs: ["aidl/foo/IFoo.aidl"],
+			aidl: { flags: ["-Werror"], },
+		}
+	`)
+
+	aidlCommand := ctx.ModuleForTests("foo", "android_common").Rule("aidl").RuleParams.Command
+	expectedAidlFlag := "-Werror"
+	if !strings.Contains(aidlCommand, expectedAidlFlag) {
+		t.Errorf("aidl command %q does not contain %q", aidlCommand, expectedAidlFlag)
+	}
+}
+
+func TestAidlPreprocessedOutputFiles(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["aidl/foo/IFoo.aidl"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
 		}
 	`)
 
-	aidlCommand := ctx.ModuleForTests("foo", "android_common").Rule("aidl").RuleParams.Command
-	expectedAidlFlag := "-Werror"
-	if !strings.Contains(aidlCommand, expectedAidlFlag) {
-		t.Errorf("aidl command %q does not contain %q", aidlCommand, expectedAidlFlag)
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*Library)
+	outputFiles, err := foo.OutputFiles(".aidl_preprocessed")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "aidl preprocessed",
+		[]string{"out/soong/framework.aidl"}, outputFiles)
+
+	bar := ctx.ModuleForTests("bar", "android_common").Module().(*Library)
+	if _, err := bar.OutputFiles(".aidl_preprocessed"); err == nil {
+		t.Errorf("expected an error for a module without aidl sources")
 	}
 }
 
@@ -1560,6 +2863,90 @@ func TestErrorproneEnabled(t *testing.T) {
 	}
 }
 
+func TestErrorproneVariantMode(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			errorprone: {
+				enabled: true,
+				variant_mode: true,
+			},
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	// The regular javac rule doesn't run errorprone, and doesn't depend on the errorprone rule, so
+	// the jar builds even if errorprone fails.
+	javac := foo.Description("javac")
+	android.AssertStringDoesNotContain(t, "javac flags", javac.Args["javacFlags"], "-Xplugin:ErrorProne")
+
+	errorprone := foo.Description("errorprone")
+	android.AssertStringDoesContain(t, "errorprone flags", errorprone.Args["javacFlags"], "-Xplugin:ErrorProne")
+	errorproneJar := errorprone.Output.String()
+	for _, p := range append(append(javac.Implicits, javac.OrderOnly...), javac.Validations...) {
+		if p.String() == errorproneJar {
+			t.Errorf("expected javac not to depend on %q", errorproneJar)
+		}
+	}
+}
+
+func TestErrorproneSeverities(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_defaults {
+			name: "foo_defaults",
+			errorprone: {
+				severities: [
+					{
+						check: "MissingOverride",
+						severity: "WARN",
+					},
+				],
+			},
+		}
+
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			defaults: ["foo_defaults"],
+			errorprone: {
+				enabled: true,
+				severities: [
+					{
+						check: "UnusedVariable",
+						severity: "OFF",
+					},
+					{
+						check: "MissingOverride",
+						severity: "ERROR",
+					},
+				],
+			},
+		}
+	`)
+
+	javac := ctx.ModuleForTests("foo", "android_common").Description("javac")
+	android.AssertStringDoesContain(t, "errorprone severity flags", javac.Args["javacFlags"],
+		"-Xep:MissingOverride:ERROR -Xep:UnusedVariable:OFF")
+
+	testJavaError(t, `errorprone.severities: invalid severity "FATAL" for check "MissingOverride"`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			errorprone: {
+				enabled: true,
+				severities: [
+					{
+						check: "MissingOverride",
+						severity: "FATAL",
+					},
+				],
+			},
+		}
+	`)
+}
+
 func TestErrorproneDisabled(t *testing.T) {
 	bp := `
 		java_library {
@@ -1736,86 +3123,610 @@ func TestDataDeviceBinsBuildsDeviceBinary(t *testing.T) {
 			depCompileMultilib: "64",
 			expectedError:      `Android.bp:2:3: dependency "bar" of "foo" missing variant`,
 		},
+		{
+			dataDeviceBinType:  "riscv64",
+			depCompileMultilib: "64",
+			expectedError:      `data_device_bins_riscv64: cannot find riscv64 device target`,
+		},
+	}
+
+	bpTemplate := `
+		java_test_host {
+			name: "foo",
+			srcs: ["test.java"],
+			data_device_bins_%s: ["bar"],
+		}
+
+		cc_binary {
+			name: "bar",
+			compile_multilib: "%s",
+		}
+	`
+
+	for _, tc := range testCases {
+		bp := fmt.Sprintf(bpTemplate, tc.dataDeviceBinType, tc.depCompileMultilib)
+
+		errorHandler := android.FixtureExpectsNoErrors
+		if tc.expectedError != "" {
+			errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+		}
+
+		testName := fmt.Sprintf(`data_device_bins_%s with compile_multilib:"%s"`, tc.dataDeviceBinType, tc.depCompileMultilib)
+		t.Run(testName, func(t *testing.T) {
+			ctx := android.GroupFixturePreparers(PrepareForIntegrationTestWithJava).
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+			if tc.expectedError != "" {
+				return
+			}
+
+			buildOS := ctx.Config.BuildOS.String()
+			fooVariant := ctx.ModuleForTests("foo", buildOS+"_common")
+			fooMod := fooVariant.Module().(*TestHost)
+			entries := android.AndroidMkEntriesForTest(t, ctx.TestContext, fooMod)[0]
+
+			expectedAutogenConfig := `<option name="push-file" key="bar" value="/data/local/tests/unrestricted/foo/bar" />`
+			autogen := fooVariant.Rule("autogen")
+			if !strings.Contains(autogen.Args["extraConfigs"], expectedAutogenConfig) {
+				t.Errorf("foo extraConfigs %v does not contain %q", autogen.Args["extraConfigs"], expectedAutogenConfig)
+			}
+
+			expectedData := []string{}
+			for _, variant := range tc.variants {
+				barVariant := ctx.ModuleForTests("bar", variant)
+				relocated := barVariant.Output("bar")
+				expectedInput := fmt.Sprintf("out/soong/.intermediates/bar/%s/unstripped/bar", variant)
+				android.AssertPathRelativeToTopEquals(t, "relocation input", expectedInput, relocated.Input)
+
+				expectedData = append(expectedData, fmt.Sprintf("out/soong/.intermediates/bar/%s/bar:bar", variant))
+			}
+
+			actualData := entries.EntryMap["LOCAL_COMPATIBILITY_SUPPORT_FILES"]
+			android.AssertStringPathsRelativeToTopEquals(t, "LOCAL_TEST_DATA", ctx.Config, expectedData, actualData)
+		})
+	}
+}
+
+func TestDataDeviceBinsRiscv64(t *testing.T) {
+	ctx := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithJava,
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Android] = []android.Target{
+				{Os: android.Android, Arch: android.Arch{ArchType: android.Riscv64}},
+			}
+		}),
+	).RunTestWithBp(t, `
+		java_test_host {
+			name: "foo",
+			srcs: ["test.java"],
+			data_device_bins_riscv64: ["bar"],
+		}
+
+		cc_binary {
+			name: "bar",
+			compile_multilib: "64",
+		}
+	`)
+
+	fooVariant := ctx.ModuleForTests("foo", ctx.Config.BuildOS.String()+"_common")
+	entries := android.AndroidMkEntriesForTest(t, ctx.TestContext, fooVariant.Module())[0]
+
+	relocated := ctx.ModuleForTests("bar", "android_riscv64").Output("bar")
+	android.AssertPathRelativeToTopEquals(t, "relocation input",
+		"out/soong/.intermediates/bar/android_riscv64/unstripped/bar", relocated.Input)
+	android.AssertStringPathsRelativeToTopEquals(t, "LOCAL_COMPATIBILITY_SUPPORT_FILES", ctx.Config,
+		[]string{"out/soong/.intermediates/bar/android_riscv64/bar:bar"},
+		entries.EntryMap["LOCAL_COMPATIBILITY_SUPPORT_FILES"])
+}
+
+func TestDataDeviceDir(t *testing.T) {
+	bp := `
+		java_test_host {
+			name: "foo",
+			srcs: ["test.java"],
+			data_device_bins_first: ["bar"],
+			data_device_dir: %q,
+		}
+
+		cc_binary {
+			name: "bar",
+		}
+	`
+
+	ctx := android.GroupFixturePreparers(PrepareForIntegrationTestWithJava).
+		RunTestWithBp(t, fmt.Sprintf(bp, "/data/local/tmp"))
+	buildOS := ctx.Config.BuildOS.String()
+	autogen := ctx.ModuleForTests("foo", buildOS+"_common").Rule("autogen")
+	expectedAutogenConfig := `<option name="push-file" key="bar" value="/data/local/tmp/bar" />`
+	android.AssertStringDoesContain(t, "foo extraConfigs", autogen.Args["extraConfigs"], expectedAutogenConfig)
+
+	for _, dir := range []string{"data/local/tmp", "/system/bin", "/data"} {
+		t.Run(dir, func(t *testing.T) {
+			android.GroupFixturePreparers(PrepareForIntegrationTestWithJava).
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					`data_device_dir: must be an absolute path under /data`,
+				)).RunTestWithBp(t, fmt.Sprintf(bp, dir))
+		})
+	}
+}
+
+func TestDeviceBinaryWrapperGeneration(t *testing.T) {
+	// Scenario 1: java_binary has main_class property in its bp
+	ctx, _ := testJava(t, `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+			main_class: "foo.bar.jb",
+		}
+	`)
+	wrapperPath := fmt.Sprint(ctx.ModuleForTests("foo", "android_arm64_armv8-a").AllOutputs())
+	if !strings.Contains(wrapperPath, "foo.sh") {
+		t.Errorf("wrapper file foo.sh is not generated")
+	}
+
+	// Scenario 2: java_binary has neither wrapper nor main_class, its build
+	// is expected to be failed.
+	testJavaError(t, "main_class property is required for device binary if no default wrapper is assigned", `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+		}`)
+}
+
+func TestDeviceBinaryArchMainClass(t *testing.T) {
+	bp := `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+			main_class: "foo.Main",
+			multilib: {
+				lib32: {
+					main_class: "foo.Main32",
+				},
+				lib64: {
+					main_class: "foo.Main64",
+				},
+			},
+		}
+	`
+
+	testCases := []struct {
+		name              string
+		targets           []android.Target
+		variant           string
+		expectedMainClass string
+	}{
+		{
+			name:              "lib64",
+			variant:           "android_arm64_armv8-a",
+			expectedMainClass: "foo.Main64",
+		},
+		{
+			name: "lib32",
+			targets: []android.Target{
+				{Os: android.Android, Arch: android.Arch{ArchType: android.Arm, ArchVariant: "armv7-a-neon"}},
+			},
+			variant:           "android_arm_armv7-a-neon",
+			expectedMainClass: "foo.Main32",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				prepareForJavaTest,
+				android.FixtureModifyConfig(func(config android.Config) {
+					if tc.targets != nil {
+						config.Targets[android.Android] = tc.targets
+					}
+				}),
+			).RunTestWithBp(t, bp)
+
+			wrapper := result.ModuleForTests("foo", tc.variant).Output("foo.sh")
+			android.AssertStringEquals(t, "main class", tc.expectedMainClass, wrapper.Args["main_class"])
+
+			manifest := result.ModuleForTests("foo", "android_common").Output("manifest.txt")
+			android.AssertStringEquals(t, "manifest main class", "Main-Class: foo.Main\n",
+				android.ContentFromFileRuleForTests(t, result.TestContext, manifest))
+		})
+	}
+
+	android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureAddFile("foo.sh", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`main_class: cannot be set for arm64 when wrapper is set`,
+	)).RunTestWithBp(t, `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+			main_class: "foo.Main",
+			wrapper: "foo.sh",
+			multilib: {
+				lib64: {
+					main_class: "foo.Main64",
+				},
+			},
+		}
+	`)
+}
+
+func TestBinaryAliases(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+			main_class: "foo.bar.Main",
+			host_supported: true,
+			aliases: [
+				{
+					name: "foo-tool",
+					main_class: "foo.bar.Tool",
+				},
+			],
+		}
+	`)
+
+	deviceAlias := ctx.ModuleForTests("foo", "android_arm64_armv8-a").Output("aliases/foo-tool")
+	android.AssertStringEquals(t, "device alias main class", "foo.bar.Tool", deviceAlias.Args["main_class"])
+	android.AssertStringEquals(t, "device alias jar", "foo.jar", deviceAlias.Args["jar_name"])
+
+	buildOS := ctx.Config().BuildOS.String()
+	hostAlias := ctx.ModuleForTests("foo", buildOS+"_x86_64").Output("aliases/foo-tool")
+	android.AssertStringEquals(t, "host alias main class", "foo.bar.Tool", hostAlias.Args["main_class"])
+
+	testJavaError(t, `aliases: alias "foo" collides with the module name`, `
+		java_binary {
+			name: "foo",
+			srcs: ["foo.java"],
+			main_class: "foo.bar.Main",
+			aliases: [
+				{
+					name: "foo",
+					main_class: "foo.bar.Tool",
+				},
+			],
+		}`)
+}
+
+func TestBinaryStripDebugInfo(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			strip_debug_info: true,
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+	foo := ctx.ModuleForTests("foo", buildOS+"_common")
+	combinedJar := foo.Module().(*Binary).outputFile
+
+	strip := foo.Rule("stripClassDebugInfo")
+	android.AssertPathRelativeToTopEquals(t, "strip input", android.PathRelativeToTop(combinedJar), strip.Input)
+
+	install := foo.Output("foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "install input", android.PathRelativeToTop(strip.Output), install.Input)
+
+	testJavaError(t, `strip_debug_info: only supported for host binaries`, `
+		java_binary {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			strip_debug_info: true,
+		}
+	`)
+}
+
+func TestBinaryCreateFatJar(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			libs: ["bar"],
+			static_libs: ["baz"],
+			create_fat_jar: true,
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["b.java"],
+			libs: ["qux"],
+		}
+
+		java_library_host {
+			name: "baz",
+			srcs: ["c.java"],
+			libs: ["quux"],
+		}
+
+		java_library_host {
+			name: "qux",
+			srcs: ["d.java"],
+		}
+
+		java_library_host {
+			name: "quux",
+			srcs: ["e.java"],
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+	foo := ctx.ModuleForTests("foo", buildOS+"_common")
+	jarOf := func(name string) string {
+		m := ctx.ModuleForTests(name, buildOS+"_common").Module().(*Library)
+		return android.PathRelativeToTop(m.ImplementationAndResourcesJars()[0])
+	}
+	jars := []string{
+		android.PathRelativeToTop(foo.Module().(*Binary).outputFile),
+		jarOf("bar"),
+		jarOf("qux"),
+		jarOf("quux"),
 	}
 
-	bpTemplate := `
-		java_test_host {
+	// The service files of all the jars are merged, and the merged copies are placed first in the
+	// fat jar so that they replace the copies in the jars.
+	services := foo.Rule("mergeServiceFiles")
+	android.AssertPathsRelativeToTopEquals(t, "service files inputs", jars, services.Inputs)
+
+	fatJar := foo.Output("fat/foo.jar")
+	android.AssertPathsRelativeToTopEquals(t, "fat jar inputs",
+		append([]string{"out/soong/.intermediates/foo/" + buildOS + "_common/fat/services.jar"}, jars...),
+		fatJar.Inputs)
+	android.AssertStringDoesContain(t, "fat jar manifest", fatJar.Args["jarArgs"],
+		"out/soong/.intermediates/foo/"+buildOS+"_common/manifest.txt")
+
+	install := foo.Output("foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "install input", android.PathRelativeToTop(fatJar.Output), install.Input)
+
+	// The wrapper runs the fat jar instead of the default wrapper looking for the jar.
+	fooWrapper := ctx.ModuleForTests("foo", buildOS+"_x86_64")
+	wrapper := fooWrapper.Rule("hostBinaryFatJarWrapper")
+	android.AssertStringEquals(t, "wrapper jar name", "foo.jar", wrapper.Args["jar_name"])
+	android.AssertPathRelativeToTopEquals(t, "installed wrapper", android.PathRelativeToTop(wrapper.Output),
+		fooWrapper.Output("foo").Input)
+
+	testJavaError(t, `create_fat_jar: requires main_class`, `
+		java_binary_host {
 			name: "foo",
-			srcs: ["test.java"],
-			data_device_bins_%s: ["bar"],
+			srcs: ["a.java"],
+			create_fat_jar: true,
 		}
+	`)
+}
 
-		cc_binary {
+func TestBinaryCreateFatJarStripDebugInfo(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			libs: ["bar"],
+			create_fat_jar: true,
+			strip_debug_info: true,
+		}
+
+		java_library_host {
 			name: "bar",
-			compile_multilib: "%s",
+			srcs: ["b.java"],
 		}
-	`
+	`)
 
-	for _, tc := range testCases {
-		bp := fmt.Sprintf(bpTemplate, tc.dataDeviceBinType, tc.depCompileMultilib)
+	buildOS := ctx.Config().BuildOS.String()
+	foo := ctx.ModuleForTests("foo", buildOS+"_common")
 
-		errorHandler := android.FixtureExpectsNoErrors
-		if tc.expectedError != "" {
-			errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+	// The debug info is stripped from the fat jar, so the installed jar still contains bar.
+	fatJar := foo.Output("fat/foo.jar")
+	strip := foo.Rule("stripClassDebugInfo")
+	android.AssertPathRelativeToTopEquals(t, "strip input", android.PathRelativeToTop(fatJar.Output), strip.Input)
+
+	install := foo.Output("foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "install input", android.PathRelativeToTop(strip.Output), install.Input)
+}
+
+func TestBinaryShadeRelocations(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			static_libs: ["bar"],
+			libs: ["baz"],
+			create_fat_jar: true,
+			shade: {
+				relocations: [
+					{
+						from: "com.bar",
+						to: "foo.shaded.com.bar",
+					},
+				],
+			},
 		}
 
-		testName := fmt.Sprintf(`data_device_bins_%s with compile_multilib:"%s"`, tc.dataDeviceBinType, tc.depCompileMultilib)
-		t.Run(testName, func(t *testing.T) {
-			ctx := android.GroupFixturePreparers(PrepareForIntegrationTestWithJava).
-				ExtendWithErrorHandler(errorHandler).
-				RunTestWithBp(t, bp)
-			if tc.expectedError != "" {
-				return
-			}
+		java_library_host {
+			name: "bar",
+			srcs: ["b.java"],
+		}
 
-			buildOS := ctx.Config.BuildOS.String()
-			fooVariant := ctx.ModuleForTests("foo", buildOS+"_common")
-			fooMod := fooVariant.Module().(*TestHost)
-			entries := android.AndroidMkEntriesForTest(t, ctx.TestContext, fooMod)[0]
+		java_library_host {
+			name: "baz",
+			srcs: ["c.java"],
+		}
+	`)
 
-			expectedAutogenConfig := `<option name="push-file" key="bar" value="/data/local/tests/unrestricted/foo/bar" />`
-			autogen := fooVariant.Rule("autogen")
-			if !strings.Contains(autogen.Args["extraConfigs"], expectedAutogenConfig) {
-				t.Errorf("foo extraConfigs %v does not contain %q", autogen.Args["extraConfigs"], expectedAutogenConfig)
-			}
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common")
 
-			expectedData := []string{}
-			for _, variant := range tc.variants {
-				barVariant := ctx.ModuleForTests("bar", variant)
-				relocated := barVariant.Output("bar")
-				expectedInput := fmt.Sprintf("out/soong/.intermediates/bar/%s/unstripped/bar", variant)
-				android.AssertPathRelativeToTopEquals(t, "relocation input", expectedInput, relocated.Input)
+	rules := android.ContentFromFileRuleForTests(t, result.TestContext, foo.Output("shade/jarjar-rules.txt"))
+	android.AssertStringEquals(t, "jarjar rules", "rule com.bar.** foo.shaded.com.bar.@1\n", rules)
 
-				expectedData = append(expectedData, fmt.Sprintf("out/soong/.intermediates/bar/%s/bar:bar", variant))
-			}
+	// The relocations are applied to the merged fat jar, not while compiling the binary.
+	android.AssertBoolEquals(t, "compile jarjar", false, foo.MaybeOutput("jarjar/foo.jar").Rule != nil)
 
-			actualData := entries.EntryMap["LOCAL_COMPATIBILITY_SUPPORT_FILES"]
-			android.AssertStringPathsRelativeToTopEquals(t, "LOCAL_TEST_DATA", ctx.Config, expectedData, actualData)
-		})
+	fatJar := foo.Output("fat/foo.jar")
+	jarjar := foo.Output("shade/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "shaded jar input", android.PathRelativeToTop(fatJar.Output), jarjar.Input)
+	android.AssertPathRelativeToTopEquals(t, "shaded jar rules",
+		"out/soong/.intermediates/foo/"+buildOS+"_common/shade/jarjar-rules.txt", jarjar.Implicit)
+
+	install := foo.Output("foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "install input", android.PathRelativeToTop(jarjar.Output), install.Input)
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`shade.relocations: requires create_fat_jar: true`,
+	)).RunTestWithBp(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			static_libs: ["bar"],
+			shade: {
+				relocations: [
+					{
+						from: "com.bar",
+						to: "foo.shaded.com.bar",
+					},
+				],
+			},
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`)
+}
+
+func TestBinaryClasspathPriority(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_binary_host {
+			name: "foo",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			static_libs: ["low", "default", "high"],
+		}
+
+		java_library_host {
+			name: "foolib",
+			srcs: ["a.java"],
+			static_libs: ["low", "default", "high"],
+		}
+
+		java_library_host {
+			name: "low",
+			srcs: ["b.java"],
+			classpath_priority: -1,
+		}
+
+		java_library_host {
+			name: "default",
+			srcs: ["b.java"],
+		}
+
+		java_library_host {
+			name: "high",
+			srcs: ["b.java"],
+			classpath_priority: 10,
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+	implementationJar := func(name string) string {
+		return result.ModuleForTests(name, buildOS+"_common").Module().(*Library).implementationJarFile.String()
 	}
+
+	// The static_libs of the binary are merged in decreasing order of priority, so the classes of
+	// high win over those of the other libraries.
+	combined := result.ModuleForTests("foo", buildOS+"_common").Output("combined/foo.jar")
+	android.AssertDeepEquals(t, "binary classpath order",
+		[]string{implementationJar("high"), implementationJar("default"), implementationJar("low")},
+		combined.Inputs.Strings()[1:])
+
+	// Libraries keep their static_libs order.
+	combined = result.ModuleForTests("foolib", buildOS+"_common").Output("combined/foolib.jar")
+	android.AssertDeepEquals(t, "library classpath order",
+		[]string{implementationJar("low"), implementationJar("default"), implementationJar("high")},
+		combined.Inputs.Strings()[1:])
 }
 
-func TestDeviceBinaryWrapperGeneration(t *testing.T) {
-	// Scenario 1: java_binary has main_class property in its bp
+func TestBinaryRuntimeJvmFlags(t *testing.T) {
 	ctx, _ := testJava(t, `
-		java_binary {
+		java_binary_host {
 			name: "foo",
-			srcs: ["foo.java"],
-			main_class: "foo.bar.jb",
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			static_libs: ["bar"],
+			aliases: [
+				{
+					name: "foo-tool",
+					main_class: "foo.Tool",
+				},
+			],
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["b.java"],
+			static_libs: ["baz"],
+			runtime_jvm_flags: ["--add-opens=java.base/java.lang=ALL-UNNAMED"],
+		}
+
+		java_library_host {
+			name: "baz",
+			srcs: ["c.java"],
+			runtime_jvm_flags: [
+				"--add-opens=java.base/java.lang=ALL-UNNAMED",
+				"--add-exports=java.base/sun.nio.ch=ALL-UNNAMED",
+			],
 		}
 	`)
-	wrapperPath := fmt.Sprint(ctx.ModuleForTests("foo", "android_arm64_armv8-a").AllOutputs())
-	if !strings.Contains(wrapperPath, "foo.sh") {
-		t.Errorf("wrapper file foo.sh is not generated")
-	}
 
-	// Scenario 2: java_binary has neither wrapper nor main_class, its build
-	// is expected to be failed.
-	testJavaError(t, "main_class property is required for device binary if no default wrapper is assigned", `
-		java_binary {
+	buildOS := ctx.Config().BuildOS.String()
+	fooCommon := ctx.ModuleForTests("foo", buildOS+"_common").Module()
+	info, _ := android.SingletonModuleProvider(ctx, fooCommon, JavaInfoProvider)
+	android.AssertArrayString(t, "transitive jvm flags", []string{
+		"--add-opens=java.base/java.lang=ALL-UNNAMED",
+		"--add-exports=java.base/sun.nio.ch=ALL-UNNAMED",
+	}, info.TransitiveJvmFlags.ToList())
+
+	expectedFlags := "--add-exports=java.base/sun.nio.ch=ALL-UNNAMED --add-opens=java.base/java.lang=ALL-UNNAMED"
+	foo := ctx.ModuleForTests("foo", buildOS+"_x86_64")
+	wrapper := foo.Rule("hostBinaryJvmFlagsWrapper")
+	android.AssertStringEquals(t, "wrapper jvm flags", expectedFlags, wrapper.Args["jvm_flags"])
+	android.AssertStringDoesContain(t, "wrapper command", wrapper.RuleParams.Command,
+		`if ! grep -q '^exec java ' `)
+
+	install := foo.Output("foo")
+	android.AssertPathRelativeToTopEquals(t, "installed wrapper", android.PathRelativeToTop(wrapper.Output), install.Input)
+
+	alias := foo.Output("aliases/foo-tool")
+	android.AssertStringEquals(t, "alias jvm flags", expectedFlags, alias.Args["jvm_flags"])
+}
+
+func TestBinaryRuntimeJvmFlagsEscaping(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_binary_host {
 			name: "foo",
-			srcs: ["foo.java"],
-		}`)
+			srcs: ["a.java"],
+			main_class: "foo.Main",
+			static_libs: ["bar"],
+		}
+
+		java_library_host {
+			name: "bar",
+			srcs: ["b.java"],
+			runtime_jvm_flags: ["-Dbar.separator=a|b&c", "-Dbar.quoted='d'"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", ctx.Config().BuildOS.String()+"_x86_64")
+	wrapper := foo.Rule("hostBinaryJvmFlagsWrapper")
+	android.AssertStringEquals(t, "wrapper jvm flags", `-Dbar.quoted='\''d'\'' -Dbar.separator=a\|b\&c`,
+		wrapper.Args["jvm_flags"])
 }
 
 func TestJavaApiContributionEmptyApiFile(t *testing.T) {
@@ -2253,29 +4164,147 @@ func TestJavaApiLibraryStaticLibsLink(t *testing.T) {
 				t.Errorf("merge_zips command does not contain expected jar %s", jarName)
 			}
 		}
-	}
+	}
+}
+
+func TestJavaApiLibraryMergeStaticLibsBeforeMetalava(t *testing.T) {
+	ctx := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(
+			map[string][]byte{
+				"a/Android.bp": []byte(`
+					java_api_contribution {
+						name: "foo1",
+						api_file: "current.txt",
+						api_surface: "public",
+					}
+				`),
+				"c/Android.bp": []byte(`
+					java_library {
+						name: "lib1",
+						srcs: ["Lib.java"],
+					}
+				`),
+				"c/Lib.java": {},
+			},
+		),
+		android.FixtureMergeEnv(
+			map[string]string{
+				"DISABLE_STUB_VALIDATION": "true",
+			},
+		),
+	).RunTestWithBp(t, `
+		java_api_library {
+			name: "bar1",
+			api_surface: "public",
+			api_contributions: ["foo1"],
+			static_libs: ["lib1"],
+			stubs_type: "everything",
+			merge_static_libs_before_metalava: true,
+		}
+
+		java_api_library {
+			name: "bar2",
+			api_surface: "public",
+			api_contributions: ["foo1"],
+			static_libs: ["lib1"],
+			stubs_type: "everything",
+		}
+	`)
+
+	lib1 := ctx.ModuleForTests("lib1", "android_common").Output("turbine-combined/lib1.jar").Output.String()
+
+	// The api file of bar1 can reference the classes of lib1, which metalava and javac find on the
+	// classpath.
+	bar1 := ctx.ModuleForTests("bar1", "android_common")
+	manifest := android.RuleBuilderSboxProtoForTests(t, ctx.TestContext, bar1.Output("metalava.sbox.textproto"))
+	android.AssertStringDoesContain(t, "bar1 metalava command", *manifest.Commands[0].Command,
+		"--api-class-resolution api:classpath")
+	android.AssertStringDoesContain(t, "bar1 metalava command", *manifest.Commands[0].Command, "lib1.jar")
+	android.AssertStringDoesContain(t, "bar1 javac classpath", bar1.Rule("javac").Args["classpath"], lib1)
+	android.AssertStringDoesContain(t, "bar1 merge_zips command", bar1.Rule("merge_zips").RuleParams.Command, lib1)
+
+	bar2 := ctx.ModuleForTests("bar2", "android_common")
+	manifest = android.RuleBuilderSboxProtoForTests(t, ctx.TestContext, bar2.Output("metalava.sbox.textproto"))
+	android.AssertStringDoesNotContain(t, "bar2 metalava command", *manifest.Commands[0].Command, "lib1.jar")
+	android.AssertStringDoesNotContain(t, "bar2 javac classpath", bar2.Rule("javac").Args["classpath"], lib1)
+	android.AssertStringDoesContain(t, "bar2 merge_zips command", bar2.Rule("merge_zips").RuleParams.Command, lib1)
+}
+
+func TestJavaApiLibraryFullApiSurfaceStub(t *testing.T) {
+	provider_bp_a := `
+	java_api_contribution {
+		name: "foo1",
+		api_file: "current.txt",
+		api_surface: "public",
+	}
+	`
+	provider_bp_b := `
+	java_api_contribution {
+		name: "foo2",
+		api_file: "current.txt",
+		api_surface: "public",
+	}
+	`
+	lib_bp_a := `
+	java_api_library {
+		name: "lib1",
+		api_surface: "public",
+		api_contributions: ["foo1", "foo2"],
+		stubs_type: "everything",
+	}
+	`
+
+	ctx := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(
+			map[string][]byte{
+				"a/Android.bp": []byte(provider_bp_a),
+				"b/Android.bp": []byte(provider_bp_b),
+				"c/Android.bp": []byte(lib_bp_a),
+			},
+		),
+		android.FixtureMergeEnv(
+			map[string]string{
+				"DISABLE_STUB_VALIDATION": "true",
+			},
+		),
+	).RunTestWithBp(t, `
+		java_api_library {
+			name: "bar1",
+			api_surface: "public",
+			api_contributions: ["foo1"],
+			full_api_surface_stubs: ["lib1"],
+			stubs_type: "everything",
+		}
+	`)
+
+	m := ctx.ModuleForTests("bar1", "android_common")
+	manifest := m.Output("metalava.sbox.textproto")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, ctx.TestContext, manifest)
+	manifestCommand := sboxProto.Commands[0].GetCommand()
+	android.AssertStringDoesContain(t, "Command expected to contain full_api_surface_stubs output jar", manifestCommand, "lib1.jar")
 }
 
-func TestJavaApiLibraryFullApiSurfaceStub(t *testing.T) {
-	provider_bp_a := `
+func TestJavaApiLibraryMultipleFullApiSurfaceStubs(t *testing.T) {
+	provider_bp := `
 	java_api_contribution {
 		name: "foo1",
 		api_file: "current.txt",
 		api_surface: "public",
 	}
 	`
-	provider_bp_b := `
-	java_api_contribution {
-		name: "foo2",
-		api_file: "current.txt",
+	lib_bp := `
+	java_api_library {
+		name: "lib1",
 		api_surface: "public",
+		api_contributions: ["foo1"],
+		stubs_type: "everything",
 	}
-	`
-	lib_bp_a := `
 	java_api_library {
-		name: "lib1",
+		name: "lib2",
 		api_surface: "public",
-		api_contributions: ["foo1", "foo2"],
+		api_contributions: ["foo1"],
 		stubs_type: "everything",
 	}
 	`
@@ -2284,9 +4313,8 @@ func TestJavaApiLibraryFullApiSurfaceStub(t *testing.T) {
 		prepareForJavaTest,
 		android.FixtureMergeMockFs(
 			map[string][]byte{
-				"a/Android.bp": []byte(provider_bp_a),
-				"b/Android.bp": []byte(provider_bp_b),
-				"c/Android.bp": []byte(lib_bp_a),
+				"a/Android.bp": []byte(provider_bp),
+				"c/Android.bp": []byte(lib_bp),
 			},
 		),
 		android.FixtureMergeEnv(
@@ -2299,7 +4327,8 @@ func TestJavaApiLibraryFullApiSurfaceStub(t *testing.T) {
 			name: "bar1",
 			api_surface: "public",
 			api_contributions: ["foo1"],
-			full_api_surface_stub: "lib1",
+			full_api_surface_stubs: ["lib1", "lib2"],
+			error_on_full_api_surface_stubs_conflict: true,
 			stubs_type: "everything",
 		}
 	`)
@@ -2308,7 +4337,12 @@ func TestJavaApiLibraryFullApiSurfaceStub(t *testing.T) {
 	manifest := m.Output("metalava.sbox.textproto")
 	sboxProto := android.RuleBuilderSboxProtoForTests(t, ctx.TestContext, manifest)
 	manifestCommand := sboxProto.Commands[0].GetCommand()
-	android.AssertStringDoesContain(t, "Command expected to contain full_api_surface_stub output jar", manifestCommand, "lib1.jar")
+	android.AssertStringDoesContain(t, "Command expected to extract lib1 classes", manifestCommand,
+		"unzip -q -o __SBOX_SANDBOX_DIR__/out/soong/.intermediates/c/lib1/android_common/lib1/lib1.jar")
+	android.AssertStringDoesContain(t, "Command expected to extract lib2 classes", manifestCommand,
+		"unzip -q -o __SBOX_SANDBOX_DIR__/out/soong/.intermediates/c/lib2/android_common/lib2/lib2.jar")
+	android.AssertStringDoesContain(t, "Command expected to check for conflicting classes", manifestCommand,
+		"classes provided by more than one of full_api_surface_stubs")
 }
 
 func TestTransitiveSrcFiles(t *testing.T) {
@@ -2334,6 +4368,39 @@ func TestTransitiveSrcFiles(t *testing.T) {
 	android.AssertArrayString(t, "unexpected jar deps", []string{"b.java", "c.java"}, transitiveSrcFiles.Strings())
 }
 
+func TestTransitiveResourceJars(t *testing.T) {
+	ctx, _ := testJavaWithFS(t, `
+		java_library {
+			name: "a",
+			srcs: ["a.java"],
+			java_resources: ["a.txt"],
+			static_libs: ["b"],
+		}
+		java_library {
+			name: "b",
+			srcs: ["b.java"],
+			java_resources: ["b.txt"],
+			static_libs: ["c"],
+		}
+		java_library {
+			name: "c",
+			srcs: ["c.java"],
+			java_resources: ["c.txt"],
+		}
+	`, map[string][]byte{
+		"a.txt": nil,
+		"b.txt": nil,
+		"c.txt": nil,
+	})
+	a := ctx.ModuleForTests("a", "android_common").Module()
+	javaInfo, _ := android.SingletonModuleProvider(ctx, a, JavaInfoProvider)
+	android.AssertPathsRelativeToTopEquals(t, "transitive resource jars", []string{
+		"out/soong/.intermediates/c/android_common/res/c.jar",
+		"out/soong/.intermediates/b/android_common/res/b.jar",
+		"out/soong/.intermediates/a/android_common/res/a.jar",
+	}, javaInfo.TransitiveResourceJars.ToList())
+}
+
 func TestTradefedOptions(t *testing.T) {
 	result := PrepareForTestWithJavaBuildComponents.RunTestWithBp(t, `
 java_test_host {
@@ -2347,15 +4414,465 @@ java_test_host {
 		]
 	}
 }
-`)
+`)
+
+	buildOS := result.Config.BuildOS.String()
+	args := result.ModuleForTests("foo", buildOS+"_common").
+		Output("out/soong/.intermediates/foo/" + buildOS + "_common/foo.config").Args
+	expected := proptools.NinjaAndShellEscape("<option name=\"exclude-path\" value=\"org/apache\" />")
+	if args["extraConfigs"] != expected {
+		t.Errorf("Expected args[\"extraConfigs\"] to equal %q, was %q", expected, args["extraConfigs"])
+	}
+}
+
+func TestTestConfigMerge(t *testing.T) {
+	fixture := android.GroupFixturePreparers(
+		PrepareForTestWithJavaBuildComponents,
+		android.FixtureMergeMockFs(android.MockFS{
+			"AndroidTest.xml":    nil,
+			"extra_preparer.xml": nil,
+		}),
+	)
+	result := fixture.RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_config_merge: "extra_preparer.xml",
+	test_options: {
+		unit_test: false,
+	},
+}
+`)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common")
+	merge := foo.Rule("mergeTestConfig")
+	android.AssertPathRelativeToTopEquals(t, "merge output",
+		"out/soong/.intermediates/foo/"+buildOS+"_common/foo.config", merge.Output)
+	android.AssertStringEquals(t, "merge file", "extra_preparer.xml", merge.Args["merge"])
+	android.AssertPathRelativeToTopEquals(t, "merge input",
+		"out/soong/.intermediates/foo/"+buildOS+"_common/autogen/foo.config", merge.Input)
+
+	autogen := foo.Output("out/soong/.intermediates/foo/" + buildOS + "_common/autogen/foo.config")
+	android.AssertStringEquals(t, "autogen template",
+		"build/make/core/java_host_test_config_template.xml", autogen.Args["template"])
+
+	fixture.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`test_config_merge: can only be used with an autogenerated test config`,
+	)).RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_config: "AndroidTest.xml",
+	test_config_merge: "extra_preparer.xml",
+	auto_gen_config: false,
+}
+`)
+}
+
+func TestTestShards(t *testing.T) {
+	result := PrepareForTestWithJavaBuildComponents.RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_options: {
+		unit_test: false,
+		shards: 4,
+	}
+}
+`)
+
+	buildOS := result.Config.BuildOS.String()
+	args := result.ModuleForTests("foo", buildOS+"_common").
+		Output("out/soong/.intermediates/foo/" + buildOS + "_common/foo.config").Args
+	expected := proptools.NinjaAndShellEscape("<option name=\"shard-count\" value=\"4\" />")
+	android.AssertStringEquals(t, "extraConfigs", expected, args["extraConfigs"])
+
+	for _, shards := range []string{"0", "-1"} {
+		t.Run(shards, func(t *testing.T) {
+			PrepareForTestWithJavaBuildComponents.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`test_options.shards: must be a positive number, got `+shards,
+			)).RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_options: {
+		unit_test: false,
+		shards: `+shards+`,
+	}
+}
+`)
+		})
+	}
+}
+
+func TestTestRetryOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "count",
+			options:  "retry_count: 2,",
+			expected: "<option name=\"retry-strategy\" value=\"RETRY_ANY_FAILURE\" /><option name=\"max-testcase-run-count\" value=\"3\" />",
+		},
+		{
+			name:     "strategy",
+			options:  `retry_strategy: "RERUN_UNTIL_FAILURE",`,
+			expected: "<option name=\"retry-strategy\" value=\"RERUN_UNTIL_FAILURE\" />",
+		},
+		{
+			name:     "count and strategy",
+			options:  `retry_count: 1, retry_strategy: "RETRY_TEST_CASE_FAILURE",`,
+			expected: "<option name=\"retry-strategy\" value=\"RETRY_TEST_CASE_FAILURE\" /><option name=\"max-testcase-run-count\" value=\"2\" />",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := PrepareForTestWithJavaBuildComponents.RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_options: {
+		unit_test: false,
+		`+tc.options+`
+	}
+}
+`)
+
+			buildOS := result.Config.BuildOS.String()
+			args := result.ModuleForTests("foo", buildOS+"_common").
+				Output("out/soong/.intermediates/foo/" + buildOS + "_common/foo.config").Args
+			android.AssertStringEquals(t, "extraConfigs", proptools.NinjaAndShellEscape(tc.expected), args["extraConfigs"])
+		})
+	}
+
+	errorCases := []struct {
+		name    string
+		options string
+		err     string
+	}{
+		{
+			name:    "unknown strategy",
+			options: `retry_strategy: "SOMETIMES",`,
+			err:     `test_options.retry_strategy: unknown retry strategy "SOMETIMES"`,
+		},
+		{
+			name:    "zero count",
+			options: "retry_count: 0,",
+			err:     `test_options.retry_count: must be a positive number, got 0`,
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			PrepareForTestWithJavaBuildComponents.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				tc.err,
+			)).RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	test_options: {
+		unit_test: false,
+		`+tc.options+`
+	}
+}
+`)
+		})
+	}
+}
+
+func TestTestStem(t *testing.T) {
+	result := PrepareForTestWithJavaBuildComponents.RunTestWithBp(t, `
+java_test_host {
+	name: "foo",
+	stem: "bar",
+	test_options: {
+		unit_test: false,
+	},
+}
+`)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common")
+	config := foo.Output("out/soong/.intermediates/foo/" + buildOS + "_common/foo.config")
+	android.AssertStringEquals(t, "test config name", "bar", config.Args["name"])
+	android.AssertStringEquals(t, "install name", "bar.jar", foo.Module().(*TestHost).installFile.Base())
+}
+
+func TestTestRequiredAbi(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				required_abi: "arm64-v8a",
+			},
+		}
+	`)
+
+	args := ctx.ModuleForTests("foo", "android_common").
+		Output("out/soong/.intermediates/foo/android_common/foo.config").Args
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		"com.android.tradefed.testtype.suite.module.ArchModuleController")
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		proptools.NinjaAndShellEscape(`<option name="arch" value="arm64" />`))
+
+	testJavaError(t, `test_options.required_abi: unknown ABI "arm64"`, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				required_abi: "arm64",
+			},
+		}
+	`)
+}
+
+func TestTestResultReporters(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				tradefed_options: [
+					{
+						name: "exclude-path",
+						value: "org/apache",
+					},
+				],
+				result_reporters: [
+					{
+						class: "com.android.ci.Reporter",
+						options: [
+							{
+								name: "upload-url",
+								value: "https://example.com",
+							},
+						],
+					},
+				],
+			},
+		}
+	`)
+
+	args := ctx.ModuleForTests("foo", "android_common").
+		Output("out/soong/.intermediates/foo/android_common/foo.config").Args
+	android.AssertStringEquals(t, "extraConfigs", proptools.NinjaAndShellEscape(
+		`<result_reporter class="com.android.ci.Reporter">\n        `+
+			`<option name="upload-url" value="https://example.com" />\n    </result_reporter>\n    `+
+			`<option name="exclude-path" value="org/apache" />`),
+		args["extraConfigs"])
+
+	testJavaError(t, `test_options.result_reporters: invalid class name "com.android.ci.Reporter "`, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				result_reporters: [
+					{
+						class: "com.android.ci.Reporter ",
+					},
+				],
+			},
+		}
+	`)
+}
+
+func TestTestSuiteData(t *testing.T) {
+	ctx, config := testJavaWithFS(t, `
+		java_test_host {
+			name: "foo",
+			srcs: ["a.java"],
+			test_suites: ["cts", "vts", "general-tests"],
+			data: ["common.txt"],
+			suite_data: [
+				{
+					suite: "cts",
+					data: ["cts.txt"],
+				},
+				{
+					suite: "vts",
+					data: ["vts.txt"],
+				},
+				{
+					suite: "mts",
+					data: ["mts.txt"],
+				},
+			],
+		}
+	`, map[string][]byte{
+		"common.txt": nil,
+		"cts.txt":    nil,
+		"vts.txt":    nil,
+		"mts.txt":    nil,
+	})
+
+	foo := ctx.ModuleForTests("foo", config.BuildOS.String()+"_common").Module().(*TestHost)
+
+	// Only the data is installed for every suite, the suite data of suites that are not in
+	// test_suites is ignored.
+	android.AssertPathsRelativeToTopEquals(t, "data", []string{"common.txt"}, foo.data)
+	entries := android.AndroidMkEntriesForTest(t, ctx, foo)[0]
+	android.AssertArrayString(t, "support files", []string{"common.txt:common.txt"},
+		entries.EntryMap["LOCAL_COMPATIBILITY_SUPPORT_FILES"])
+
+	// The cts data is only installed into cts and the vts data only into vts.
+	android.AssertArrayString(t, "suite data footer", []string{
+		"ifdef COMPATIBILITY_TESTCASES_OUT_cts",
+		"$(eval $(call copy-one-file,cts.txt,$(COMPATIBILITY_TESTCASES_OUT_cts)/foo/cts.txt))",
+		"COMPATIBILITY.cts.FILES += $(COMPATIBILITY_TESTCASES_OUT_cts)/foo/cts.txt",
+		"endif",
+		"ifdef COMPATIBILITY_TESTCASES_OUT_vts",
+		"$(eval $(call copy-one-file,vts.txt,$(COMPATIBILITY_TESTCASES_OUT_vts)/foo/vts.txt))",
+		"COMPATIBILITY.vts.FILES += $(COMPATIBILITY_TESTCASES_OUT_vts)/foo/vts.txt",
+		"endif",
+	}, suiteDataFooterLines(entries.FooterLinesForTests()))
+}
+
+// suiteDataFooterLines returns the lines written by androidMkWriteSuiteData from the footer lines.
+func suiteDataFooterLines(lines []string) []string {
+	var ret []string
+	inSuite := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ifdef COMPATIBILITY_TESTCASES_OUT_") {
+			inSuite = true
+		}
+		if inSuite {
+			ret = append(ret, line)
+		}
+		if line == "endif" {
+			inSuite = false
+		}
+	}
+	return ret
+}
+
+func TestTestDataTestApps(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			data_test_apps: ["bar", "baz"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			sdk_version: "current",
+		}
+
+		android_test_helper_app {
+			name: "baz",
+			srcs: ["c.java"],
+			sdk_version: "current",
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	args := foo.Output("out/soong/.intermediates/foo/android_common/foo.config").Args
+	android.AssertStringEquals(t, "extraConfigs", proptools.NinjaAndShellEscape(
+		`<target_preparer class="com.android.tradefed.targetprep.TestAppInstallSetup">\n        `+
+			`<option name="test-file-name" value="bar.apk" />\n        `+
+			`<option name="test-file-name" value="baz.apk" />\n        `+
+			`<option name="cleanup-apks" value="true" />\n    </target_preparer>`),
+		args["extraConfigs"])
+
+	android.AssertPathsRelativeToTopEquals(t, "data", []string{
+		"out/soong/.intermediates/bar/android_common/bar.apk",
+		"out/soong/.intermediates/baz/android_common/baz.apk",
+	}, foo.Module().(*Test).data)
+
+	testJavaError(t, `data_test_apps: "bar" is not an installable android_app`, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			data_test_apps: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`)
+}
+
+func TestTestUninstallPackages(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			data_test_apps: ["bar"],
+			test_options: {
+				uninstall_packages: ["com.android.bar", "com.android.baz"],
+			},
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			sdk_version: "current",
+		}
+	`)
+
+	args := ctx.ModuleForTests("foo", "android_common").
+		Output("out/soong/.intermediates/foo/android_common/foo.config").Args
+	android.AssertStringEquals(t, "extraConfigs", proptools.NinjaAndShellEscape(
+		`<target_preparer class="com.android.tradefed.targetprep.RunCommandTargetPreparer">\n        `+
+			`<option name="run-command" value="pm uninstall com.android.bar" />\n        `+
+			`<option name="run-command" value="pm uninstall com.android.baz" />\n    </target_preparer>\n    `+
+			`<target_preparer class="com.android.tradefed.targetprep.TestAppInstallSetup">\n        `+
+			`<option name="test-file-name" value="bar.apk" />\n        `+
+			`<option name="cleanup-apks" value="true" />\n    </target_preparer>`),
+		args["extraConfigs"])
+
+	testJavaError(t, `test_options.uninstall_packages: invalid package name "com.android.bar; reboot"`, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				uninstall_packages: ["com.android.bar; reboot"],
+			},
+		}
+	`)
+}
 
-	buildOS := result.Config.BuildOS.String()
-	args := result.ModuleForTests("foo", buildOS+"_common").
-		Output("out/soong/.intermediates/foo/" + buildOS + "_common/foo.config").Args
-	expected := proptools.NinjaAndShellEscape("<option name=\"exclude-path\" value=\"org/apache\" />")
-	if args["extraConfigs"] != expected {
-		t.Errorf("Expected args[\"extraConfigs\"] to equal %q, was %q", expected, args["extraConfigs"])
-	}
+func TestTestRequiredDeviceProperties(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				required_abi: "arm64-v8a",
+				required_device_properties: [
+					{
+						name: "ro.debuggable",
+						value: "1",
+					},
+				],
+			},
+		}
+	`)
+
+	args := ctx.ModuleForTests("foo", "android_common").
+		Output("out/soong/.intermediates/foo/android_common/foo.config").Args
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		"com.android.tradefed.testtype.suite.module.SystemPropertyModuleController")
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		proptools.NinjaAndShellEscape(`<option name="property-name" value="ro.debuggable" />`))
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		proptools.NinjaAndShellEscape(`<option name="expected-value" value="1" />`))
+	android.AssertStringDoesContain(t, "extraConfigs", args["extraConfigs"],
+		"com.android.tradefed.testtype.suite.module.ArchModuleController")
+
+	testJavaError(t, `test_options.required_device_properties: invalid property name "ro debuggable"`, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				required_device_properties: [
+					{
+						name: "ro debuggable",
+						value: "1",
+					},
+				],
+			},
+		}
+	`)
 }
 
 func TestTestRunnerOptions(t *testing.T) {
@@ -2382,6 +4899,41 @@ java_test_host {
 	}
 }
 
+func TestTestJunitVersion(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "junit",
+			srcs: ["junit.java"],
+		}
+
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				junit_version: "4",
+			},
+		}
+	`)
+
+	junitHeaderJar := result.ModuleForTests("junit", "android_common").Output("turbine-combined/junit.jar").Output
+	javac := result.ModuleForTests("foo", "android_common").Rule("javac")
+	android.AssertStringDoesContain(t, "foo classpath", javac.Args["classpath"], junitHeaderJar.String())
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`test_options.junit_version: unknown JUnit version "3"`,
+	)).RunTestWithBp(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				junit_version: "3",
+			},
+		}
+	`)
+}
+
 func TestJavaExcludeStaticLib(t *testing.T) {
 	ctx, _ := testJava(t, `
 	java_library {
@@ -2506,6 +5058,216 @@ func TestJavaApiLibraryApiFilesSorting(t *testing.T) {
 	android.AssertStringDoesContain(t, "source text files not in api scope order", manifestCommand, sourceFilesFlag)
 }
 
+func TestJavaApiLibraryPreviousApis(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(map[string][]byte{
+			"prebuilts/sdk/34/public/api/foo.txt": nil,
+			"prebuilts/sdk/33/public/api/foo.txt": nil,
+		}),
+	).RunTestWithBp(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			previous_apis: [
+				"prebuilts/sdk/34/public/api/foo.txt",
+				"prebuilts/sdk/33/public/api/foo.txt",
+			],
+			stubs_type: "everything",
+		}
+	`)
+	m := result.ModuleForTests("foo", "android_common")
+	manifest := m.Output("metalava.sbox.textproto")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, manifest)
+	manifestCommand := sboxProto.Commands[0].GetCommand()
+	android.AssertStringDoesContain(t, "previous apis not in ascending API level order", manifestCommand,
+		"--migrate-nullness prebuilts/sdk/33/public/api/foo.txt "+
+			"--migrate-nullness prebuilts/sdk/34/public/api/foo.txt")
+
+	android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(map[string][]byte{
+			"prebuilts/sdk/33/public/api/foo.txt": nil,
+		}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`previous_apis: cannot be set together with previous_api`,
+	)).RunTestWithBp(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			previous_api: "prebuilts/sdk/33/public/api/foo.txt",
+			previous_apis: ["prebuilts/sdk/33/public/api/foo.txt"],
+			stubs_type: "everything",
+		}
+	`)
+}
+
+func TestJavaApiLibraryEmitApiVersions(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(map[string][]byte{
+			"prebuilts/sdk/34/public/api/foo.txt": nil,
+			"prebuilts/sdk/33/public/api/foo.txt": nil,
+		}),
+	).RunTestWithBp(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			previous_apis: [
+				"prebuilts/sdk/34/public/api/foo.txt",
+				"prebuilts/sdk/33/public/api/foo.txt",
+			],
+			emit_api_versions: true,
+			stubs_type: "everything",
+		}
+
+		java_api_library {
+			name: "bar",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			stubs_type: "everything",
+		}
+	`)
+	m := result.ModuleForTests("foo", "android_common")
+	manifest := m.Output("metalava.sbox.textproto")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, manifest)
+	manifestCommand := sboxProto.Commands[0].GetCommand()
+	android.AssertStringDoesContain(t, "api versions output", manifestCommand,
+		"--generate-api-version-history __SBOX_SANDBOX_DIR__/out/foo-api-versions.xml")
+	android.AssertStringDoesContain(t, "api versions signature files", manifestCommand,
+		"--api-version-signature-files prebuilts/sdk/33/public/api/foo.txt:prebuilts/sdk/34/public/api/foo.txt")
+	android.AssertStringDoesContain(t, "api versions names", manifestCommand,
+		"--api-version-names '33 34 "+result.Config.PlatformSdkVersion().String()+"'")
+
+	outputs, err := m.Module().(*ApiLibrary).OutputFiles(".api_versions.xml")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "api versions xml",
+		[]string{"out/soong/.intermediates/foo/android_common/metalava/foo-api-versions.xml"}, outputs)
+
+	bar := result.ModuleForTests("bar", "android_common")
+	barManifest := bar.Output("metalava.sbox.textproto")
+	barCommand := android.RuleBuilderSboxProtoForTests(t, result.TestContext, barManifest).Commands[0].GetCommand()
+	android.AssertStringDoesNotContain(t, "api versions output", barCommand, "--generate-api-version-history")
+}
+
+func TestJavaApiLibraryApiDiffOutput(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureMergeMockFs(map[string][]byte{
+			"prebuilts/sdk/34/public/api/foo.txt": nil,
+		}),
+	).RunTestWithBp(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			previous_api: "prebuilts/sdk/34/public/api/foo.txt",
+			api_diff_output: true,
+			stubs_type: "everything",
+		}
+	`)
+	m := result.ModuleForTests("foo", "android_common")
+	manifest := m.Output("api_diff.sbox.textproto")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, manifest)
+	manifestCommand := sboxProto.Commands[0].GetCommand()
+	android.AssertStringDoesContain(t, "previous signatures", manifestCommand,
+		"--source-files prebuilts/sdk/34/public/api/foo.txt")
+	android.AssertStringDoesContain(t, "previous signatures output", manifestCommand,
+		"--api __SBOX_SANDBOX_DIR__/out/previous.txt")
+	android.AssertStringDoesContain(t, "current signatures output", manifestCommand,
+		"--api __SBOX_SANDBOX_DIR__/out/current.txt")
+	android.AssertStringDoesContain(t, "api diff", manifestCommand,
+		"(diff --unchanged-line-format= --old-line-format='- %L' --new-line-format='+ %L' "+
+			"__SBOX_SANDBOX_DIR__/out/previous.txt __SBOX_SANDBOX_DIR__/out/current.txt "+
+			"> __SBOX_SANDBOX_DIR__/out/foo-api-diff.txt || test $? -eq 1)")
+
+	outputs, err := m.Module().(*ApiLibrary).OutputFiles(".api_diff")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "api diff",
+		[]string{"out/soong/.intermediates/foo/android_common/api_diff/foo-api-diff.txt"}, outputs)
+}
+
+func TestJavaApiLibraryApiDiffOutputRequiresPreviousApi(t *testing.T) {
+	testJavaError(t, `api_diff_output: requires previous_api to be set`, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			api_diff_output: true,
+			stubs_type: "everything",
+		}
+	`)
+}
+
+func TestJavaApiLibraryStubsJavaVersion(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			system_modules: "core-public-stubs-system-modules.from-text",
+			stubs_java_version: "17",
+			stubs_type: "everything",
+		}
+
+		java_api_library {
+			name: "bar",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			stubs_type: "everything",
+		}
+	`)
+
+	javac := ctx.ModuleForTests("foo", "android_common").Output("metalava/stubs.jar")
+	android.AssertStringEquals(t, "stubs java version", "-source 17 -target 17", javac.Args["javaVersionFlags"])
+	android.AssertStringDoesContain(t, "stubs system modules", javac.Args["bootClasspath"],
+		"--system=out/soong/.intermediates/core-public-stubs-system-modules.from-text/android_common/system")
+
+	javac = ctx.ModuleForTests("bar", "android_common").Output("metalava/stubs.jar")
+	android.AssertStringEquals(t, "default stubs java version", "-source 1.8 -target 1.8", javac.Args["javaVersionFlags"])
+
+	testJavaError(t, `stubs_java_version: Unrecognized Java language level`, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			stubs_java_version: "8.5",
+			stubs_type: "everything",
+		}
+	`)
+}
+
+func TestJavaApiLibraryMetalavaHomeDir(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_api_library {
+			name: "foo",
+			api_contributions: [
+				"api-stubs-docs-non-updatable.api.contribution",
+			],
+			stubs_type: "everything",
+		}
+	`)
+	m := ctx.ModuleForTests("foo", "android_common")
+	manifest := m.Output("metalava.sbox.textproto")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, ctx, manifest)
+	manifestCommand := sboxProto.Commands[0].GetCommand()
+	android.AssertStringDoesContain(t, "metalava home dir", manifestCommand,
+		"ANDROID_PREFS_ROOT=__SBOX_SANDBOX_DIR__/out ")
+	android.AssertStringDoesNotContain(t, "metalava home dir cleanup", manifestCommand, "metalava/home")
+}
+
 func TestSdkLibraryProvidesSystemModulesToApiLibrary(t *testing.T) {
 	result := android.GroupFixturePreparers(
 		prepareForJavaTest,
@@ -2916,54 +5678,259 @@ func TestInvalidTestOnlyTargets(t *testing.T) {
 	}
 }
 
-// Expect exactly one that matches 'expected'.
-// Append 'msg' to the Errorf that printed.
-func expectOneError(expected string, msg string) android.FixtureErrorHandler {
-	return android.FixtureCustomErrorHandler(func(t *testing.T, result *android.TestResult) {
-		t.Helper()
-		if len(result.Errs) != 1 {
-			t.Errorf("Expected exactly one error, but found: %d when  setting test_only on: %s", len(result.Errs), msg)
-			return
+// Expect exactly one that matches 'expected'.
+// Append 'msg' to the Errorf that printed.
+func expectOneError(expected string, msg string) android.FixtureErrorHandler {
+	return android.FixtureCustomErrorHandler(func(t *testing.T, result *android.TestResult) {
+		t.Helper()
+		if len(result.Errs) != 1 {
+			t.Errorf("Expected exactly one error, but found: %d when  setting test_only on: %s", len(result.Errs), msg)
+			return
+		}
+		actualErrMsg := result.Errs[0].Error()
+		if !strings.Contains(actualErrMsg, expected) {
+			t.Errorf("Different error than expected.  Received: [%v] on %s expected: %s", actualErrMsg, msg, expected)
+		}
+	})
+}
+
+func TestJavaLibHostWithStem(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			stem: "foo-new",
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+	foo := ctx.ModuleForTests("foo", buildOS+"_common")
+
+	outputs := fmt.Sprint(foo.AllOutputs())
+	if !strings.Contains(outputs, "foo-new.jar") {
+		t.Errorf("Module output does not contain expected jar %s", "foo-new.jar")
+	}
+}
+
+func TestJavaLibWithStem(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			stem: "foo-new",
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	outputs := fmt.Sprint(foo.AllOutputs())
+	if !strings.Contains(outputs, "foo-new.jar") {
+		t.Errorf("Module output does not contain expected jar %s", "foo-new.jar")
+	}
+}
+
+func TestPermittedPackages(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["bar"],
+			permitted_packages: ["foo", "bar.baz"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	// The package check runs on the jar that includes the static libs.
+	check := foo.Output("package-check.stamp")
+	android.AssertStringEquals(t, "permitted packages", "foo bar.baz", check.Args["packages"])
+	android.AssertPathRelativeToTopEquals(t, "checked jar",
+		"out/soong/.intermediates/foo/android_common/package-check/foo.jar", check.Input)
+
+	// Anything that uses the classes of the library must cause the check to run.
+	checkedJar := foo.Output("package-check/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "validation", android.PathRelativeToTop(check.Output), checkedJar.Validation)
+	android.AssertPathRelativeToTopEquals(t, "implementation jar",
+		android.PathRelativeToTop(checkedJar.Output), foo.Module().(*Library).implementationJarFile)
+}
+
+func TestEnforceNoSplitPackages(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["bar", "baz"],
+			enforce_no_split_packages: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["com/android/shared/Bar.java"],
 		}
-		actualErrMsg := result.Errs[0].Error()
-		if !strings.Contains(actualErrMsg, expected) {
-			t.Errorf("Different error than expected.  Received: [%v] on %s expected: %s", actualErrMsg, msg, expected)
+
+		java_library {
+			name: "baz",
+			srcs: ["com/android/shared/Baz.java"],
 		}
-	})
+
+		java_library {
+			name: "qux",
+			srcs: ["a.java"],
+			static_libs: ["bar", "baz"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	barHeader := ctx.ModuleForTests("bar", "android_common").Output("turbine-combined/bar.jar").Output
+	bazHeader := ctx.ModuleForTests("baz", "android_common").Output("turbine-combined/baz.jar").Output
+
+	// The check reports the packages found in the header jars of more than one static_libs
+	// dependency along with the modules that contain them.
+	check := foo.Output("split-package-check.stamp")
+	android.AssertStringEquals(t, "checked jars",
+		"bar:"+barHeader.String()+": baz:"+bazHeader.String()+":", check.Args["jars"])
+	android.AssertPathsRelativeToTopEquals(t, "check inputs",
+		[]string{barHeader.RelativeToTop().String(), bazHeader.RelativeToTop().String()}, check.Inputs)
+
+	// Anything that uses the classes of the library must cause the check to run.
+	checkedJar := foo.Output("split-package-check/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "validation", android.PathRelativeToTop(check.Output), checkedJar.Validation)
+	android.AssertPathRelativeToTopEquals(t, "implementation jar",
+		android.PathRelativeToTop(checkedJar.Output), foo.Module().(*Library).implementationJarFile)
+
+	qux := ctx.ModuleForTests("qux", "android_common")
+	android.AssertBoolEquals(t, "check enabled by default", false,
+		qux.MaybeOutput("split-package-check.stamp").Rule != nil)
 }
 
-func TestJavaLibHostWithStem(t *testing.T) {
+func TestEnforceNoSplitPackagesSharedStaticLib(t *testing.T) {
 	ctx, _ := testJava(t, `
-		java_library_host {
+		java_library {
 			name: "foo",
 			srcs: ["a.java"],
-			stem: "foo-new",
+			static_libs: ["bar", "baz"],
+			enforce_no_split_packages: true,
 		}
-	`)
 
-	buildOS := ctx.Config().BuildOS.String()
-	foo := ctx.ModuleForTests("foo", buildOS+"_common")
+		java_library {
+			name: "bar",
+			srcs: ["com/android/bar/Bar.java"],
+			static_libs: ["common"],
+		}
 
-	outputs := fmt.Sprint(foo.AllOutputs())
-	if !strings.Contains(outputs, "foo-new.jar") {
-		t.Errorf("Module output does not contain expected jar %s", "foo-new.jar")
-	}
+		java_library {
+			name: "baz",
+			srcs: ["com/android/baz/Baz.java"],
+			static_libs: ["common"],
+		}
+
+		java_library {
+			name: "common",
+			srcs: ["com/android/common/Common.java"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	barHeader := ctx.ModuleForTests("bar", "android_common").Output("turbine-combined/bar.jar").Output
+	bazHeader := ctx.ModuleForTests("baz", "android_common").Output("turbine-combined/baz.jar").Output
+	commonHeader := ctx.ModuleForTests("common", "android_common").Output("turbine-combined/common.jar").Output
+
+	// The classes of common are in the header jars of both bar and baz, but they are only
+	// checked as part of their own module, so its packages are not reported as split.
+	check := foo.Output("split-package-check.stamp")
+	android.AssertStringEquals(t, "checked jars",
+		"bar:"+barHeader.String()+":"+commonHeader.String()+" baz:"+bazHeader.String()+":"+commonHeader.String(),
+		check.Args["jars"])
+	android.AssertPathsRelativeToTopEquals(t, "check inputs", []string{
+		barHeader.RelativeToTop().String(),
+		bazHeader.RelativeToTop().String(),
+		commonHeader.RelativeToTop().String(),
+	}, check.Inputs)
 }
 
-func TestJavaLibWithStem(t *testing.T) {
+func TestEnsureNonemptyJar(t *testing.T) {
 	ctx, _ := testJava(t, `
 		java_library {
 			name: "foo",
+			java_resources: ["res/a"],
+			ensure_nonempty_jar: true,
+		}
+
+		java_library {
+			name: "bar",
 			srcs: ["a.java"],
-			stem: "foo-new",
+			ensure_nonempty_jar: true,
 		}
 	`)
 
+	// The classes jar of a module without classes is a jar with only the marker entry.
 	foo := ctx.ModuleForTests("foo", "android_common")
+	marker := foo.Output("nonempty-marker/foo.jar")
+	android.AssertStringDoesContain(t, "marker entry", marker.RuleParams.Command, "META-INF/.nonempty")
+	android.AssertStringDoesContain(t, "marker jar is a zip", marker.RuleParams.Command, "soong_zip")
+	android.AssertPathRelativeToTopEquals(t, "implementation jar",
+		android.PathRelativeToTop(marker.Output), foo.Module().(*Library).implementationJarFile)
+
+	// The resources are still merged into the jar that contains the marker entry.
+	withRes := foo.Output("withres/foo.jar")
+	android.AssertPathsRelativeToTopEquals(t, "jar with resources inputs",
+		[]string{
+			"out/soong/.intermediates/foo/android_common/res/foo.jar",
+			"out/soong/.intermediates/foo/android_common/nonempty-marker/foo.jar",
+		}, withRes.Inputs)
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	android.AssertBoolEquals(t, "marker for module with classes", false,
+		bar.MaybeOutput("nonempty-marker/bar.jar").Rule != nil)
+}
 
-	outputs := fmt.Sprint(foo.AllOutputs())
-	if !strings.Contains(outputs, "foo-new.jar") {
-		t.Errorf("Module output does not contain expected jar %s", "foo-new.jar")
+func TestSeparateJarOutputDirs(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+	`
+
+	testCases := []struct {
+		name           string
+		env            map[string]string
+		expectedHeader string
+		expectedImpl   string
+	}{
+		{
+			name:           "default",
+			expectedHeader: "out/soong/.intermediates/foo/android_common/turbine-combined/foo.jar",
+			expectedImpl:   "out/soong/.intermediates/foo/android_common/javac/foo.jar",
+		},
+		{
+			name:           "separate",
+			env:            map[string]string{"SOONG_SEPARATE_JAR_OUTPUT_DIRS": "true"},
+			expectedHeader: "out/soong/.intermediates/foo/android_common/headers/turbine-combined/foo.jar",
+			expectedImpl:   "out/soong/.intermediates/foo/android_common/impl/javac/foo.jar",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				PrepareForTestWithJavaDefaultModules,
+				android.FixtureMergeEnv(tc.env),
+			).RunTestWithBp(t, bp)
+
+			foo := result.ModuleForTests("foo", "android_common").Module().(*Library)
+			headerJars, err := foo.OutputFiles(".hjar")
+			android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+			android.AssertPathsRelativeToTopEquals(t, "header jar", []string{tc.expectedHeader}, headerJars)
+
+			implJar := foo.implementationJarFile
+			android.AssertPathRelativeToTopEquals(t, "implementation jar", tc.expectedImpl, implJar)
+		})
 	}
 }
 
@@ -3042,3 +6009,349 @@ func assertTestOnlyAndTopLevel(t *testing.T, ctx *android.TestResult, expectedTe
 		t.Errorf("top-level: Expected but not found: %v, Found but not expected: %v", left, right)
 	}
 }
+
+func TestEmitDepsHeaderZip(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["baz"],
+			static_libs: ["bar"],
+			emit_deps_header_zip: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			libs: ["baz"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+		}
+	`)
+
+	bazHeaderJar := result.ModuleForTests("baz", "android_common").Output("turbine-combined/baz.jar").Output
+	barHeaderJar := result.ModuleForTests("bar", "android_common").Output("turbine-combined/bar.jar").Output
+
+	foo := result.ModuleForTests("foo", "android_common")
+	depsHeaderZip := foo.Output("foo-deps-headers.zip")
+
+	count := 0
+	for _, input := range depsHeaderZip.Inputs.Strings() {
+		if input == bazHeaderJar.String() {
+			count++
+		}
+	}
+	android.AssertIntEquals(t, "baz header jar occurrences in deps header zip", 1, count)
+	android.AssertStringListContains(t, "deps header zip inputs",
+		depsHeaderZip.Inputs.Strings(), barHeaderJar.String())
+
+	// The header jars are stored without their directories, so that the zip entries don't depend
+	// on the output directory.
+	android.AssertStringDoesContain(t, "deps header zip command", depsHeaderZip.RuleParams.Command,
+		"-o out/soong/.intermediates/foo/android_common/foo-deps-headers.zip -j -r out/soong/.intermediates/foo/android_common/foo-deps-headers.rsp")
+
+	outputs, err := foo.Module().(*Library).OutputFiles(".deps-headers.zip")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "deps header zip output",
+		[]string{"out/soong/.intermediates/foo/android_common/foo-deps-headers.zip"}, outputs)
+
+	PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`emit_deps_header_zip: header jars .* have the same name "common.jar"`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar", "baz"],
+			emit_deps_header_zip: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			stem: "common",
+			installable: false,
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			stem: "common",
+			installable: false,
+		}
+	`)
+}
+
+func TestEmitServiceUsage(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			emit_service_usage: true,
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	fooJavaInfo, _ := android.SingletonModuleProvider(result, foo.Module(), JavaInfoProvider)
+	serviceUsage := foo.Rule("serviceUsage")
+	android.AssertPathsRelativeToTopEquals(t, "service usage input",
+		android.PathsRelativeToTop(fooJavaInfo.ImplementationJars), android.Paths{serviceUsage.Input})
+	android.AssertStringDoesContain(t, "service usage command", serviceUsage.RuleParams.Command,
+		`/java\/util\/ServiceLoader\.load/`)
+	android.AssertStringDoesContain(t, "service usage command", serviceUsage.RuleParams.Command,
+		"xargs -0 -r ${config.JavapCmd}")
+
+	outputs, err := foo.Module().(*Library).OutputFiles(".service-usage")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "service usage output",
+		[]string{"out/soong/.intermediates/foo/android_common/foo-service-usage.txt"}, outputs)
+}
+
+func TestEmitNativeMethods(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			emit_native_methods: true,
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	fooJavaInfo, _ := android.SingletonModuleProvider(result, foo.Module(), JavaInfoProvider)
+	nativeMethods := foo.Rule("nativeMethods")
+	android.AssertPathsRelativeToTopEquals(t, "native methods input",
+		android.PathsRelativeToTop(fooJavaInfo.ImplementationJars), android.Paths{nativeMethods.Input})
+	android.AssertStringDoesContain(t, "native methods command", nativeMethods.RuleParams.Command,
+		`/^ .* native /`)
+	android.AssertStringDoesContain(t, "native methods command", nativeMethods.RuleParams.Command,
+		"xargs -0 -r ${config.JavapCmd} -p")
+
+	outputs, err := foo.Module().(*Library).OutputFiles(".native-methods")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "native methods output",
+		[]string{"out/soong/.intermediates/foo/android_common/foo-native-methods.txt"}, outputs)
+}
+
+func TestImplementationJarChecksum(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	fooJavaInfo, _ := android.SingletonModuleProvider(result, foo.Module(), JavaInfoProvider)
+	checksum := foo.Output("foo.jar.sha256")
+	android.AssertPathsRelativeToTopEquals(t, "checksum input",
+		android.PathsRelativeToTop(fooJavaInfo.ImplementationJars), android.Paths{checksum.Input})
+	android.AssertStringDoesContain(t, "checksum command", checksum.RuleParams.Command, "sha256sum $in")
+
+	outputs, err := foo.Module().(*Library).OutputFiles(".sha256")
+	android.AssertDeepEquals(t, "OutputFiles error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "checksum output",
+		[]string{"out/soong/.intermediates/foo/android_common/foo.jar.sha256"}, outputs)
+}
+
+func TestDexAlign(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		dexpreopt.PrepareForTestByEnablingDexpreopt,
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			uncompress_dex: true,
+			dex_align: 16384,
+		}
+
+		dex_import {
+			name: "bar",
+			jars: ["bar.jar"],
+			dex_align: 16384,
+		}
+	`)
+
+	fooAlign := result.ModuleForTests("foo", "android_common").Output("aligned/foo.jar")
+	android.AssertStringEquals(t, "foo zipalign alignment", "16384", fooAlign.Args["alignment"])
+
+	barAlign := result.ModuleForTests("bar", "android_common").Output("bar.jar")
+	android.AssertStringDoesContain(t, "bar zipalign command", barAlign.RuleParams.Command, "zipalign -f 16384 ")
+
+	android.GroupFixturePreparers(
+		prepareForJavaTest,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`dex_align: must be a power of two, got 3`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			uncompress_dex: true,
+			dex_align: 3,
+		}
+	`)
+}
+
+func TestDexImportBootJar(t *testing.T) {
+	result := prepareForJavaTest.RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			is_boot_jar: true,
+			permitted_packages: ["com.android.foo"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+
+	// Boot jars are always stored uncompressed.
+	foo.Rule("uncompress_dex")
+
+	check := foo.Rule("dex_jar_package_check")
+	android.AssertStringDoesContain(t, "package check command", check.RuleParams.Command,
+		"out/soong/.intermediates/foo/android_common/foo.jar")
+	checkedJar := foo.Output("package-check/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "validation",
+		"out/soong/.intermediates/foo/android_common/package-check.stamp", checkedJar.Validation)
+	android.AssertPathRelativeToTopEquals(t, "dex jar",
+		"out/soong/.intermediates/foo/android_common/package-check/foo.jar",
+		foo.Module().(*DexImport).DexJarBuildPath(moduleErrorfTestCtx{}).Path())
+
+	// Match the packages the same way as check_boot_jars.
+	allowList := android.ContentFromFileRuleForTests(t, result.TestContext, foo.Output("package-check/package_allowed_list.txt"))
+	allowListRegexp := regexp.MustCompile(`^(` + strings.Join(strings.Split(strings.TrimSpace(allowList), "\n"), "|") + `)$`)
+	for pkg, expected := range map[string]bool{
+		"com.android.foo":     true,
+		"com.android.foo.bar": true,
+		"com.android.foobar":  false,
+		"com.android.bar":     false,
+	} {
+		android.AssertBoolEquals(t, "package "+pkg+" permitted", expected, allowListRegexp.MatchString(pkg))
+	}
+
+	prepareForJavaTest.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`permitted_packages: must be set when is_boot_jar is true`,
+	)).RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			is_boot_jar: true,
+		}
+	`)
+
+	// Boot jars cannot be forced to keep their dex files compressed.
+	prepareForJavaTest.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`uncompress_dex: cannot be false when is_boot_jar is true`,
+	)).RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			is_boot_jar: true,
+			permitted_packages: ["com.android.foo"],
+			uncompress_dex: false,
+		}
+	`)
+}
+
+func TestDexImportUncompressDex(t *testing.T) {
+	result := prepareForJavaTest.RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			uncompress_dex: false,
+		}
+
+		dex_import {
+			name: "bar",
+			jars: ["bar.jar"],
+			uncompress_dex: true,
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	android.AssertBoolEquals(t, "foo uncompressed", false, foo.Module().(*DexImport).dexpreopter.uncompressedDex)
+	if foo.MaybeRule("uncompress_dex").Rule != nil {
+		t.Errorf("expected foo dex files to be left compressed")
+	}
+	android.AssertPathRelativeToTopEquals(t, "foo copy input", "foo.jar", foo.Output("foo.jar").Input)
+
+	bar := result.ModuleForTests("bar", "android_common")
+	android.AssertBoolEquals(t, "bar uncompressed", true, bar.Module().(*DexImport).dexpreopter.uncompressedDex)
+	bar.Rule("uncompress_dex")
+}
+
+func TestDexImportMinApiLevel(t *testing.T) {
+	result := prepareForJavaTest.RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			min_api_level: "26",
+		}
+
+		dex_import {
+			name: "bar",
+			jars: ["bar.jar"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	check := foo.Rule("checkDexApiLevel")
+	android.AssertStringEquals(t, "min api level", "26", check.Args["minApiLevel"])
+	android.AssertPathRelativeToTopEquals(t, "checked jar", "foo.jar", check.Input)
+	checkedJar := foo.Output("dex-api-level-check/foo.jar")
+	android.AssertPathRelativeToTopEquals(t, "validation",
+		"out/soong/.intermediates/foo/android_common/dex-api-level-check.stamp", checkedJar.Validation)
+	android.AssertPathRelativeToTopEquals(t, "dex jar",
+		"out/soong/.intermediates/foo/android_common/dex-api-level-check/foo.jar",
+		foo.Module().(*DexImport).DexJarBuildPath(moduleErrorfTestCtx{}).Path())
+
+	bar := result.ModuleForTests("bar", "android_common")
+	if bar.MaybeRule("checkDexApiLevel").Rule != nil {
+		t.Errorf("expected no dex api level check without min_api_level")
+	}
+
+	prepareForJavaTest.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`min_api_level: `,
+	)).RunTestWithBp(t, `
+		dex_import {
+			name: "foo",
+			jars: ["foo.jar"],
+			min_api_level: "foo",
+		}
+	`)
+}
+
+func TestHostStubOnly(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			host_supported: true,
+			host_stub_only: true,
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+
+	deviceJavac := result.ModuleForTests("foo", "android_common").MaybeRule("javac")
+	android.AssertPathsRelativeToTopEquals(t, "device javac inputs", []string{"a.java"}, deviceJavac.Inputs)
+
+	hostJavac := result.ModuleForTests("foo", buildOS+"_common").MaybeRule("javac")
+	android.AssertDeepEquals(t, "host javac rule", nil, hostJavac.Rule)
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`host_stub_only: can only be set on modules that are host_supported`,
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			host_stub_only: true,
+		}
+	`)
+}
@@ -389,3 +389,58 @@ func TestKotlinCompose(t *testing.T) {
 	android.AssertStringDoesNotContain(t, "unexpected compose compiler plugin",
 		noCompose.VariablesForTestsRelativeToTop()["kotlincFlags"], "-Xplugin="+composeCompiler.String())
 }
+
+func TestKotlinExportedKotlincflags(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, `
+		java_library {
+			name: "exporter",
+			srcs: ["a.java"],
+			exported_kotlincflags: ["-Xjvm-default=all"],
+		}
+
+		java_library {
+			name: "static_exporter",
+			srcs: ["a.java"],
+			static_libs: ["exporter"],
+			exported_kotlincflags: ["-Xjvm-default=all", "-Xcontext-receivers"],
+		}
+
+		java_library {
+			name: "consumer",
+			srcs: ["a.kt"],
+			libs: ["static_exporter"],
+		}
+
+		java_library {
+			name: "other",
+			srcs: ["a.kt"],
+		}
+	`)
+
+	consumer := result.ModuleForTests("consumer", "android_common")
+	flags := consumer.VariablesForTestsRelativeToTop()["kotlincFlags"]
+	android.AssertStringDoesContain(t, "missing exported flag", flags, "-Xcontext-receivers")
+	android.AssertIntEquals(t, "exported flag count", 1, strings.Count(flags, "-Xjvm-default=all"))
+
+	exporter := result.ModuleForTests("exporter", "android_common")
+	android.AssertStringDoesNotContain(t, "exported flag applied to the exporter",
+		exporter.VariablesForTestsRelativeToTop()["kotlincFlags"], "-Xjvm-default=all")
+
+	other := result.ModuleForTests("other", "android_common")
+	android.AssertStringDoesNotContain(t, "unexpected exported flag",
+		other.VariablesForTestsRelativeToTop()["kotlincFlags"], "-Xjvm-default=all")
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		"exported_kotlincflags: Bad flag: `-include-runtime`, do not include runtime.",
+	)).RunTestWithBp(t, `
+		java_library {
+			name: "exporter",
+			srcs: ["a.java"],
+			exported_kotlincflags: ["-include-runtime"],
+		}
+	`)
+}
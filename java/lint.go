@@ -56,7 +56,8 @@ type LintProperties struct {
 		Extra_check_modules []string
 
 		// The lint baseline file to use. If specified, lint warnings listed in this file will be
-		// suppressed during lint checks.
+		// suppressed during lint checks.  Building with UPDATE_LINT_BASELINES=true replaces the file
+		// with the issues found by lint instead of failing on them.
 		Baseline_filename *string
 
 		// If true, baselining updatability lint checks (e.g. NewApi) is prohibited. Defaults to false.
@@ -496,7 +497,11 @@ func (l *linter) lint(ctx android.ModuleContext) {
 	rule.Temporary(lintPaths.projectXML)
 	rule.Temporary(lintPaths.configXML)
 
-	suppressExitCode := BoolDefault(l.properties.Lint.Suppress_exit_code, false)
+	// When UPDATE_LINT_BASELINES=true is set the baseline is replaced with the issues that lint
+	// finds instead of failing the build.
+	updateBaseline := l.properties.Lint.Baseline_filename != nil && ctx.Config().IsEnvTrue("UPDATE_LINT_BASELINES")
+
+	suppressExitCode := BoolDefault(l.properties.Lint.Suppress_exit_code, false) || updateBaseline
 	if exitCode := ctx.Config().Getenv("ANDROID_LINT_SUPPRESS_EXIT_CODE"); exitCode == "" && !suppressExitCode {
 		cmd.Flag("--exitcode")
 	}
@@ -530,6 +535,22 @@ func (l *linter) lint(ctx android.ModuleContext) {
 
 	rule.Build("lint", "lint")
 
+	var baselineUpdated android.Path
+	if updateBaseline {
+		// Copy the reference baseline written by lint over the baseline in the source tree, outside
+		// of the sandbox of the lint rule. The baseline in the source tree is an input of the lint
+		// rule, only touch it when the content changed so that the next build is a no-op.
+		stamp := android.PathForModuleOut(ctx, "lint", "lint-baseline-updated.stamp")
+		srcBaseline := android.PathForModuleSrc(ctx, *l.properties.Lint.Baseline_filename).String()
+		updateRule := android.NewRuleBuilder(pctx, ctx)
+		updateRule.Command().Text("cmp -s").Input(referenceBaseline).Text(srcBaseline).
+			Text("|| cp -f").Input(referenceBaseline).Text(srcBaseline)
+		updateRule.Command().Text("touch").Output(stamp)
+		updateRule.Build("update_lint_baseline", "update lint baseline")
+		baselineUpdated = stamp
+		ctx.Phony("update-lint-baselines", stamp)
+	}
+
 	l.outputs = lintOutputs{
 		html:              html,
 		text:              text,
@@ -545,7 +566,7 @@ func (l *linter) lint(ctx android.ModuleContext) {
 
 	// Create a per-module phony target to run the lint check.
 	phonyName := ctx.ModuleName() + "-lint"
-	ctx.Phony(phonyName, xml)
+	ctx.Phony(phonyName, android.PathsIfNonNil(xml, baselineUpdated)...)
 }
 
 func BuildModuleLintReportZips(ctx android.ModuleContext, depSets LintDepSets) android.Paths {
@@ -45,6 +45,56 @@ func TestJavaLintDoesntUseBaselineImplicitly(t *testing.T) {
 	}
 }
 
+func TestJavaLintUpdateBaselines(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			min_sdk_version: "29",
+			sdk_version: "system_current",
+			lint: {
+				baseline_filename: "lint-baseline.xml",
+			},
+		}
+	`
+	fs := android.MockFS{
+		"lint-baseline.xml": nil,
+	}
+
+	t.Run("check", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			fs.AddToFixture(),
+		).RunTestWithBp(t, bp)
+
+		foo := result.ModuleForTests("foo", "android_common")
+		sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, foo.Output("lint.sbox.textproto"))
+		android.AssertStringDoesContain(t, "lint command", *sboxProto.Commands[0].Command, "--exitcode")
+		android.AssertBoolEquals(t, "baseline updated", false,
+			foo.MaybeOutput("lint/lint-baseline-updated.stamp").Rule != nil)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			fs.AddToFixture(),
+			android.FixtureMergeEnv(map[string]string{
+				"UPDATE_LINT_BASELINES": "true",
+			}),
+		).RunTestWithBp(t, bp)
+
+		foo := result.ModuleForTests("foo", "android_common")
+		sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, foo.Output("lint.sbox.textproto"))
+		android.AssertStringDoesNotContain(t, "lint command", *sboxProto.Commands[0].Command, "--exitcode")
+		android.AssertStringDoesContain(t, "lint command", *sboxProto.Commands[0].Command, "--baseline lint-baseline.xml")
+
+		update := foo.Output("lint/lint-baseline-updated.stamp")
+		android.AssertStringDoesContain(t, "update command", update.RuleParams.Command,
+			"cmp -s out/soong/.intermediates/foo/android_common/lint/lint-baseline.xml lint-baseline.xml || "+
+				"cp -f out/soong/.intermediates/foo/android_common/lint/lint-baseline.xml lint-baseline.xml")
+	})
+}
+
 func TestJavaLintRequiresCustomLintFileToExist(t *testing.T) {
 	android.GroupFixturePreparers(
 		PrepareForTestWithJavaDefaultModules,
@@ -52,4 +52,31 @@ type PluginProperties struct {
 	// This necessitates disabling the turbine optimization on modules that use this plugin, which will reduce
 	// parallelism and cause more recompilation for modules that depend on modules that use this plugin.
 	Generates_api *bool
+
+	// If set, the annotation processor only generates API when this annotation processor option is
+	// passed to javac, e.g. with -A<option> or -A<option>=<value> in javacflags, and the turbine
+	// optimization is only disabled for the modules using this plugin that pass it.  Cannot be
+	// combined with generates_api.
+	Generates_api_when_option *string
+}
+
+func (p *Plugin) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if p.pluginProperties.Generates_api_when_option != nil {
+		if *p.pluginProperties.Generates_api_when_option == "" {
+			ctx.PropertyErrorf("generates_api_when_option", "must not be empty")
+		}
+		if Bool(p.pluginProperties.Generates_api) {
+			ctx.PropertyErrorf("generates_api_when_option", "cannot be set together with generates_api")
+		}
+	}
+	p.Library.GenerateAndroidBuildActions(ctx)
+}
+
+// processorKey returns the key of the plugin in JavaInfo.ExportedPluginGeneratesApiOptions, which
+// is its processor class if it has one and its module name otherwise.
+func (p *Plugin) processorKey() string {
+	if p.pluginProperties.Processor_class != nil {
+		return *p.pluginProperties.Processor_class
+	}
+	return p.Name()
 }
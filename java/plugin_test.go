@@ -15,7 +15,10 @@
 package java
 
 import (
+	"strings"
 	"testing"
+
+	"android/soong/android"
 )
 
 func TestNoPlugin(t *testing.T) {
@@ -120,3 +123,116 @@ func TestPluginGeneratesApi(t *testing.T) {
 		t.Errorf("foo processor %q != '-processor com.bar'", javac.Args["processor"])
 	}
 }
+
+func TestExportedPluginsGeneratesApi(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			exported_plugins: ["bar", "baz"],
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			generates_api: true,
+		}
+
+		java_plugin {
+			name: "baz",
+			processor_class: "com.baz",
+		}
+	`)
+
+	// Any exported plugin that generates API disables turbine, not only the last one listed.
+	foo := ctx.ModuleForTests("foo", "android_common").Module()
+	fooInfo, _ := android.SingletonModuleProvider(ctx, foo, JavaInfoProvider)
+	android.AssertBoolEquals(t, "ExportedPluginDisableTurbine", true, fooInfo.ExportedPluginDisableTurbine)
+}
+
+func TestPluginGeneratesApiWhenOption(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			exported_plugins: ["bar", "baz"],
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			generates_api_when_option: "com.bar.api",
+		}
+
+		java_plugin {
+			name: "baz",
+			generates_api_when_option: "com.baz.api",
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common").Module()
+	fooInfo, _ := android.SingletonModuleProvider(ctx, foo, JavaInfoProvider)
+	android.AssertBoolEquals(t, "ExportedPluginDisableTurbine", false, fooInfo.ExportedPluginDisableTurbine)
+	android.AssertDeepEquals(t, "ExportedPluginGeneratesApiOptions",
+		map[string]string{"com.bar": "com.bar.api", "baz": "com.baz.api"}, fooInfo.ExportedPluginGeneratesApiOptions)
+
+	testJavaError(t, `generates_api_when_option: cannot be set together with generates_api`, `
+		java_plugin {
+			name: "bar",
+			generates_api: true,
+			generates_api_when_option: "com.bar.api",
+		}
+	`)
+}
+
+func TestProcessorClasspath(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			plugins: ["bar"],
+			processor_classpath: ["baz"],
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			srcs: ["b.java"],
+		}
+
+		java_library_host {
+			name: "baz",
+			srcs: ["c.java"],
+		}
+	`)
+
+	buildOS := ctx.Config().BuildOS.String()
+
+	javac := ctx.ModuleForTests("foo", "android_common").Rule("javac")
+
+	bar := ctx.ModuleForTests("bar", buildOS+"_common").Rule("javac").Output.String()
+	baz := ctx.ModuleForTests("baz", buildOS+"_common").Rule("javac").Output.String()
+
+	if javac.Args["processorpath"] != "-processorpath "+bar+":"+baz {
+		t.Errorf("foo processorpath %q != '-processorpath %s:%s'", javac.Args["processorpath"], bar, baz)
+	}
+
+	if strings.Contains(javac.Args["classpath"], baz) {
+		t.Errorf("foo classpath %q should not contain %q", javac.Args["classpath"], baz)
+	}
+}
+
+func TestProcessorClasspathAlsoInLibs(t *testing.T) {
+	testJavaError(t, `processor_classpath: "baz" cannot also be listed in libs or static_libs`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["baz"],
+			processor_classpath: ["baz"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			host_supported: true,
+		}
+	`)
+}
@@ -1409,6 +1409,17 @@ type SdkLibrary struct {
 
 var _ SdkLibraryDependency = (*SdkLibrary)(nil)
 
+var _ declaredUsesLibrary = (*SdkLibrary)(nil)
+
+// declaredUsesLibraryName returns the name of the <library> in the permissions file of a shared
+// library.
+func (module *SdkLibrary) declaredUsesLibraryName() string {
+	if !module.sharedLibrary() {
+		return ""
+	}
+	return module.BaseModuleName()
+}
+
 func (module *SdkLibrary) generateTestAndSystemScopesByDefault() bool {
 	return module.sdkLibraryProperties.Generate_system_and_test_apis
 }
@@ -2120,15 +2131,15 @@ func (module *SdkLibrary) createStubsSourcesAndApi(mctx android.DefaultableHookC
 
 func (module *SdkLibrary) createApiLibrary(mctx android.DefaultableHookContext, apiScope *apiScope, alternativeFullApiSurfaceStub string) {
 	props := struct {
-		Name                  *string
-		Visibility            []string
-		Api_contributions     []string
-		Libs                  []string
-		Static_libs           []string
-		Full_api_surface_stub *string
-		System_modules        *string
-		Enable_validation     *bool
-		Stubs_type            *string
+		Name                   *string
+		Visibility             []string
+		Api_contributions      []string
+		Libs                   []string
+		Static_libs            []string
+		Full_api_surface_stubs []string
+		System_modules         *string
+		Enable_validation      *bool
+		Stubs_type             *string
 	}{}
 
 	props.Name = proptools.StringPtr(module.apiLibraryModuleName(apiScope))
@@ -2157,15 +2168,15 @@ func (module *SdkLibrary) createApiLibrary(mctx android.DefaultableHookContext,
 	props.Libs = append(props.Libs, module.scopeToProperties[apiScope].Libs...)
 	props.Libs = append(props.Libs, "stub-annotations")
 	props.Static_libs = module.sdkLibraryProperties.Stub_only_static_libs
-	props.Full_api_surface_stub = proptools.StringPtr(apiScope.kind.DefaultJavaLibraryName())
+	props.Full_api_surface_stubs = []string{apiScope.kind.DefaultJavaLibraryName()}
 	if alternativeFullApiSurfaceStub != "" {
-		props.Full_api_surface_stub = proptools.StringPtr(alternativeFullApiSurfaceStub)
+		props.Full_api_surface_stubs = []string{alternativeFullApiSurfaceStub}
 	}
 
 	// android_module_lib_stubs_current.from-text only comprises api contributions from art, conscrypt and i18n.
 	// Thus, replace with android_module_lib_stubs_current_full.from-text, which comprises every api domains.
 	if apiScope.kind == android.SdkModule {
-		props.Full_api_surface_stub = proptools.StringPtr(apiScope.kind.DefaultJavaLibraryName() + "_full.from-text")
+		props.Full_api_surface_stubs = []string{apiScope.kind.DefaultJavaLibraryName() + "_full.from-text"}
 	}
 
 	// java_sdk_library modules that set sdk_version as none does not depend on other api
@@ -2173,7 +2184,7 @@ func (module *SdkLibrary) createApiLibrary(mctx android.DefaultableHookContext,
 	// full_api_surface_stubs but create and compile stubs by the java_api_library module
 	// itself.
 	if module.SdkVersion(mctx).Kind == android.SdkNone {
-		props.Full_api_surface_stub = nil
+		props.Full_api_surface_stubs = nil
 	}
 
 	props.System_modules = module.deviceProperties.System_modules
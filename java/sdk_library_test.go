@@ -1639,7 +1639,7 @@ func TestJavaSdkLibrary_ApiLibrary(t *testing.T) {
 	for _, c := range testCases {
 		m := result.ModuleForTests(c.scope.apiLibraryModuleName("foo"), "android_common").Module().(*ApiLibrary)
 		android.AssertArrayString(t, "Module expected to contain api contributions", c.apiContributions, m.properties.Api_contributions)
-		android.AssertStringEquals(t, "Module expected to contain full api surface api library", c.fullApiSurfaceStub, *m.properties.Full_api_surface_stub)
+		android.AssertArrayString(t, "Module expected to contain full api surface api library", []string{c.fullApiSurfaceStub}, m.properties.Full_api_surface_stubs)
 	}
 }
 
@@ -309,6 +309,17 @@ func TestClasspath(t *testing.T) {
 			java9classpath: []string{"prebuilts/sdk/current/module-lib/android.jar", "prebuilts/sdk/tools/core-lambda-stubs.jar"},
 			aidl:           "-pprebuilts/sdk/current/public/framework.aidl",
 		},
+		{
+			// Test case only applies when Always_use_prebuilt_sdks=false (the default).
+			forAlwaysUsePrebuiltSdks: proptools.BoolPtr(false),
+
+			name:           "module-lib_current",
+			properties:     `sdk_version: "module-lib_current",`,
+			bootclasspath:  []string{"android_module_lib_stubs_current", "core-lambda-stubs"},
+			system:         "core-module-lib-stubs-system-modules",
+			java9classpath: []string{"android_module_lib_stubs_current"},
+			aidl:           "-pout/soong/framework_non_updatable.aidl",
+		},
 		{
 			name:           "module_30",
 			properties:     `sdk_version: "module_30",`,
@@ -0,0 +1,101 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/tradefed"
+)
+
+// This singleton lists the test config of every java_test and java_test_host variant in
+// $OUT/soong/java_test_index.txt, so that test discovery tools can find the configs without
+// loading each module.  Each line has the module name, the variant, whether the variant runs on
+// the host or on a device, the path of the config and the comma separated test suites.  The index
+// is built by the java_test_index phony target.
+
+func registerTestIndexBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("java_test_index", testIndexSingletonFactory)
+}
+
+func testIndexSingletonFactory() android.Singleton {
+	return &testIndexSingleton{}
+}
+
+type testIndexSingleton struct{}
+
+const testIndexFileName = "java_test_index.txt"
+
+type testIndexEntry struct {
+	name       string
+	variant    string
+	host       bool
+	testConfig android.Path
+	suites     []string
+}
+
+func (e testIndexEntry) String() string {
+	designation := "device"
+	if e.host {
+		designation = "host"
+	}
+	return fmt.Sprintf("%s %s %s %s %s", e.name, e.variant, designation, e.testConfig.String(),
+		strings.Join(e.suites, ","))
+}
+
+func (s *testIndexSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var entries []testIndexEntry
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		switch module.(type) {
+		case *Test, *TestHost:
+		default:
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, tradefed.BaseTestProviderKey)
+		if !ok || info.TestConfig == nil {
+			return
+		}
+		entries = append(entries, testIndexEntry{
+			name:       ctx.ModuleName(module),
+			variant:    ctx.ModuleSubDir(module),
+			host:       info.IsHost,
+			testConfig: info.TestConfig,
+			suites:     android.SortedUniqueStrings(info.TestSuites),
+		})
+	})
+
+	// The index must not depend on the order the modules are visited in.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].variant < entries[j].variant
+	})
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+
+	indexPath := android.PathForOutput(ctx, testIndexFileName)
+	android.WriteFileRule(ctx, indexPath, strings.Join(lines, "\n"))
+	ctx.Phony("java_test_index", indexPath)
+}
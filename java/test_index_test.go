@@ -0,0 +1,50 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestTestIndex(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_suites: ["general-tests", "device-tests"],
+		}
+
+		java_test_host {
+			name: "bar",
+			srcs: ["a.java"],
+			test_suites: ["general-tests"],
+		}
+	`)
+
+	fooConfig := result.ModuleForTests("foo", "android_common").Module().(*Test).testConfig
+	buildOS := result.Config.BuildOS.String()
+	barConfig := result.ModuleForTests("bar", buildOS+"_common").Module().(*TestHost).testConfig
+
+	index := result.SingletonForTests("java_test_index").Output(testIndexFileName)
+	content := android.ContentFromFileRuleForTests(t, result.TestContext, index)
+
+	android.AssertArrayString(t, "index", []string{
+		"bar " + buildOS + "_common host " + barConfig.String() + " general-tests",
+		"foo android_common device " + fooConfig.String() + " device-tests,general-tests",
+	}, strings.Split(content, "\n"))
+}
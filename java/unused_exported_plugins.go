@@ -0,0 +1,94 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton finds modules that set exported_plugins but have no dependent modules that would
+// run those plugins, and lists them in $OUT/soong/unused_exported_plugins.txt. The check is only
+// enabled when SOONG_WARN_UNUSED_EXPORTED_PLUGINS=true is set in the environment; building the
+// unused-exported-plugins goal prints a warning for each listed module.
+
+func registerUnusedExportedPluginsBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("unused_exported_plugins", unusedExportedPluginsSingletonFactory)
+}
+
+func unusedExportedPluginsSingletonFactory() android.Singleton {
+	return &unusedExportedPluginsSingleton{}
+}
+
+type unusedExportedPluginsSingleton struct{}
+
+const unusedExportedPluginsFileName = "unused_exported_plugins.txt"
+
+var unusedExportedPluginsWarning = pctx.AndroidStaticRule("unusedExportedPluginsWarning",
+	blueprint.RuleParams{
+		Command: `sed 's/^/warning: exported_plugins of module /; s/$$/ are not used by any dependent module/' $in >&2 && ` +
+			`touch $out`,
+	})
+
+func (s *unusedExportedPluginsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("SOONG_WARN_UNUSED_EXPORTED_PLUGINS") {
+		return
+	}
+
+	exporters := make(map[string]bool)
+	consumed := make(map[string]bool)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, JavaInfoProvider)
+		if !ok {
+			return
+		}
+		if len(info.ExportedPlugins) > 0 {
+			exporters[ctx.ModuleName(module)] = true
+		}
+		// Every java module that depends on a module with exported plugins runs them through
+		// collectDeps, so any dependency from a java module counts as a consumer.
+		ctx.VisitDirectDeps(module, func(dep android.Module) {
+			if depInfo, ok := android.SingletonModuleProvider(ctx, dep, JavaInfoProvider); ok && len(depInfo.ExportedPlugins) > 0 {
+				consumed[ctx.ModuleName(dep)] = true
+			}
+		})
+	})
+
+	var unused []string
+	for name := range exporters {
+		if !consumed[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+
+	reportPath := android.PathForOutput(ctx, unusedExportedPluginsFileName)
+	android.WriteFileRule(ctx, reportPath, strings.Join(unused, "\n"))
+
+	stampPath := android.PathForOutput(ctx, "unused_exported_plugins.stamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   unusedExportedPluginsWarning,
+		Input:  reportPath,
+		Output: stampPath,
+	})
+	ctx.Phony("unused-exported-plugins", stampPath)
+}
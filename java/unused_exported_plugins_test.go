@@ -0,0 +1,69 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+const unusedExportedPluginsBp = `
+	java_plugin {
+		name: "plugin",
+		srcs: ["a.java"],
+		processor_class: "com.android.TestPlugin",
+	}
+
+	java_library {
+		name: "used_exporter",
+		srcs: ["a.java"],
+		exported_plugins: ["plugin"],
+	}
+
+	java_library {
+		name: "unused_exporter",
+		srcs: ["a.java"],
+		exported_plugins: ["plugin"],
+	}
+
+	java_library {
+		name: "consumer",
+		srcs: ["a.java"],
+		libs: ["used_exporter"],
+	}
+`
+
+func TestUnusedExportedPlugins(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_WARN_UNUSED_EXPORTED_PLUGINS": "true",
+		}),
+	).RunTestWithBp(t, unusedExportedPluginsBp)
+
+	report := result.SingletonForTests("unused_exported_plugins").Output(unusedExportedPluginsFileName)
+	android.AssertStringEquals(t, "unused exported plugins", "unused_exporter",
+		android.ContentFromFileRuleForTests(t, result.TestContext, report))
+}
+
+func TestUnusedExportedPluginsDisabledByDefault(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).RunTestWithBp(t, unusedExportedPluginsBp)
+
+	report := result.SingletonForTests("unused_exported_plugins").MaybeOutput(unusedExportedPluginsFileName)
+	android.AssertBoolEquals(t, "report generated", false, report.Rule != nil)
+}
@@ -16,181 +16,44 @@ package sdk
 
 import (
 	"fmt"
-	"math"
 	"reflect"
-	"strings"
+
+	"android/soong/android"
 )
 
 // Supports customizing sdk snapshot output based on target build release.
 
 // buildRelease represents the version of a build system used to create a specific release.
-//
-// The name of the release, is the same as the code for the dessert release, e.g. S, Tiramisu, etc.
-type buildRelease struct {
-	// The name of the release, e.g. S, Tiramisu, etc.
-	name string
-
-	// The index of this structure within the dessertBuildReleases list.
-	//
-	// The buildReleaseCurrent does not appear in the dessertBuildReleases list as it has an ordinal value
-	// that is larger than the size of the dessertBuildReleases.
-	ordinal int
-}
-
-func (br *buildRelease) EarlierThan(other *buildRelease) bool {
-	return br.ordinal < other.ordinal
-}
-
-// String returns the name of the build release.
-func (br *buildRelease) String() string {
-	return br.name
-}
+type buildRelease = android.BuildRelease
 
 // buildReleaseSet represents a set of buildRelease objects.
-type buildReleaseSet struct {
-	// Set of *buildRelease represented as a map from *buildRelease to struct{}.
-	contents map[*buildRelease]struct{}
-}
-
-// addItem adds a build release to the set.
-func (s *buildReleaseSet) addItem(release *buildRelease) {
-	s.contents[release] = struct{}{}
-}
-
-// addRange adds all the build releases from start (inclusive) to end (inclusive).
-func (s *buildReleaseSet) addRange(start *buildRelease, end *buildRelease) {
-	for i := start.ordinal; i <= end.ordinal; i += 1 {
-		s.addItem(dessertBuildReleases[i])
-	}
-}
-
-// contains returns true if the set contains the specified build release.
-func (s *buildReleaseSet) contains(release *buildRelease) bool {
-	_, ok := s.contents[release]
-	return ok
-}
-
-// String returns a string representation of the set, sorted from earliest to latest release.
-func (s *buildReleaseSet) String() string {
-	list := []string{}
-	addRelease := func(release *buildRelease) {
-		if _, ok := s.contents[release]; ok {
-			list = append(list, release.name)
-		}
-	}
-	// Add the names of the build releases in this set in the order in which they were created.
-	for _, release := range dessertBuildReleases {
-		addRelease(release)
-	}
-	// Always add "current" to the list of names last if it is present in the set.
-	addRelease(buildReleaseCurrent)
-	return fmt.Sprintf("[%s]", strings.Join(list, ","))
-}
+type buildReleaseSet = android.BuildReleaseSet
 
 var (
-	// nameToBuildRelease contains a map from name to build release.
-	nameToBuildRelease = map[string]*buildRelease{}
-
-	// dessertBuildReleases lists all the available dessert build releases, i.e. excluding current.
-	dessertBuildReleases = []*buildRelease{}
-
 	// allBuildReleaseSet is the set of all build releases.
-	allBuildReleaseSet = &buildReleaseSet{contents: map[*buildRelease]struct{}{}}
-
-	// Add the dessert build releases from oldest to newest.
-	buildReleaseS = initBuildRelease("S")
-	buildReleaseT = initBuildRelease("Tiramisu")
-	buildReleaseU = initBuildRelease("UpsideDownCake")
+	allBuildReleaseSet = android.AllBuildReleaseSet()
 
-	// Add the current build release which is always treated as being more recent than any other
-	// build release, including those added in tests.
-	buildReleaseCurrent = initBuildRelease("current")
+	buildReleaseS       = android.BuildReleaseS
+	buildReleaseT       = android.BuildReleaseT
+	buildReleaseU       = android.BuildReleaseU
+	buildReleaseCurrent = android.BuildReleaseCurrent
 )
 
 // initBuildRelease creates a new build release with the specified name.
 func initBuildRelease(name string) *buildRelease {
-	ordinal := len(dessertBuildReleases)
-	if name == "current" {
-		// The current build release is more recent than all other build releases, including those
-		// created in tests so use the max int value. It cannot just rely on being created after all
-		// the other build releases as some are created in tests which run after the current build
-		// release has been created.
-		ordinal = math.MaxInt
-	}
-	release := &buildRelease{name: name, ordinal: ordinal}
-	nameToBuildRelease[name] = release
-	allBuildReleaseSet.addItem(release)
-	if name != "current" {
-		// As the current build release has an ordinal value that does not correspond to its position
-		// in the dessertBuildReleases list do not add it to the list.
-		dessertBuildReleases = append(dessertBuildReleases, release)
-	}
-	return release
-}
-
-// latestDessertBuildRelease returns the latest dessert release build name, i.e. the last dessert
-// release added to the list, which does not include current.
-func latestDessertBuildRelease() *buildRelease {
-	return dessertBuildReleases[len(dessertBuildReleases)-1]
+	return android.InitBuildRelease(name)
 }
 
 // nameToRelease maps from build release name to the corresponding build release (if it exists) or
 // the error if it does not.
 func nameToRelease(name string) (*buildRelease, error) {
-	if r, ok := nameToBuildRelease[name]; ok {
-		return r, nil
-	}
-
-	return nil, fmt.Errorf("unknown release %q, expected one of %s", name, allBuildReleaseSet)
+	return android.NameToBuildRelease(name)
 }
 
 // parseBuildReleaseSet parses a build release set string specification into a build release set.
-//
-// The specification consists of one of the following:
-// * a single build release name, e.g. S, T, etc.
-// * a closed range (inclusive to inclusive), e.g. S-T
-// * an open range, e.g. T+.
-//
-// This returns the set if the specification was valid or an error.
+// See android.ParseBuildReleaseSet for the format of the specification.
 func parseBuildReleaseSet(specification string) (*buildReleaseSet, error) {
-	set := &buildReleaseSet{contents: map[*buildRelease]struct{}{}}
-
-	if strings.HasSuffix(specification, "+") {
-		rangeStart := strings.TrimSuffix(specification, "+")
-		start, err := nameToRelease(rangeStart)
-		if err != nil {
-			return nil, err
-		}
-		end := latestDessertBuildRelease()
-		set.addRange(start, end)
-		// An open-ended range always includes the current release.
-		set.addItem(buildReleaseCurrent)
-	} else if strings.Contains(specification, "-") {
-		limits := strings.SplitN(specification, "-", 2)
-		start, err := nameToRelease(limits[0])
-		if err != nil {
-			return nil, err
-		}
-
-		end, err := nameToRelease(limits[1])
-		if err != nil {
-			return nil, err
-		}
-
-		if start.ordinal > end.ordinal {
-			return nil, fmt.Errorf("invalid closed range, start release %q is later than end release %q", start.name, end.name)
-		}
-
-		set.addRange(start, end)
-	} else {
-		release, err := nameToRelease(specification)
-		if err != nil {
-			return nil, err
-		}
-		set.addItem(release)
-	}
-
-	return set, nil
+	return android.ParseBuildReleaseSet(specification)
 }
 
 // Given a set of properties (struct value), set the value of a field within that struct (or one of
@@ -412,7 +275,7 @@ func newPropertyPrunerByBuildRelease(propertiesStruct interface{}, targetBuildRe
 			}
 
 			// If the field does not support tha target release then prune it.
-			return !set.contains(targetBuildRelease)
+			return !set.Contains(targetBuildRelease)
 
 		} else {
 			// Any untagged fields are assumed to be supported by all build releases so should never be
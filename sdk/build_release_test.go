@@ -93,10 +93,10 @@ func TestParseBuildReleaseSet(t *testing.T) {
 func TestBuildReleaseSetContains(t *testing.T) {
 	t.Run("contains", func(t *testing.T) {
 		set, _ := parseBuildReleaseSet("F1-F2")
-		android.AssertBoolEquals(t, "set contains F1", true, set.contains(buildReleaseFuture1))
-		android.AssertBoolEquals(t, "set does not contain S", false, set.contains(buildReleaseS))
-		android.AssertBoolEquals(t, "set contains F2", true, set.contains(buildReleaseFuture2))
-		android.AssertBoolEquals(t, "set does not contain T", false, set.contains(buildReleaseT))
+		android.AssertBoolEquals(t, "set contains F1", true, set.Contains(buildReleaseFuture1))
+		android.AssertBoolEquals(t, "set does not contain S", false, set.Contains(buildReleaseS))
+		android.AssertBoolEquals(t, "set contains F2", true, set.Contains(buildReleaseFuture2))
+		android.AssertBoolEquals(t, "set does not contain T", false, set.Contains(buildReleaseT))
 	})
 }
 
@@ -250,7 +250,7 @@ func isMemberTypeSupportedByTargetBuildRelease(memberType android.SdkMemberType,
 		panic(fmt.Errorf("member type %s has invalid supported build releases %q: %s",
 			memberType.SdkPropertyName(), supportedBuildReleases, err))
 	}
-	if !set.contains(targetBuildRelease) {
+	if !set.Contains(targetBuildRelease) {
 		supportedByTargetBuildRelease = false
 	}
 	return supportedByTargetBuildRelease
@@ -77,9 +77,10 @@ func (o Option) Config() string {
 	return fmt.Sprintf(`<option name="%s" value="%s" />`, o.Name, o.Value)
 }
 
-// It can be a template of object or target_preparer.
+// It can be a template of object, target_preparer or result_reporter.
 type Object struct {
-	// Set it as a target_preparer if object type == "target_preparer".
+	// Set it as a target_preparer if object type == "target_preparer", or as a result_reporter if
+	// object type == "result_reporter".
 	Type    string
 	Class   string
 	Options []Option
@@ -99,8 +100,8 @@ func (ob Object) Config() string {
 		optionDelimiter := fmt.Sprintf("\\n%s%s", test_xml_indent, test_xml_indent)
 		options = optionDelimiter + strings.Join(optionStrings, optionDelimiter)
 	}
-	if ob.Type == "target_preparer" {
-		return fmt.Sprintf(`<target_preparer class="%s">%s\n%s</target_preparer>`, ob.Class, options, test_xml_indent)
+	if ob.Type == "target_preparer" || ob.Type == "result_reporter" {
+		return fmt.Sprintf(`<%s class="%s">%s\n%s</%s>`, ob.Type, ob.Class, options, test_xml_indent, ob.Type)
 	} else {
 		return fmt.Sprintf(`<object type="%s" class="%s">%s\n%s</object>`, ob.Type, ob.Class, options, test_xml_indent)
 	}
@@ -143,6 +144,27 @@ func autogenTemplate(ctx android.ModuleContext, name string, output android.Writ
 	})
 }
 
+var mergeTestConfig = pctx.StaticRule("mergeTestConfig", blueprint.RuleParams{
+	Command:     "${MergeTestConfigCmd} -i $in -m $merge -o $out",
+	CommandDeps: []string{"${MergeTestConfigCmd}", "$merge"},
+}, "merge")
+
+// autogenMergedTemplate generates the test config from template into output, merging in the
+// <option> and <target_preparer> elements of mergeFile.
+func autogenMergedTemplate(ctx android.ModuleContext, name string, output android.WritablePath, template string, configs []Config, testRunnerConfigs []Option, outputFileName string, testInstallBase string, mergeFile android.Path) {
+	autogenPath := android.PathForModuleOut(ctx, "autogen", output.Base())
+	autogenTemplate(ctx, name, autogenPath, template, configs, testRunnerConfigs, outputFileName, testInstallBase)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        mergeTestConfig,
+		Description: "merge test config",
+		Input:       autogenPath,
+		Output:      output,
+		Args: map[string]string{
+			"merge": mergeFile.String(),
+		},
+	})
+}
+
 // AutoGenTestConfigOptions is used so that we can supply many optional
 // arguments to the AutoGenTestConfig function.
 type AutoGenTestConfigOptions struct {
@@ -150,6 +172,7 @@ type AutoGenTestConfigOptions struct {
 	OutputFileName          string
 	TestConfigProp          *string
 	TestConfigTemplateProp  *string
+	TestConfigMergeProp     *string
 	TestSuites              []string
 	Config                  []Config
 	OptionsForAutogenerated []Option
@@ -172,23 +195,29 @@ func AutoGenTestConfig(ctx android.ModuleContext, options AutoGenTestConfigOptio
 		name = ctx.ModuleName()
 	}
 	path, autogenPath := testConfigPath(ctx, options.TestConfigProp, options.TestSuites, options.AutoGenConfig, options.TestConfigTemplateProp)
+	mergePath := ctx.ExpandOptionalSource(options.TestConfigMergeProp, "test_config_merge")
 	if autogenPath != nil {
+		var template string
 		templatePath := getTestConfigTemplate(ctx, options.TestConfigTemplateProp)
 		if templatePath.Valid() {
-			autogenTemplate(ctx, name, autogenPath, templatePath.String(), configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase)
+			template = templatePath.String()
+		} else if ctx.Device() {
+			template = options.DeviceTemplate
+		} else if Bool(options.UnitTest) {
+			template = options.HostUnitTestTemplate
 		} else {
-			if ctx.Device() {
-				autogenTemplate(ctx, name, autogenPath, options.DeviceTemplate, configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase)
-			} else {
-				if Bool(options.UnitTest) {
-					autogenTemplate(ctx, name, autogenPath, options.HostUnitTestTemplate, configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase)
-				} else {
-					autogenTemplate(ctx, name, autogenPath, options.HostTemplate, configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase)
-				}
-			}
+			template = options.HostTemplate
+		}
+		if mergePath.Valid() {
+			autogenMergedTemplate(ctx, name, autogenPath, template, configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase, mergePath.Path())
+		} else {
+			autogenTemplate(ctx, name, autogenPath, template, configs, options.TestRunnerOptions, options.OutputFileName, options.TestInstallBase)
 		}
 		return autogenPath
 	}
+	if mergePath.Valid() {
+		ctx.PropertyErrorf("test_config_merge", "can only be used with an autogenerated test config")
+	}
 	if len(options.OptionsForAutogenerated) > 0 {
 		ctx.ModuleErrorf("Extra tradefed configurations were provided for an autogenerated xml file, but the autogenerated xml file was not used.")
 	}
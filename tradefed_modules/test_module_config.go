@@ -289,7 +289,13 @@ func (m *testModuleConfigHostModule) GenerateAndroidBuildActions(ctx android.Mod
 // Returns false on errors and the context is updated with an error indicating the baseType expected.
 func (m *testModuleConfigModule) validateBase(ctx android.ModuleContext, depTag *dependencyTag, baseType string, baseShouldBeHost bool) {
 	ctx.VisitDirectDepsWithTag(*depTag, func(dep android.Module) {
-		if provider, ok := android.OtherModuleProvider(ctx, dep, tradefed.BaseTestProviderKey); ok {
+		provider, ok := android.OtherModuleProvider(ctx, dep, tradefed.BaseTestProviderKey)
+		// Device java_test modules also provide the base test data, but only android_test
+		// modules have the apk that is linked into the derived module.
+		if ok && !provider.IsHost && (provider.OutputFile == nil || provider.OutputFile.Ext() != ".apk") {
+			ok = false
+		}
+		if ok {
 			if baseShouldBeHost == provider.IsHost {
 				m.provider = provider
 			} else {
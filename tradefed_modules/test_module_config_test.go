@@ -164,6 +164,28 @@ func TestModuleConfigBadBaseShouldFailWithGeneralMessage(t *testing.T) {
 		RunTestWithBp(t, badBp)
 }
 
+func TestModuleConfigJavaTestBaseShouldFailWithGeneralMessage(t *testing.T) {
+	badBp := `
+		java_test {
+			name: "base",
+			srcs: ["a.java"],
+		}
+
+		test_module_config {
+			name: "derived_test",
+			base: "base",
+			exclude_filters: ["android.test.example.devcodelab.DevCodelabTest#testHelloFail"],
+			test_suites: ["general-tests"],
+		}`
+
+	android.GroupFixturePreparers(
+		java.PrepareForTestWithJavaDefaultModules,
+		android.FixtureRegisterWithContext(RegisterTestModuleConfigBuildComponents),
+	).ExtendWithErrorHandler(
+		android.FixtureExpectsOneErrorPattern("'base' module used as base but it is not a 'android_test' module.")).
+		RunTestWithBp(t, badBp)
+}
+
 func TestModuleConfigNoBaseShouldFail(t *testing.T) {
 	badBp := `
 		java_library {